@@ -5,15 +5,19 @@ import (
 	"net/http"
 	"os"
 	"regexp"
+	"strconv"
 	"time"
 	"trraformapi/internal/api"
 	"trraformapi/internal/api/auth"
+	"trraformapi/internal/api/geometry"
 	"trraformapi/internal/api/leaderboard"
+	"trraformapi/internal/api/market"
 	"trraformapi/internal/api/payment"
 	"trraformapi/internal/api/plot"
 	"trraformapi/internal/api/user"
 	"trraformapi/pkg/config"
 	plotutils "trraformapi/pkg/plot_utils"
+	"trraformapi/pkg/render"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	awsconfig "github.com/aws/aws-sdk-go-v2/config"
@@ -35,6 +39,8 @@ import (
 
 func main() {
 
+	config.RequireEnv("MONGO_PASSWORD", "REDIS_PASSWORD", "CF_R2_ACCESS_KEY", "CF_R2_SECRET_KEY", "CF_R2_API_ENDPOINT", "CF_API_TOKEN", "CF_TURNSTILE_SECRET_KEY", "JWT_SECRET", "STRIPE_SECRET_KEY", "STRIPE_WEBHOOK_SECRET")
+
 	ctx := context.Background()
 	h := &api.Handler{}
 
@@ -69,6 +75,14 @@ func main() {
 	h.Validate.RegisterValidation("maxgraphemes", plotutils.MaxGraphemesValidator)
 	h.Validate.RegisterValidation("builddata", plotutils.BuildDataValidator)
 
+	// plot id lists are limited to what fits in one checkout
+	h.Validate.RegisterAlias("cartsize", "max="+strconv.Itoa(config.MAX_CART_SIZE))
+
+	// without the client palette thumbnails are skipped and exports and imports answer 503
+	if err := render.LoadPaletteFile(render.PALETTE_FILE); err != nil {
+		logger.Warn("Color palette not loaded, thumbnails, exports and imports are disabled", zap.Error(err))
+	}
+
 	h.HttpCli = &http.Client{
 		Timeout: 30 * time.Second,
 	}
@@ -89,6 +103,18 @@ func main() {
 		panic(err)
 	}
 	h.MongoDB = mongoCli.Database(config.MONGO_DB)
+	if err := payment.EnsureStripeEventIndexes(h.MongoDB, ctx); err != nil {
+		panic(err)
+	}
+	if err := plotutils.EnsurePlotSearchIndex(h.MongoDB, ctx); err != nil {
+		panic(err)
+	}
+	if err := plotutils.EnsureTransferIndexes(h.MongoDB, ctx); err != nil {
+		panic(err)
+	}
+	if err := market.EnsureListingIndexes(h.MongoDB, ctx); err != nil {
+		panic(err)
+	}
 
 	// init redis
 	h.RedisCli = redis.NewClient(&redis.Options{
@@ -127,10 +153,10 @@ func main() {
 	router.Use(cors.Handler(cors.Options{
 		AllowedOrigins: []string{config.ORIGIN},
 		AllowedMethods: []string{"GET", "POST", "OPTIONS"},
-		AllowedHeaders: []string{"Content-Type", "Authorization"},
+		AllowedHeaders: []string{"Content-Type", "Content-Encoding", "Authorization", "If-Match"},
+		ExposedHeaders: []string{"ETag"},
 	}))
 	router.Use(middleware.Recoverer)
-	router.Use(middleware.RequestSize(1 << 20))
 	router.Use(middleware.Timeout(config.API_TIMEOUT))
 
 	authH := &auth.Handler{Handler: h}
@@ -138,32 +164,83 @@ func main() {
 	plotH := &plot.Handler{Handler: h}
 	leaderboardH := &leaderboard.Handler{Handler: h}
 	paymentsH := &payment.Handler{Handler: h}
+	marketH := &market.Handler{Handler: h}
+	geometryH := &geometry.Handler{Handler: h}
+
+	// binary plot uploads and imports have their own size limit
+	router.Post("/plot/upload", h.AuthMiddleware(plotH.UploadPlot))
+	router.Post("/plot/import", h.AuthMiddleware(plotH.ImportPlot))
+
+	router.Group(func(router chi.Router) {
+
+		router.Use(middleware.RequestSize(1 << 20))
+
+		// auth endpoints (add captcha)
+		router.Post("/auth/create-account", authH.CreateAccount)
+		router.Post("/auth/password-login", authH.PasswordLogin)
+		router.Post("/auth/google-login", authH.GoogleLogin)
+		router.Post("/auth/send-verification-code", authH.SendVerificationCode)
+		router.Post("/auth/verify-email", authH.VerifyEmail)
+		router.Post("/auth/reset-password", authH.ResetPassword)
+
+		// user endpoints
+		router.Get("/user", userH.GetUserData)
+		router.Get("/user/{username}", userH.GetUserProfile)
+		router.Post("/user/change-username", h.AuthMiddleware(userH.ChangeUsername))
+		router.Post("/user/privacy", h.AuthMiddleware(userH.UpdatePrivacy))
+
+		// geometry endpoints
+		router.Get("/geometry", geometryH.GetGeometryVersion)
+		router.Get("/geometry/plot/{id}", geometryH.GetPlotGeometry)
+		router.Get("/geometry/chunk/{id}", geometryH.GetChunkGeometry)
+		router.Get("/geometry/within", geometryH.GetPlotsWithin)
+
+		// plot endpoints
+		router.Post("/plot/claim-with-credit", h.AuthMiddleware(plotH.ClaimWithCredit))
+		router.Post("/plot/release", h.AuthMiddleware(plotH.ReleasePlot))
+		router.Post("/plot/update", h.AuthMiddleware(plotH.UpdatePlot))
+		router.Post("/plot/patch", h.AuthMiddleware(plotH.PatchPlot))
+		router.Get("/plot/revision", h.AuthMiddleware(plotH.GetPlotRevision))
+		router.Get("/plot/{id}/export", plotH.ExportPlot)
+		router.Get("/plot/{id}/tree", plotH.GetPlotTree)
+		router.Get("/plot/search", plotH.SearchPlots)
+		router.Post("/plot/availability", plotH.CheckAvailability)
+		router.Get("/plot/open", plotH.GetRandomOpenPlots)
+		router.Get("/plot/open/count", plotH.GetOpenPlotCounts)
+		router.Get("/plot/versions", h.AuthMiddleware(plotH.GetPlotVersions))
+		router.Get("/plot/version", h.AuthMiddleware(plotH.GetPlotVersion))
+		router.Post("/plot/version/restore", h.AuthMiddleware(plotH.RestorePlotVersion))
+		router.Get("/plot/transfers", h.AuthMiddleware(plotH.GetTransfers))
+		router.Post("/plot/transfer", h.AuthMiddleware(plotH.CreateTransfer))
+		router.Post("/plot/transfer/respond", h.AuthMiddleware(plotH.RespondTransfer))
+		router.Post("/plot/transfer/cancel", h.AuthMiddleware(plotH.CancelTransfer))
+		router.Get("/plot/subplot-settings", h.AuthMiddleware(plotH.GetSubplotSettings))
+		router.Post("/plot/subplot-settings", h.AuthMiddleware(plotH.UpdateSubplotSettings))
+		router.Get("/plot/collab", h.AuthMiddleware(plotH.GetCollaborators))
+		router.Get("/plot/collab/invites", h.AuthMiddleware(plotH.GetCollabInvites))
+		router.Post("/plot/collab/invite", h.AuthMiddleware(plotH.InviteCollaborator))
+		router.Post("/plot/collab/respond", h.AuthMiddleware(plotH.RespondCollabInvite))
+		router.Post("/plot/collab/remove", h.AuthMiddleware(plotH.RemoveCollaborator))
+
+		// leaderboard endpoints
+		router.Get("/leaderboard", leaderboardH.GetLeaderboard)
+		router.Post("/leaderboard/vote", leaderboardH.Vote)
+
+		// marketplace endpoints
+		router.Get("/market/listings", marketH.GetListings)
+		router.Get("/market/sales", marketH.GetSales)
+		router.Post("/market/list", h.AuthMiddleware(marketH.CreateListing))
+		router.Post("/market/cancel", h.AuthMiddleware(marketH.CancelListing))
+		router.Post("/market/buy", h.AuthMiddleware(marketH.BuyListing))
+		router.Post("/market/connect", h.AuthMiddleware(marketH.ConnectAccount))
+
+		// payment endpoints
+		router.Get("/payment/portal", h.AuthMiddleware(paymentsH.CreatePortalSession))
+		router.Get("/payment/subscription", h.AuthMiddleware(paymentsH.CreateSubscriptionSession))
+		router.Post("/payment/checkout", h.AuthMiddleware(paymentsH.CreateCheckoutSession))
+		router.Post("/payment/webhook", paymentsH.StripeWebhook)
 
-	// auth endpoints (add captcha)
-	router.Post("/auth/create-account", authH.CreateAccount)
-	router.Post("/auth/password-login", authH.PasswordLogin)
-	router.Post("/auth/google-login", authH.GoogleLogin)
-	router.Post("/auth/send-verification-code", authH.SendVerificationCode)
-	router.Post("/auth/verify-email", authH.VerifyEmail)
-	router.Post("/auth/reset-password", authH.ResetPassword)
-
-	// user endpoints
-	router.Get("/user", userH.GetUserData)
-	router.Post("/user/change-username", h.AuthMiddleware(userH.ChangeUsername))
-
-	// plot endpoints
-	router.Post("/plot/claim-with-credit", h.AuthMiddleware(plotH.ClaimWithCredit))
-	router.Post("/plot/update", h.AuthMiddleware(plotH.UpdatePlot))
-
-	// leaderboard endpoints
-	router.Get("/leaderboard", leaderboardH.GetLeaderboard)
-	router.Post("/leaderboard/vote", leaderboardH.Vote)
-
-	// payment endpoints
-	router.Get("/payment/portal", h.AuthMiddleware(paymentsH.CreatePortalSession))
-	router.Get("/payment/subscription", h.AuthMiddleware(paymentsH.CreateSubscriptionSession))
-	router.Post("/payment/checkout", h.AuthMiddleware(paymentsH.CreateCheckoutSession))
-	router.Post("/payment/webhook", paymentsH.StripeWebhook)
+	})
 
 	logger.Info("Server running on port 8080")
 	http.ListenAndServe(":8080", router)
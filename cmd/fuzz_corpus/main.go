@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"trraformapi/pkg/config"
+	plotutils "trraformapi/pkg/plot_utils"
+	"trraformapi/pkg/utils"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// builds the plotutils.Decode fuzz corpus from real plots, and checks the decoder against it.
+// entries use the go fuzz corpus file format so they can seed a FuzzDecode target directly
+//
+//	go run ./cmd/fuzz_corpus              write seeds from the local default plots
+//	go run ./cmd/fuzz_corpus -r2 50       also sample 50 plots from R2
+//	go run ./cmd/fuzz_corpus -check       decode every entry, fail on panics
+
+func main() {
+
+	dir := flag.String("dir", "pkg/plot_utils/testdata/fuzz/FuzzDecode", "corpus directory")
+	sample := flag.Int("r2", 0, "number of plots to sample from R2")
+	check := flag.Bool("check", false, "decode every corpus entry instead of writing seeds")
+	flag.Parse()
+
+	if *check {
+		if err := checkCorpus(*dir); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	var plots [][]byte
+
+	// default plot is raw build data, framed the same way scripts/upload_default does
+	raw, err := os.ReadFile("static/default_cactus.dat")
+	if err != nil {
+		log.Fatal(err)
+	}
+	buildData, err := utils.BytesToUint16Arr(raw)
+	if err != nil {
+		log.Fatal(err)
+	}
+	plots = append(plots, encodeSeeds(&plotutils.PlotData{BuildData: buildData})...)
+	plots = append(plots, encodeSeeds(&plotutils.PlotData{
+		Name:        "cactus 🌵",
+		Description: "a plot with every field set",
+		Link:        "https://trraform.com",
+		LinkTitle:   "trraform",
+		BuildData:   buildData,
+	})...)
+
+	if *sample > 0 {
+		sampled, err := sampleR2(*sample)
+		if err != nil {
+			log.Fatal(err)
+		}
+		plots = append(plots, sampled...)
+	}
+
+	var seeds [][]byte
+	for _, p := range plots {
+		seeds = append(seeds, p)
+		seeds = append(seeds, mutations(p)...)
+	}
+
+	if err := os.MkdirAll(*dir, 0o755); err != nil {
+		log.Fatal(err)
+	}
+	for _, seed := range seeds {
+		name := fmt.Sprintf("%x", sha256.Sum256(seed))[:16]
+		content := "go test fuzz v1\n[]byte(" + strconv.Quote(string(seed)) + ")\n"
+		if err := os.WriteFile(filepath.Join(*dir, name), []byte(content), 0o644); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	fmt.Printf("Wrote %d corpus entries to %s\n", len(seeds), *dir)
+
+}
+
+// every version of a plot
+func encodeSeeds(plotData *plotutils.PlotData) [][]byte {
+
+	var seeds [][]byte
+	for ver := 0; ver <= config.PLOT_DATA_VERSION; ver++ {
+		data, err := plotData.EncodeVersion(ver)
+		if err != nil {
+			log.Fatal(err)
+		}
+		seeds = append(seeds, data)
+	}
+
+	return seeds
+
+}
+
+// malformed variants of a valid plot, one for each class of decode error
+func mutations(data []byte) [][]byte {
+
+	jsonLen := int(binary.LittleEndian.Uint32(data))
+	buildAt := 4 + jsonLen
+	put32 := func(at int, v uint32) []byte {
+		m := append([]byte{}, data...)
+		binary.LittleEndian.PutUint32(m[at:], v)
+		return m
+	}
+
+	muts := [][]byte{
+		{},
+		data[:3],                              // bad prefix
+		data[:buildAt-1],                      // truncated json part
+		data[:buildAt+2],                      // bad build prefix
+		data[:len(data)-1],                    // truncated build part
+		append(append([]byte{}, data...), 0),  // trailing data
+		put32(0, config.PLOT_JSON_MAX_SIZE+1), // oversize json
+		put32(0, 0xffffffff),                  // json length past end
+		put32(buildAt, 0xffffffff),            // build length past end
+		put32(buildAt, uint32(len(data)-buildAt-4-1)), // odd build length
+	}
+
+	// unknown version and broken json, same lengths
+	if i := bytes.Index(data[:buildAt], []byte(`"ver":`)); i >= 0 {
+		m := append([]byte{}, data...)
+		m[i+len(`"ver":`)] = '9'
+		muts = append(muts, m)
+	}
+	if jsonLen > 0 {
+		m := append([]byte{}, data...)
+		m[4] = '['
+		muts = append(muts, m)
+	}
+
+	// flip the low bit of a value after the header, turning a write into a repeat or back
+	if len(data) > buildAt+4+6 {
+		m := append([]byte{}, data...)
+		m[buildAt+4+4] ^= 1
+		muts = append(muts, m)
+	}
+
+	return muts
+
+}
+
+func sampleR2(n int) ([][]byte, error) {
+
+	config.RequireEnv("CF_R2_ACCESS_KEY", "CF_R2_SECRET_KEY", "CF_R2_API_ENDPOINT")
+
+	ctx := context.Background()
+
+	cred := credentials.NewStaticCredentialsProvider(
+		config.ENV.CF_R2_ACCESS_KEY,
+		config.ENV.CF_R2_SECRET_KEY,
+		"",
+	)
+	r2Cli := s3.New(s3.Options{
+		Credentials:  cred,
+		BaseEndpoint: aws.String(os.Getenv("CF_R2_API_ENDPOINT")),
+		UsePathStyle: true,
+		Region:       "auto",
+	})
+
+	list, err := r2Cli.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(config.CF_PLOT_BUCKET),
+		MaxKeys: aws.Int32(int32(n)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var plots [][]byte
+	for _, obj := range list.Contents {
+		key := aws.ToString(obj.Key)
+		if !strings.HasSuffix(key, ".dat") {
+			continue
+		}
+		data, _, err := utils.GetObjectR2(r2Cli, ctx, config.CF_PLOT_BUCKET, key)
+		if err != nil {
+			return nil, err
+		}
+		plots = append(plots, data)
+	}
+
+	return plots, nil
+
+}
+
+func checkCorpus(dir string) error {
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	panics := 0
+	counts := map[string]int{}
+	for _, entry := range entries {
+		data, err := readEntry(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return fmt.Errorf("%s: %w", entry.Name(), err)
+		}
+
+		result, panicked := decode(data)
+		if panicked {
+			panics++
+			log.Printf("%s: panic: %s", entry.Name(), result)
+		}
+		counts[result]++
+	}
+
+	for result, n := range counts {
+		fmt.Printf("%5d  %s\n", n, result)
+	}
+	if panics > 0 {
+		return fmt.Errorf("%d corpus entries panicked", panics)
+	}
+
+	return nil
+
+}
+
+func decode(data []byte) (result string, panicked bool) {
+
+	defer func() {
+		if r := recover(); r != nil {
+			result, panicked = fmt.Sprint(r), true
+		}
+	}()
+
+	if _, err := plotutils.Decode(data); err != nil {
+		for _, target := range []error{
+			plotutils.ErrBadPrefix,
+			plotutils.ErrTruncatedPart,
+			plotutils.ErrOversizeJson,
+			plotutils.ErrInvalidJson,
+			plotutils.ErrUnknownVersion,
+			plotutils.ErrOddBuildLength,
+			plotutils.ErrOversizeBuild,
+			plotutils.ErrInvalidBuildData,
+			plotutils.ErrTrailingData,
+		} {
+			if errors.Is(err, target) {
+				return target.Error(), false
+			}
+		}
+		return "untyped error: " + err.Error(), false
+	}
+
+	return "ok", false
+
+}
+
+// reads the single []byte value of a go fuzz corpus file
+func readEntry(path string) ([]byte, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<24)
+	if !scanner.Scan() || scanner.Text() != "go test fuzz v1" {
+		return nil, fmt.Errorf("not a fuzz corpus file")
+	}
+	if !scanner.Scan() {
+		return nil, fmt.Errorf("missing value")
+	}
+	line := scanner.Text()
+	if !strings.HasPrefix(line, "[]byte(") || !strings.HasSuffix(line, ")") {
+		return nil, fmt.Errorf("value is not []byte")
+	}
+	value, err := strconv.Unquote(line[len("[]byte(") : len(line)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(value), nil
+
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"trraformapi/pkg/config"
+	plotutils "trraformapi/pkg/plot_utils"
+	"trraformapi/pkg/utils"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"go.mongodb.org/mongo-driver/v2/mongo/readpref"
+	"golang.org/x/sync/errgroup"
+)
+
+// rewrites plot objects in R2 to the version their bucket is served in, in batches, verifying each
+// rewrite decodes to the same plot before it's written and reading it back after.
+// with -versions the private version history is rewritten to config.PLOT_DATA_VERSION (v1).
+// CDN served plot objects (plots and their LODs) follow config.PLOT_PUBLIC_VERSION, which stays 0
+// until clients and the chunk builder decode v1. bumping it makes saves write v1 and this command
+// converts the stored objects. safe to re-run, objects already in the target version are skipped
+
+var errSkipped = errors.New("already migrated")
+
+func main() {
+
+	batchSize := flag.Int("batch", 100, "objects listed and migrated per batch")
+	concurrency := flag.Int("concurrency", 8, "objects migrated concurrently within a batch")
+	startAfter := flag.String("start-after", "", "resume after this object key")
+	dryRun := flag.Bool("dry-run", false, "decode, re-encode and verify without writing")
+	versions := flag.Bool("versions", false, "migrate the plot version history instead of the public plot objects")
+	flag.Parse()
+
+	ctx := context.Background()
+
+	// init mongo
+	mongoServerAPI := options.ServerAPI(options.ServerAPIVersion1)
+	mongoOpts := options.Client().ApplyURI("mongodb+srv://caleballen:" + config.ENV.MONGO_PASSWORD + "@trraform.cenuh0o.mongodb.net/?retryWrites=true&w=majority&appName=Trraform").SetServerAPIOptions(mongoServerAPI)
+	mongoCli, err := mongo.Connect(mongoOpts)
+	if err != nil {
+		panic(err)
+	}
+	defer mongoCli.Disconnect(ctx)
+	if err := mongoCli.Ping(ctx, readpref.Primary()); err != nil {
+		panic(err)
+	}
+	mongoDB := mongoCli.Database(config.MONGO_DB)
+
+	// init s3
+	cred := credentials.NewStaticCredentialsProvider(
+		config.ENV.CF_R2_ACCESS_KEY,
+		config.ENV.CF_R2_SECRET_KEY,
+		"",
+	)
+	r2Cli := s3.New(s3.Options{
+		Credentials:  cred,
+		BaseEndpoint: aws.String(os.Getenv("CF_R2_API_ENDPOINT")),
+		UsePathStyle: true,
+		Region:       "auto",
+	})
+
+	target := &migrationTarget{bucket: config.CF_PLOT_BUCKET, version: config.PLOT_PUBLIC_VERSION}
+	if *versions {
+		target = &migrationTarget{bucket: config.CF_VERSION_BUCKET, version: config.PLOT_DATA_VERSION}
+	}
+
+	var migrated, skipped, failed atomic.Int64
+	after := *startAfter
+
+	for {
+		list, err := r2Cli.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+			Bucket:     aws.String(target.bucket),
+			StartAfter: aws.String(after),
+			MaxKeys:    aws.Int32(int32(*batchSize)),
+		})
+		if err != nil {
+			log.Fatalf("List error after %q: %v", after, err)
+		}
+		if len(list.Contents) == 0 {
+			break
+		}
+
+		g, gctx := errgroup.WithContext(ctx)
+		g.SetLimit(*concurrency)
+		for _, obj := range list.Contents {
+			key := aws.ToString(obj.Key)
+			if !strings.HasSuffix(key, ".dat") {
+				continue
+			}
+			g.Go(func() error {
+				err := migratePlot(r2Cli, mongoDB, gctx, target, key, *dryRun)
+				switch {
+				case errors.Is(err, errSkipped):
+					skipped.Add(1)
+				case err != nil:
+					failed.Add(1)
+					log.Printf("Failed %s: %v", key, err)
+				default:
+					migrated.Add(1)
+				}
+				return nil
+			})
+		}
+		g.Wait()
+
+		after = aws.ToString(list.Contents[len(list.Contents)-1].Key)
+		log.Printf("Batch done, last key %s (migrated %d, skipped %d, failed %d)", after, migrated.Load(), skipped.Load(), failed.Load())
+
+		if !aws.ToBool(list.IsTruncated) {
+			break
+		}
+	}
+
+	fmt.Printf("Done: migrated %d, skipped %d, failed %d\n", migrated.Load(), skipped.Load(), failed.Load())
+	if failed.Load() > 0 {
+		os.Exit(1)
+	}
+
+}
+
+type migrationTarget struct {
+	bucket  string
+	version int
+}
+
+func migratePlot(r2Cli *s3.Client, mongoDB *mongo.Database, ctx context.Context, target *migrationTarget, key string, dryRun bool) error {
+
+	result, err := r2Cli.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(target.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(result.Body)
+	result.Body.Close()
+	if err != nil {
+		return err
+	}
+	etag := aws.ToString(result.ETag)
+
+	version, err := plotutils.DataVersion(data)
+	if err != nil {
+		return err
+	}
+	if version == target.version {
+		return errSkipped
+	}
+
+	plotData, err := plotutils.Decode(data)
+	if err != nil {
+		return err
+	}
+	newData, err := plotData.EncodeVersion(target.version)
+	if err != nil {
+		return err
+	}
+
+	// builds v1 doesn't compress stay in v0
+	if newVersion, err := plotutils.DataVersion(newData); err != nil {
+		return err
+	} else if newVersion == version {
+		return errSkipped
+	}
+
+	// verify the new encoding decodes to the same plot before writing it
+	if err := verify(plotData, newData); err != nil {
+		return err
+	}
+	if dryRun {
+		return nil
+	}
+
+	// conditional on the etag read above, so a save in the meantime isn't overwritten
+	contentType := aws.ToString(result.ContentType)
+	newEtag, err := utils.PutObjectIfMatchR2(r2Cli, ctx, target.bucket, key, bytes.NewReader(newData), contentType, result.Metadata, etag)
+	if err != nil {
+		return err
+	}
+
+	// keep the revision etag in sync so the next save isn't rejected as stale
+	if plotId, err := plotutils.PlotIdFromHexString(strings.TrimSuffix(key, ".dat")); err == nil && target.bucket == config.CF_PLOT_BUCKET {
+		if _, err := mongoDB.Collection("plots").UpdateOne(ctx,
+			bson.M{"plotId": plotId.Id, "etag": etag},
+			bson.M{"$set": bson.M{"etag": newEtag}},
+		); err != nil {
+			return err
+		}
+	}
+
+	// read back what was written
+	written, _, err := utils.GetObjectR2(r2Cli, ctx, target.bucket, key)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(written, newData) {
+		return fmt.Errorf("read back differs from written data")
+	}
+
+	return nil
+
+}
+
+func verify(plotData *plotutils.PlotData, newData []byte) error {
+
+	decoded, err := plotutils.Decode(newData)
+	if err != nil {
+		return fmt.Errorf("verify: %w", err)
+	}
+	if decoded.Name != plotData.Name || decoded.Description != plotData.Description || decoded.Link != plotData.Link || decoded.LinkTitle != plotData.LinkTitle {
+		return fmt.Errorf("verify: json part differs")
+	}
+
+	want, err := plotutils.ExpandBuildData(plotData.BuildData)
+	if err != nil {
+		return fmt.Errorf("verify: %w", err)
+	}
+	got, err := plotutils.ExpandBuildData(decoded.BuildData)
+	if err != nil {
+		return fmt.Errorf("verify: %w", err)
+	}
+	if decoded.BuildData[0] != plotData.BuildData[0] || !slices.Equal(want, got) {
+		return fmt.Errorf("verify: build data differs")
+	}
+
+	return nil
+
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+	"trraformapi/pkg/config"
+	plotutils "trraformapi/pkg/plot_utils"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"go.mongodb.org/mongo-driver/v2/mongo/readpref"
+)
+
+func main() {
+
+	config.RequireEnv("MONGO_PASSWORD", "REDIS_PASSWORD", "CF_R2_ACCESS_KEY", "CF_R2_SECRET_KEY", "CF_R2_API_ENDPOINT", "CF_API_TOKEN")
+
+	ctx := context.Background()
+
+	// init mongo
+	mongoServerAPI := options.ServerAPI(options.ServerAPIVersion1)
+	mongoOpts := options.Client().ApplyURI("mongodb+srv://caleballen:" + config.ENV.MONGO_PASSWORD + "@trraform.cenuh0o.mongodb.net/?retryWrites=true&w=majority&appName=Trraform").SetServerAPIOptions(mongoServerAPI)
+	mongoCli, err := mongo.Connect(mongoOpts)
+	if err != nil {
+		panic(err)
+	}
+	defer mongoCli.Disconnect(ctx)
+	if err := mongoCli.Ping(ctx, readpref.Primary()); err != nil {
+		panic(err)
+	}
+	mongoDB := mongoCli.Database(config.MONGO_DB)
+
+	// init redis
+	redisCli := redis.NewClient(&redis.Options{
+		Addr:     "redis-16216.c15.us-east-1-4.ec2.redns.redis-cloud.com:16216",
+		Username: "default",
+		Password: config.ENV.REDIS_PASSWORD,
+		DB:       0,
+	})
+
+	// init s3
+	cred := credentials.NewStaticCredentialsProvider(
+		config.ENV.CF_R2_ACCESS_KEY,
+		config.ENV.CF_R2_SECRET_KEY,
+		"",
+	)
+	r2Cli := s3.New(s3.Options{
+		Credentials:  cred,
+		BaseEndpoint: aws.String(os.Getenv("CF_R2_API_ENDPOINT")),
+		UsePathStyle: true,
+		Region:       "auto",
+	})
+
+	httpCli := &http.Client{
+		Timeout: 30 * time.Second,
+	}
+
+	fmt.Println("Starting owner sync worker")
+
+	// main loop
+	for {
+		job, err := plotutils.ClaimOwnerSyncJob(mongoDB, ctx)
+		if err != nil {
+			log.Printf("Claim job error: %v", err)
+			time.Sleep(time.Minute) // brief backoff
+			continue
+		}
+		if job == nil {
+			time.Sleep(time.Second * 10) // no jobs right now, sleep
+			continue
+		}
+
+		err = plotutils.RunOwnerSyncJob(mongoDB, redisCli, r2Cli, httpCli, ctx, job)
+		if errors.Is(err, plotutils.ErrOwnerSyncSuperseded) {
+			log.Printf("Job %s requeued, picking it up again", job.Id.Hex())
+		} else if err != nil {
+			log.Printf("Job %s failed for user %s: %v", job.Id.Hex(), job.Uid.Hex(), err)
+		} else {
+			log.Printf("Job %s done for user %s", job.Id.Hex(), job.Uid.Hex())
+		}
+	}
+
+}
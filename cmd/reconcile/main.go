@@ -0,0 +1,879 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+	"trraformapi/internal/api/payment"
+	"trraformapi/pkg/config"
+	plotutils "trraformapi/pkg/plot_utils"
+	"trraformapi/pkg/schemas"
+	"trraformapi/pkg/utils"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/redis/go-redis/v9"
+	"github.com/stripe/stripe-go/v82"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"go.mongodb.org/mongo-driver/v2/mongo/readpref"
+	"golang.org/x/sync/errgroup"
+)
+
+// cross checks the places a claimed plot lives: users.plotIds (the source of truth for ownership),
+// the plots collection, the plot's R2 object and its metadata, the open plots index, released plots,
+// paid stripe checkout sessions and the subplot payouts they owe. drift is reported, and repaired when run
+// with -repair. without it nothing is written
+
+// drift kinds
+const (
+	DRIFT_NO_PLOT_DOC     = "no-plot-doc"     // listed by a user, no plots document
+	DRIFT_OWNER_MISMATCH  = "owner-mismatch"  // plots document owner isn't the user listing it
+	DRIFT_ORPHAN_PLOT     = "orphan-plot"     // plots document no user lists
+	DRIFT_MULTIPLE_OWNERS = "multiple-owners" // listed by more than one user
+	DRIFT_MISSING_DAT     = "missing-dat"     // owned plot without a .dat object
+	DRIFT_METADATA        = "metadata"        // .dat owner or verified metadata is stale
+	DRIFT_SEARCH_FIELDS   = "search-fields"   // plots document verified flag is stale
+	DRIFT_OPEN_INDEX      = "open-index"      // claimed plot still in the open plots index
+	DRIFT_SUBPLOT_INDEX   = "subplot-index"   // open subplot missing from, or closed subplot left in, the open plots index
+	DRIFT_UNFULFILLED     = "unfulfilled"     // paid checkout session whose plots weren't claimed
+	DRIFT_UNCLEANED       = "uncleaned"       // released plot whose data wasn't cleared
+	DRIFT_EXPIRED         = "expired"         // pending transfers past their expiry
+	DRIFT_OWED_PAYOUT     = "owed-payout"     // subplot payout the claim recorded but nothing paid
+)
+
+// completed sessions younger than this may still have their webhook in flight
+const sessionGracePeriod = 10 * time.Minute
+
+// releases younger than this may still be cleaning up
+const releaseGracePeriod = 10 * time.Minute
+
+// payouts younger than this may still be settled by the webhook
+const payoutGracePeriod = 10 * time.Minute
+
+type drift struct {
+	Kind     string
+	Subject  string
+	Detail   string
+	Repaired bool
+	Err      error
+}
+
+type reconciler struct {
+	mongoDB   *mongo.Database
+	redisCli  *redis.Client
+	r2Cli     *s3.Client
+	stripeCli *stripe.Client
+	httpCli   *http.Client
+	repair    bool
+
+	users  map[bson.ObjectID]*schemas.User
+	owners map[uint64][]bson.ObjectID // plot to the users listing it
+
+	mu     sync.Mutex
+	drifts []*drift
+}
+
+func main() {
+
+	repair := flag.Bool("repair", false, "repair drift, dry run otherwise")
+	skipR2 := flag.Bool("skip-r2", false, "skip checking R2 objects")
+	since := flag.Duration("stripe-since", 30*24*time.Hour, "check paid checkout sessions created within this window, 0 skips stripe")
+	concurrency := flag.Int("concurrency", 8, "R2 objects checked concurrently")
+	flag.Parse()
+
+	config.RequireEnv("MONGO_PASSWORD", "REDIS_PASSWORD", "CF_R2_ACCESS_KEY", "CF_R2_SECRET_KEY", "CF_R2_API_ENDPOINT", "CF_API_TOKEN", "STRIPE_SECRET_KEY")
+
+	ctx := context.Background()
+
+	// init mongo
+	mongoServerAPI := options.ServerAPI(options.ServerAPIVersion1)
+	mongoOpts := options.Client().ApplyURI("mongodb+srv://caleballen:" + config.ENV.MONGO_PASSWORD + "@trraform.cenuh0o.mongodb.net/?retryWrites=true&w=majority&appName=Trraform").SetServerAPIOptions(mongoServerAPI)
+	mongoCli, err := mongo.Connect(mongoOpts)
+	if err != nil {
+		panic(err)
+	}
+	defer mongoCli.Disconnect(ctx)
+	if err := mongoCli.Ping(ctx, readpref.Primary()); err != nil {
+		panic(err)
+	}
+
+	// init redis
+	redisCli := redis.NewClient(&redis.Options{
+		Addr:     "redis-16216.c15.us-east-1-4.ec2.redns.redis-cloud.com:16216",
+		Username: "default",
+		Password: config.ENV.REDIS_PASSWORD,
+		DB:       0,
+	})
+
+	// init s3
+	cred := credentials.NewStaticCredentialsProvider(
+		config.ENV.CF_R2_ACCESS_KEY,
+		config.ENV.CF_R2_SECRET_KEY,
+		"",
+	)
+	r2Cli := s3.New(s3.Options{
+		Credentials:  cred,
+		BaseEndpoint: aws.String(os.Getenv("CF_R2_API_ENDPOINT")),
+		UsePathStyle: true,
+		Region:       "auto",
+	})
+
+	rec := &reconciler{
+		mongoDB:   mongoCli.Database(config.MONGO_DB),
+		redisCli:  redisCli,
+		r2Cli:     r2Cli,
+		stripeCli: stripe.NewClient(config.ENV.STRIPE_SECRET_KEY),
+		httpCli:   &http.Client{Timeout: 30 * time.Second},
+		repair:    *repair,
+	}
+
+	if !rec.repair {
+		fmt.Println("Dry run, run with -repair to fix drift")
+	}
+
+	if err := rec.loadUsers(ctx); err != nil {
+		log.Fatalf("Load users: %v", err)
+	}
+	if err := rec.checkPlotDocs(ctx); err != nil {
+		log.Fatalf("Check plots: %v", err)
+	}
+	if err := rec.checkOpenIndex(ctx); err != nil {
+		log.Fatalf("Check open plots index: %v", err)
+	}
+	if err := rec.checkSubplotIndex(ctx); err != nil {
+		log.Fatalf("Check open subplots index: %v", err)
+	}
+	if err := rec.checkReleases(ctx); err != nil {
+		log.Fatalf("Check releases: %v", err)
+	}
+	if err := rec.checkTransfers(ctx); err != nil {
+		log.Fatalf("Check transfers: %v", err)
+	}
+	if *since > 0 {
+		if err := rec.checkPayouts(ctx); err != nil {
+			log.Fatalf("Check subplot payouts: %v", err)
+		}
+	}
+	if !*skipR2 {
+		if err := rec.checkObjects(ctx, *concurrency); err != nil {
+			log.Fatalf("Check R2: %v", err)
+		}
+	}
+	if *since > 0 {
+		if err := rec.checkSessions(ctx, *since); err != nil {
+			log.Fatalf("Check stripe: %v", err)
+		}
+	}
+
+	if rec.report() > 0 {
+		os.Exit(1)
+	}
+
+}
+
+func (rec *reconciler) add(d *drift) {
+	rec.mu.Lock()
+	rec.drifts = append(rec.drifts, d)
+	rec.mu.Unlock()
+}
+
+// runs fix when repairing and records the outcome
+func (rec *reconciler) fix(d *drift, fix func() error) {
+	if rec.repair && fix != nil {
+		d.Err = fix()
+		d.Repaired = d.Err == nil
+	}
+	rec.add(d)
+}
+
+// prints drift, returns the count left unrepaired
+func (rec *reconciler) report() int {
+
+	sort.SliceStable(rec.drifts, func(i, j int) bool {
+		return rec.drifts[i].Kind < rec.drifts[j].Kind
+	})
+
+	counts := map[string]int{}
+	unrepaired := 0
+	for _, d := range rec.drifts {
+		status := "found"
+		if d.Repaired {
+			status = "repaired"
+		} else if d.Err != nil {
+			status = "repair failed: " + d.Err.Error()
+		}
+		if !d.Repaired {
+			unrepaired++
+		}
+		counts[d.Kind]++
+		fmt.Printf("%-16s %-26s %s (%s)\n", d.Kind, d.Subject, d.Detail, status)
+	}
+
+	kinds := make([]string, 0, len(counts))
+	for kind := range counts {
+		kinds = append(kinds, kind)
+	}
+	slices.Sort(kinds)
+	fmt.Printf("\n%d users, %d owned plots\n", len(rec.users), len(rec.owners))
+	for _, kind := range kinds {
+		fmt.Printf("%-16s %d\n", kind, counts[kind])
+	}
+	fmt.Printf("Done: %d drift, %d unrepaired\n", len(rec.drifts), unrepaired)
+
+	return unrepaired
+
+}
+
+func (rec *reconciler) loadUsers(ctx context.Context) error {
+
+	cur, err := rec.mongoDB.Collection("users").Find(ctx,
+		bson.M{"plotIds.0": bson.M{"$exists": true}},
+		options.Find().SetProjection(bson.M{
+			"username":     1,
+			"privacy":      1,
+			"subscription": 1,
+			"freePlot":     1,
+			"plotIds":      1,
+			"purchasedIds": 1,
+		}),
+	)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	rec.users = map[bson.ObjectID]*schemas.User{}
+	rec.owners = map[uint64][]bson.ObjectID{}
+	for cur.Next(ctx) {
+		var user schemas.User
+		if err := cur.Decode(&user); err != nil {
+			return err
+		}
+		rec.users[user.Id] = &user
+		for _, plotIdStr := range user.PlotIds {
+			plotId, err := plotutils.PlotIdFromHexString(plotIdStr)
+			if err != nil {
+				log.Printf("User %s lists invalid plot id %q", user.Id.Hex(), plotIdStr)
+				continue
+			}
+			rec.owners[plotId.Id] = append(rec.owners[plotId.Id], user.Id)
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return err
+	}
+
+	// ownership can't be repaired without knowing which listing is right
+	for id, uids := range rec.owners {
+		if len(uids) > 1 {
+			hexes := make([]string, len(uids))
+			for i, uid := range uids {
+				hexes[i] = uid.Hex()
+			}
+			rec.add(&drift{
+				Kind:    DRIFT_MULTIPLE_OWNERS,
+				Subject: (&plotutils.PlotId{Id: id}).ToString(),
+				Detail:  fmt.Sprintf("listed by %v", hexes),
+			})
+		}
+	}
+
+	return nil
+
+}
+
+// source a plot was most likely claimed with, for plots documents that were never written
+func inferSource(user *schemas.User, plotIdStr string) string {
+	if slices.Contains(user.PurchasedIds, plotIdStr) {
+		return schemas.PLOT_SOURCE_PURCHASE
+	}
+	if user.FreePlot == plotIdStr {
+		return schemas.PLOT_SOURCE_FREE
+	}
+	return schemas.PLOT_SOURCE_CREDIT
+}
+
+func (rec *reconciler) checkPlotDocs(ctx context.Context) error {
+
+	plotsColl := rec.mongoDB.Collection("plots")
+	cur, err := plotsColl.Find(ctx, bson.M{},
+		options.Find().SetProjection(bson.M{"plotId": 1, "owner": 1, "verified": 1}),
+	)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	seen := make(map[uint64]struct{}, len(rec.owners))
+	searchStale := map[bson.ObjectID]struct{}{}
+	for cur.Next(ctx) {
+		var plot schemas.Plot
+		if err := cur.Decode(&plot); err != nil {
+			return err
+		}
+		seen[plot.PlotId] = struct{}{}
+		plotIdStr := (&plotutils.PlotId{Id: plot.PlotId}).ToString()
+
+		uids := rec.owners[plot.PlotId]
+		if len(uids) == 0 {
+			// nobody to give it to, the plot's checkout session shows up as unfulfilled if it was paid for
+			owner := "no owner"
+			if !plot.Owner.IsZero() {
+				owner = "owner " + plot.Owner.Hex() + " doesn't list it"
+			}
+			rec.add(&drift{Kind: DRIFT_ORPHAN_PLOT, Subject: plotIdStr, Detail: owner})
+			continue
+		}
+		if len(uids) > 1 {
+			continue
+		}
+
+		user := rec.users[uids[0]]
+		if plot.Owner != user.Id {
+			rec.fix(&drift{
+				Kind:    DRIFT_OWNER_MISMATCH,
+				Subject: plotIdStr,
+				Detail:  fmt.Sprintf("owner %s, listed by %s", plot.Owner.Hex(), user.Id.Hex()),
+			}, func() error {
+				_, err := plotsColl.UpdateOne(ctx, bson.M{"plotId": plot.PlotId}, bson.M{
+					"$set": bson.M{"owner": user.Id},
+				})
+				return err
+			})
+		}
+		if plot.Verified != user.Subscription.IsActive {
+			searchStale[user.Id] = struct{}{}
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return err
+	}
+
+	for id, uids := range rec.owners {
+		if _, ok := seen[id]; ok || len(uids) > 1 {
+			continue
+		}
+		user := rec.users[uids[0]]
+		plotId := &plotutils.PlotId{Id: id}
+		plotIdStr := plotId.ToString()
+		rec.fix(&drift{
+			Kind:    DRIFT_NO_PLOT_DOC,
+			Subject: plotIdStr,
+			Detail:  "listed by " + user.Id.Hex(),
+		}, func() error {
+			_, err := plotsColl.InsertOne(ctx, schemas.Plot{
+				PlotId: id,
+				Ctime:  time.Now().UTC(),
+				Owner:  user.Id,
+				Source: inferSource(user, plotIdStr),
+				Depth:  plotId.Depth(),
+			})
+			if mongo.IsDuplicateKeyError(err) { // claimed while checking
+				return nil
+			}
+			return err
+		})
+	}
+
+	// search fields are rewritten per owner
+	for uid := range searchStale {
+		user := rec.users[uid]
+		rec.fix(&drift{
+			Kind:    DRIFT_SEARCH_FIELDS,
+			Subject: uid.Hex(),
+			Detail:  "verified should be " + strconv.FormatBool(user.Subscription.IsActive),
+		}, func() error {
+			return plotutils.UpdatePlotsSearchFields(rec.mongoDB, ctx, user)
+		})
+	}
+
+	return nil
+
+}
+
+func (rec *reconciler) checkOpenIndex(ctx context.Context) error {
+
+	const batchSize = 1000
+
+	ids := make([]uint64, 0, len(rec.owners))
+	for id := range rec.owners {
+		ids = append(ids, id)
+	}
+
+	for batch := range slices.Chunk(ids, batchSize) {
+		pipe := rec.redisCli.Pipeline()
+		cmds := make([]*redis.BoolCmd, len(batch))
+		for i, id := range batch {
+			plotId := &plotutils.PlotId{Id: id}
+			cmds[i] = pipe.SIsMember(ctx, plotutils.AvailabilityKey(plotId.Depth()), plotId.ToString())
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return err
+		}
+		for i, id := range batch {
+			if !cmds[i].Val() {
+				continue
+			}
+			plotId := &plotutils.PlotId{Id: id}
+			rec.fix(&drift{
+				Kind:    DRIFT_OPEN_INDEX,
+				Subject: plotId.ToString(),
+				Detail:  "claimed but listed as open",
+			}, func() error {
+				return rec.redisCli.SRem(ctx, plotutils.AvailabilityKey(plotId.Depth()), plotId.ToString()).Err()
+			})
+		}
+	}
+
+	return nil
+
+}
+
+// the subplot depths of the open plots index hold every unclaimed subplot placed in a claimed
+// parent's build. this also seeds the index for plots claimed before it existed, subplot slots
+// come from the plots documents so search_backfill has to have run first
+func (rec *reconciler) checkSubplotIndex(ctx context.Context) error {
+
+	cur, err := rec.mongoDB.Collection("plots").Find(ctx, bson.M{},
+		options.Find().SetProjection(bson.M{"plotId": 1, "depth": 1, "subplots": 1}),
+	)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	claimed := make(map[uint64]bool, len(rec.owners))
+	for id := range rec.owners {
+		claimed[id] = true
+	}
+	var parents []schemas.Plot
+	for cur.Next(ctx) {
+		var plot schemas.Plot
+		if err := cur.Decode(&plot); err != nil {
+			return err
+		}
+		claimed[plot.PlotId] = true
+		if plot.Depth < config.MAX_DEPTH && len(plot.Subplots) > 0 {
+			parents = append(parents, plot)
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return err
+	}
+
+	// open subplots by parent
+	open := make(map[uint64]map[string]bool, len(parents))
+	for _, parent := range parents {
+		if _, owned := rec.owners[parent.PlotId]; !owned {
+			continue
+		}
+		parentId := &plotutils.PlotId{Id: parent.PlotId}
+		subplots := make(map[string]bool, len(parent.Subplots))
+		for _, local := range parent.Subplots {
+			plotId := plotutils.CreateSubplotId(parentId, uint64(local))
+			if !claimed[plotId.Id] {
+				subplots[plotId.ToString()] = true
+			}
+		}
+		open[parent.PlotId] = subplots
+	}
+
+	// what the index holds, grouped the same way
+	indexed := make(map[uint64]map[string]bool)
+	for depth := 1; depth <= config.MAX_DEPTH; depth++ {
+		members, err := rec.redisCli.SMembers(ctx, plotutils.AvailabilityKey(depth)).Result()
+		if err != nil {
+			return err
+		}
+		for _, idStr := range members {
+			plotId, err := plotutils.PlotIdFromHexString(idStr)
+			var parentId *plotutils.PlotId
+			if err == nil {
+				parentId = plotId.GetParent()
+			}
+			if parentId == nil || plotId.Depth() != depth {
+				rec.fix(&drift{
+					Kind:    DRIFT_SUBPLOT_INDEX,
+					Subject: idStr,
+					Detail:  fmt.Sprintf("not a depth %d plot id", depth),
+				}, func() error {
+					return rec.redisCli.SRem(ctx, plotutils.AvailabilityKey(depth), idStr).Err()
+				})
+				continue
+			}
+			if indexed[parentId.Id] == nil {
+				indexed[parentId.Id] = map[string]bool{}
+			}
+			indexed[parentId.Id][plotId.ToString()] = true
+		}
+	}
+
+	// one drift per parent, claimed subplots left in the index are checkOpenIndex's
+	parentIds := make(map[uint64]struct{}, len(open)+len(indexed))
+	for id := range open {
+		parentIds[id] = struct{}{}
+	}
+	for id := range indexed {
+		parentIds[id] = struct{}{}
+	}
+	for id := range parentIds {
+		var missing, stale []any
+		for idStr := range open[id] {
+			if !indexed[id][idStr] {
+				missing = append(missing, idStr)
+			}
+		}
+		for idStr := range indexed[id] {
+			plotId, _ := plotutils.PlotIdFromHexString(idStr)
+			if !open[id][idStr] && !claimed[plotId.Id] {
+				stale = append(stale, idStr)
+			}
+		}
+		if len(missing) == 0 && len(stale) == 0 {
+			continue
+		}
+
+		parentId := &plotutils.PlotId{Id: id}
+		key := plotutils.AvailabilityKey(parentId.Depth() + 1)
+		rec.fix(&drift{
+			Kind:    DRIFT_SUBPLOT_INDEX,
+			Subject: parentId.ToString(),
+			Detail:  fmt.Sprintf("%d open subplots missing, %d closed subplots listed", len(missing), len(stale)),
+		}, func() error {
+			pipe := rec.redisCli.TxPipeline()
+			if len(missing) > 0 {
+				pipe.SAdd(ctx, key, missing...)
+			}
+			if len(stale) > 0 {
+				pipe.SRem(ctx, key, stale...)
+			}
+			_, err := pipe.Exec(ctx)
+			return err
+		})
+	}
+
+	return nil
+
+}
+
+// releases whose cleanup failed after the release committed
+func (rec *reconciler) checkReleases(ctx context.Context) error {
+
+	cur, err := rec.mongoDB.Collection("plotReleases").Find(ctx, bson.M{
+		"cleaned": false,
+		"ctime":   bson.M{"$lt": time.Now().Add(-releaseGracePeriod)},
+	})
+	if err != nil {
+		return err
+	}
+	var releases []schemas.PlotRelease
+	if err := cur.All(ctx, &releases); err != nil {
+		return err
+	}
+
+	for i := range releases {
+		release := &releases[i]
+		rec.fix(&drift{
+			Kind:    DRIFT_UNCLEANED,
+			Subject: release.PlotId,
+			Detail:  "released by " + release.Uid.Hex() + " at " + release.Ctime.Format(time.RFC3339),
+		}, func() error {
+			return plotutils.CleanReleasedPlot(rec.mongoDB, rec.redisCli, rec.r2Cli, rec.httpCli, ctx, release)
+		})
+	}
+
+	return nil
+
+}
+
+func (rec *reconciler) checkTransfers(ctx context.Context) error {
+
+	n, err := rec.mongoDB.Collection("transfers").CountDocuments(ctx, bson.M{
+		"status":    schemas.TRANSFER_PENDING,
+		"expiresAt": bson.M{"$lte": time.Now().UTC()},
+	})
+	if err != nil || n == 0 {
+		return err
+	}
+
+	rec.fix(&drift{
+		Kind:    DRIFT_EXPIRED,
+		Subject: "transfers",
+		Detail:  fmt.Sprintf("%d still pending", n),
+	}, func() error {
+		return plotutils.ExpireTransfers(rec.mongoDB, ctx, "")
+	})
+
+	return nil
+
+}
+
+// subplot payouts the webhook couldn't pay, usually a parent owner without a connect account
+func (rec *reconciler) checkPayouts(ctx context.Context) error {
+
+	cur, err := rec.mongoDB.Collection("subplotPayouts").Find(ctx, bson.M{
+		"status": schemas.SUBPLOT_PAYOUT_OWED,
+		"ctime":  bson.M{"$lt": time.Now().Add(-payoutGracePeriod)},
+	})
+	if err != nil {
+		return err
+	}
+	var payouts []schemas.SubplotPayout
+	if err := cur.All(ctx, &payouts); err != nil {
+		return err
+	}
+
+	for i := range payouts {
+		payout := &payouts[i]
+		rec.fix(&drift{
+			Kind:    DRIFT_OWED_PAYOUT,
+			Subject: payout.PlotId,
+			Detail:  fmt.Sprintf("%d cents owed to %s for session %s", payout.Amount, payout.Payee.Hex(), payout.Session),
+		}, func() error {
+			return payment.SettleSubplotPayouts(rec.stripeCli, rec.mongoDB, ctx, bson.M{"_id": payout.Id})
+		})
+	}
+
+	return nil
+
+}
+
+func (rec *reconciler) checkObjects(ctx context.Context, concurrency int) error {
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(concurrency)
+	for id, uids := range rec.owners {
+		if len(uids) > 1 {
+			continue
+		}
+		user := rec.users[uids[0]]
+		plotId := &plotutils.PlotId{Id: id}
+		g.Go(func() error {
+			return rec.checkObject(gctx, plotId, user)
+		})
+	}
+
+	return g.Wait()
+
+}
+
+func (rec *reconciler) checkObject(ctx context.Context, plotId *plotutils.PlotId, user *schemas.User) error {
+
+	plotIdStr := plotId.ToString()
+	metadata, found, err := utils.HeadObjectR2(rec.r2Cli, ctx, config.CF_PLOT_BUCKET, plotIdStr+".dat")
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		rec.fix(&drift{
+			Kind:    DRIFT_MISSING_DAT,
+			Subject: plotIdStr,
+			Detail:  "owned by " + user.Id.Hex(),
+		}, func() error {
+			return plotutils.SetDefaultPlot(rec.redisCli, rec.r2Cli, ctx, plotId, user)
+		})
+		return nil
+	}
+
+	want := plotutils.PlotMetadata(user)
+	for key, val := range want {
+		if metadata[key] != val {
+			rec.fix(&drift{
+				Kind:    DRIFT_METADATA,
+				Subject: plotIdStr,
+				Detail:  fmt.Sprintf("%s is %q, should be %q", key, metadata[key], val),
+			}, func() error {
+				return plotutils.UpdatePlotMetadata(rec.redisCli, rec.r2Cli, ctx, plotId, want)
+			})
+			break
+		}
+	}
+
+	return nil
+
+}
+
+// paid plot checkouts whose buyer doesn't own every plot in the session
+func (rec *reconciler) checkSessions(ctx context.Context, since time.Duration) error {
+
+	now := time.Now()
+	params := &stripe.CheckoutSessionListParams{
+		CreatedRange: &stripe.RangeQueryParams{
+			GreaterThanOrEqual: now.Add(-since).Unix(),
+			LesserThan:         now.Add(-sessionGracePeriod).Unix(),
+		},
+		Status: stripe.String(string(stripe.CheckoutSessionStatusComplete)),
+	}
+
+	for session, err := range rec.stripeCli.V1CheckoutSessions.List(ctx, params) {
+		if err != nil {
+			return err
+		}
+		if session.Mode != stripe.CheckoutSessionModePayment ||
+			session.PaymentStatus != stripe.CheckoutSessionPaymentStatusPaid ||
+			session.Metadata["type"] == "market" {
+			continue
+		}
+		if err := rec.checkSession(ctx, session); err != nil {
+			return err
+		}
+	}
+
+	return nil
+
+}
+
+func (rec *reconciler) checkSession(ctx context.Context, session *stripe.CheckoutSession) error {
+
+	uid, err := bson.ObjectIDFromHex(session.Metadata["uid"])
+	if err != nil {
+		log.Printf("Session %s has invalid uid %q", session.ID, session.Metadata["uid"])
+		return nil
+	}
+
+	var plotIds []*plotutils.PlotId
+	var missing []string
+	for i := range config.MAX_CART_SIZE {
+		plotIdStr, ok := session.Metadata[strconv.Itoa(i)]
+		if !ok {
+			break
+		}
+		plotId, err := plotutils.PlotIdFromHexString(plotIdStr)
+		if err != nil {
+			return err
+		}
+		plotIds = append(plotIds, plotId)
+		if !slices.Contains(rec.owners[plotId.Id], uid) {
+			missing = append(missing, plotId.ToString())
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	// claims are all or nothing, a session the buyer held any plot of was fulfilled. plots the buyer
+	// released, transferred or sold since aren't listed by them anymore
+	held, err := rec.buyerHeldPlots(ctx, uid, plotIds)
+	if err != nil {
+		return err
+	}
+	if held {
+		return nil
+	}
+
+	// plots taken before payment went through are refunded by the webhook
+	refunded, err := rec.sessionRefunded(ctx, session)
+	if err != nil {
+		return err
+	}
+	if refunded {
+		return nil
+	}
+
+	// same claim the webhook makes, plots owned by someone else need a manual refund
+	rec.fix(&drift{
+		Kind:    DRIFT_UNFULFILLED,
+		Subject: session.ID,
+		Detail:  fmt.Sprintf("buyer %s doesn't own %v", uid.Hex(), missing),
+	}, func() error {
+		subplotPrices, err := plotutils.SubplotPricesFromMetadata(session.Metadata)
+		if err != nil {
+			return err
+		}
+		var paymentIntentId string
+		if session.PaymentIntent != nil {
+			paymentIntentId = session.PaymentIntent.ID
+		}
+		_, err = plotutils.ClaimPlots(rec.mongoDB, rec.redisCli, rec.r2Cli, ctx, &plotutils.ClaimRequest{
+			Uid:           uid,
+			PlotIds:       plotIds,
+			Source:        schemas.PLOT_SOURCE_PURCHASE,
+			LockOwner:     session.Metadata["lo"],
+			SubplotPrices: subplotPrices,
+			Session:       session.ID,
+			PaymentIntent: paymentIntentId,
+		})
+		var conflictErr *plotutils.ClaimConflictError
+		if errors.As(err, &conflictErr) {
+			return fmt.Errorf("needs refund, %v", conflictErr.Results)
+		} else if err != nil {
+			return err
+		}
+		if err := payment.SettleSubplotPayouts(rec.stripeCli, rec.mongoDB, ctx, bson.M{"session": session.ID}); err != nil {
+			return err
+		}
+		_, err = plotutils.UnlockPlots(rec.redisCli, session.Metadata["lo"])
+		return err
+	})
+
+	return nil
+
+}
+
+// whether uid ever owned one of the plots, from release records, transfer history and sold listings
+func (rec *reconciler) buyerHeldPlots(ctx context.Context, uid bson.ObjectID, plotIds []*plotutils.PlotId) (bool, error) {
+
+	ids := make([]uint64, len(plotIds))
+	idStrs := make([]string, len(plotIds))
+	for i, plotId := range plotIds {
+		ids[i] = plotId.Id
+		idStrs[i] = plotId.ToString()
+	}
+
+	checks := []struct {
+		coll   string
+		filter bson.M
+	}{
+		{"plotReleases", bson.M{"plotId": bson.M{"$in": idStrs}, "$or": bson.A{
+			bson.M{"uid": uid},
+			bson.M{"transfers.from": uid},
+		}}},
+		{"plots", bson.M{"plotId": bson.M{"$in": ids}, "transfers.from": uid}},
+		{"listings", bson.M{"plotId": bson.M{"$in": idStrs}, "seller": uid, "status": schemas.LISTING_SOLD}},
+	}
+	for _, check := range checks {
+		n, err := rec.mongoDB.Collection(check.coll).CountDocuments(ctx, check.filter, options.Count().SetLimit(1))
+		if err != nil {
+			return false, err
+		}
+		if n > 0 {
+			return true, nil
+		}
+	}
+
+	return false, nil
+
+}
+
+func (rec *reconciler) sessionRefunded(ctx context.Context, session *stripe.CheckoutSession) (bool, error) {
+
+	if session.PaymentIntent == nil {
+		return false, nil
+	}
+
+	params := &stripe.RefundListParams{PaymentIntent: stripe.String(session.PaymentIntent.ID)}
+	for refund, err := range rec.stripeCli.V1Refunds.List(ctx, params) {
+		if err != nil {
+			return false, err
+		}
+		if refund.Status != stripe.RefundStatusFailed && refund.Status != stripe.RefundStatusCanceled {
+			return true, nil
+		}
+	}
+
+	return false, nil
+
+}
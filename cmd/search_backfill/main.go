@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"sync"
+	"sync/atomic"
+	"trraformapi/pkg/config"
+	plotutils "trraformapi/pkg/plot_utils"
+	"trraformapi/pkg/schemas"
+	"trraformapi/pkg/utils"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"go.mongodb.org/mongo-driver/v2/mongo/readpref"
+	"golang.org/x/sync/errgroup"
+)
+
+// creates the plot search index and fills the search fields of existing plots from their R2 objects,
+// and records the subplot slots of plots saved before slots were tracked.
+// safe to re-run, every plot is rewritten from its current object and owner
+
+func main() {
+
+	concurrency := flag.Int("concurrency", 8, "plots backfilled concurrently")
+	startAfter := flag.String("start-after", "", "resume after this plots document id")
+	dryRun := flag.Bool("dry-run", false, "decode plots without writing")
+	flag.Parse()
+
+	config.RequireEnv("MONGO_PASSWORD", "CF_R2_ACCESS_KEY", "CF_R2_SECRET_KEY", "CF_R2_API_ENDPOINT")
+
+	ctx := context.Background()
+
+	// init mongo
+	mongoServerAPI := options.ServerAPI(options.ServerAPIVersion1)
+	mongoOpts := options.Client().ApplyURI("mongodb+srv://caleballen:" + config.ENV.MONGO_PASSWORD + "@trraform.cenuh0o.mongodb.net/?retryWrites=true&w=majority&appName=Trraform").SetServerAPIOptions(mongoServerAPI)
+	mongoCli, err := mongo.Connect(mongoOpts)
+	if err != nil {
+		panic(err)
+	}
+	defer mongoCli.Disconnect(ctx)
+	if err := mongoCli.Ping(ctx, readpref.Primary()); err != nil {
+		panic(err)
+	}
+	mongoDB := mongoCli.Database(config.MONGO_DB)
+
+	// init s3
+	cred := credentials.NewStaticCredentialsProvider(
+		config.ENV.CF_R2_ACCESS_KEY,
+		config.ENV.CF_R2_SECRET_KEY,
+		"",
+	)
+	r2Cli := s3.New(s3.Options{
+		Credentials:  cred,
+		BaseEndpoint: aws.String(os.Getenv("CF_R2_API_ENDPOINT")),
+		UsePathStyle: true,
+		Region:       "auto",
+	})
+
+	if !*dryRun {
+		if err := plotutils.EnsurePlotSearchIndex(mongoDB, ctx); err != nil {
+			log.Fatalf("Index error: %v", err)
+		}
+	}
+
+	filter := bson.M{}
+	if *startAfter != "" {
+		lastId, err := bson.ObjectIDFromHex(*startAfter)
+		if err != nil {
+			log.Fatal(err)
+		}
+		filter["_id"] = bson.M{"$gt": lastId}
+	}
+	cur, err := mongoDB.Collection("plots").Find(ctx, filter,
+		options.Find().SetSort(bson.M{"_id": 1}).SetProjection(bson.M{"plotId": 1}),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer cur.Close(ctx)
+
+	owners := &ownerCache{users: map[string]*schemas.User{}}
+	var done, failed atomic.Int64
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(*concurrency)
+	for cur.Next(ctx) {
+		var plot schemas.Plot
+		if err := cur.Decode(&plot); err != nil {
+			log.Fatal(err)
+		}
+		g.Go(func() error {
+			if err := backfillPlot(mongoDB, r2Cli, gctx, owners, &plot, *dryRun); err != nil {
+				failed.Add(1)
+				log.Printf("Failed %s (plot %d): %v", plot.Id.Hex(), plot.PlotId, err)
+			} else if n := done.Add(1); n%1000 == 0 {
+				log.Printf("Backfilled %d plots, last %s", n, plot.Id.Hex())
+			}
+			return nil
+		})
+	}
+	g.Wait()
+	if err := cur.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("Done: backfilled %d, failed %d\n", done.Load(), failed.Load())
+	if failed.Load() > 0 {
+		os.Exit(1)
+	}
+
+}
+
+func backfillPlot(mongoDB *mongo.Database, r2Cli *s3.Client, ctx context.Context, owners *ownerCache, plot *schemas.Plot, dryRun bool) error {
+
+	plotId := &plotutils.PlotId{Id: plot.PlotId}
+	plotIdStr := plotId.ToString()
+
+	// plots never saved have no object, they still get owner fields and depth
+	plotData := &plotutils.PlotData{}
+	data, _, err := utils.GetObjectR2(r2Cli, ctx, config.CF_PLOT_BUCKET, plotIdStr+".dat")
+	var noSuchKey *types.NoSuchKey
+	if err == nil {
+		if plotData, err = plotutils.Decode(data); err != nil {
+			return err
+		}
+	} else if !errors.As(err, &noSuchKey) {
+		return err
+	}
+
+	owner, err := owners.get(mongoDB, ctx, plotIdStr)
+	if err != nil {
+		return err
+	}
+
+	set := plotutils.PlotDataSearchFields(plotId, plotData)
+	if owner != nil {
+		maps.Copy(set, plotutils.PlotOwnerSearchFields(owner))
+	}
+	if dryRun {
+		return nil
+	}
+
+	if _, err := mongoDB.Collection("plots").UpdateOne(ctx, bson.M{"_id": plot.Id}, bson.M{"$set": set}); err != nil {
+		return err
+	}
+
+	// slots are otherwise recorded by saves, a save since this read has the newer ones
+	if len(plotData.BuildData) < 2 || plotId.Depth() >= config.MAX_DEPTH {
+		return nil
+	}
+	_, err = mongoDB.Collection("plots").UpdateOne(ctx,
+		bson.M{"_id": plot.Id, "subplots": nil},
+		bson.M{"$set": bson.M{"subplots": plotutils.SubplotMarkers(plotData.BuildData)}},
+	)
+
+	return err
+
+}
+
+// owners are looked up by plot, most own several plots
+type ownerCache struct {
+	mu    sync.Mutex
+	users map[string]*schemas.User // by plot id
+}
+
+func (c *ownerCache) get(mongoDB *mongo.Database, ctx context.Context, plotIdStr string) (*schemas.User, error) {
+
+	c.mu.Lock()
+	user, ok := c.users[plotIdStr]
+	c.mu.Unlock()
+	if ok {
+		return user, nil
+	}
+
+	var owner schemas.User
+	if err := mongoDB.Collection("users").FindOne(ctx, bson.M{"plotIds": plotIdStr}).Decode(&owner); errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	for _, id := range owner.PlotIds {
+		c.users[id] = &owner
+	}
+	c.mu.Unlock()
+
+	return &owner, nil
+
+}
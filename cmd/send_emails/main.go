@@ -101,6 +101,8 @@ func sendEmail(ctx context.Context, redisCli *redis.Client, sesCli *ses.Client,
 
 func main() {
 
+	config.RequireEnv("REDIS_PASSWORD")
+
 	ctx := context.Background()
 
 	// init redis
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+	"trraformapi/internal/api"
+	"trraformapi/internal/api/payment"
+	"trraformapi/pkg/config"
+	"trraformapi/pkg/schemas"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/redis/go-redis/v9"
+	"github.com/stripe/stripe-go/v82"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"go.mongodb.org/mongo-driver/v2/mongo/readpref"
+	"go.uber.org/zap"
+)
+
+// lists stripe webhook events from the ledger and re-drives failed ones through the webhook handler
+//
+//	stripe_events list [-status failed] [-type checkout.session.completed] [-limit 50]
+//	stripe_events redrive [-all-failed] [event ids...]
+
+func main() {
+
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	config.RequireEnv("MONGO_PASSWORD", "REDIS_PASSWORD", "CF_R2_ACCESS_KEY", "CF_R2_SECRET_KEY", "CF_R2_API_ENDPOINT", "CF_API_TOKEN", "STRIPE_SECRET_KEY")
+
+	ctx := context.Background()
+
+	// init mongo
+	mongoServerAPI := options.ServerAPI(options.ServerAPIVersion1)
+	mongoOpts := options.Client().ApplyURI("mongodb+srv://caleballen:" + config.ENV.MONGO_PASSWORD + "@trraform.cenuh0o.mongodb.net/?retryWrites=true&w=majority&appName=Trraform").SetServerAPIOptions(mongoServerAPI)
+	mongoCli, err := mongo.Connect(mongoOpts)
+	if err != nil {
+		panic(err)
+	}
+	defer mongoCli.Disconnect(ctx)
+	if err := mongoCli.Ping(ctx, readpref.Primary()); err != nil {
+		panic(err)
+	}
+	mongoDB := mongoCli.Database(config.MONGO_DB)
+
+	switch os.Args[1] {
+	case "list":
+		fs := flag.NewFlagSet("list", flag.ExitOnError)
+		status := fs.String("status", schemas.STRIPE_EVENT_FAILED, "event status, empty for all")
+		eventType := fs.String("type", "", "event type, empty for all")
+		limit := fs.Int64("limit", 50, "max events listed, newest first")
+		fs.Parse(os.Args[2:])
+		if err := listEvents(mongoDB, ctx, *status, *eventType, *limit); err != nil {
+			log.Fatal(err)
+		}
+
+	case "redrive":
+		fs := flag.NewFlagSet("redrive", flag.ExitOnError)
+		allFailed := fs.Bool("all-failed", false, "re-drive every failed event, oldest first")
+		fs.Parse(os.Args[2:])
+		eventIds := fs.Args()
+		if *allFailed == (len(eventIds) > 0) {
+			log.Fatal("pass event ids or -all-failed")
+		}
+		if *allFailed {
+			if eventIds, err = failedEventIds(mongoDB, ctx); err != nil {
+				log.Fatal(err)
+			}
+		}
+		failed := redrive(newPaymentHandler(mongoDB), ctx, eventIds)
+		fmt.Printf("Done: re-drove %d, failed %d\n", len(eventIds)-failed, failed)
+		if failed > 0 {
+			os.Exit(1)
+		}
+
+	default:
+		usage()
+	}
+
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: stripe_events list [-status s] [-type t] [-limit n]")
+	fmt.Fprintln(os.Stderr, "       stripe_events redrive [-all-failed] [event ids...]")
+	os.Exit(2)
+}
+
+// the same clients the api server gives the webhook
+func newPaymentHandler(mongoDB *mongo.Database) *payment.Handler {
+
+	logger, err := zap.NewDevelopment()
+	if err != nil {
+		panic(err)
+	}
+
+	// init s3
+	cred := credentials.NewStaticCredentialsProvider(
+		config.ENV.CF_R2_ACCESS_KEY,
+		config.ENV.CF_R2_SECRET_KEY,
+		"",
+	)
+
+	return &payment.Handler{Handler: &api.Handler{
+		Logger:  logger,
+		MongoDB: mongoDB,
+		RedisCli: redis.NewClient(&redis.Options{
+			Addr:     "redis-16216.c15.us-east-1-4.ec2.redns.redis-cloud.com:16216",
+			Username: "default",
+			Password: config.ENV.REDIS_PASSWORD,
+			DB:       0,
+		}),
+		R2Cli: s3.New(s3.Options{
+			Credentials:  cred,
+			BaseEndpoint: aws.String(os.Getenv("CF_R2_API_ENDPOINT")),
+			UsePathStyle: true,
+			Region:       "auto",
+		}),
+		StripeCli: stripe.NewClient(config.ENV.STRIPE_SECRET_KEY),
+		HttpCli: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+	}}
+
+}
+
+func listEvents(mongoDB *mongo.Database, ctx context.Context, status string, eventType string, limit int64) error {
+
+	filter := bson.M{}
+	if status != "" {
+		filter["status"] = status
+	}
+	if eventType != "" {
+		filter["type"] = eventType
+	}
+	cur, err := mongoDB.Collection("stripeEvents").Find(ctx, filter,
+		options.Find().
+			SetSort(bson.M{"receivedAt": -1}).
+			SetLimit(limit).
+			SetProjection(bson.M{"payload": 0}),
+	)
+	if err != nil {
+		return err
+	}
+	var events []schemas.StripeEvent
+	if err := cur.All(ctx, &events); err != nil {
+		return err
+	}
+
+	for _, event := range events {
+		fmt.Printf("%s  %-32s %-10s attempts=%d received=%s object=%s\n",
+			event.Id, event.Type, event.Status, event.Attempts, event.ReceivedAt.Format(time.RFC3339), event.Object)
+		if event.Error != "" {
+			fmt.Printf("    %s\n", event.Error)
+		}
+	}
+	fmt.Printf("%d events\n", len(events))
+
+	return nil
+
+}
+
+func failedEventIds(mongoDB *mongo.Database, ctx context.Context) ([]string, error) {
+
+	cur, err := mongoDB.Collection("stripeEvents").Find(ctx,
+		bson.M{"status": schemas.STRIPE_EVENT_FAILED},
+		options.Find().SetSort(bson.M{"created": 1}).SetProjection(bson.M{"_id": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var events []schemas.StripeEvent
+	if err := cur.All(ctx, &events); err != nil {
+		return nil, err
+	}
+
+	eventIds := make([]string, len(events))
+	for i := range events {
+		eventIds[i] = events[i].Id
+	}
+
+	return eventIds, nil
+
+}
+
+// re-drives events in order from their recorded payloads, returns the count that failed again
+func redrive(h *payment.Handler, ctx context.Context, eventIds []string) int {
+
+	failed := 0
+	for _, eventId := range eventIds {
+
+		var recorded schemas.StripeEvent
+		if err := h.MongoDB.Collection("stripeEvents").FindOne(ctx, bson.M{"_id": eventId}).Decode(&recorded); err != nil {
+			log.Printf("%s: %v", eventId, err)
+			failed++
+			continue
+		}
+		var event stripe.Event
+		if err := json.Unmarshal(recorded.Payload, &event); err != nil {
+			log.Printf("%s: %v", eventId, err)
+			failed++
+			continue
+		}
+
+		eventCtx, cancel := context.WithTimeout(ctx, config.API_TIMEOUT)
+		processed, err := h.ProcessEvent(eventCtx, &event, recorded.Payload)
+		cancel()
+		if err != nil {
+			log.Printf("%s (%s): %v", eventId, recorded.Type, err)
+			failed++
+		} else if !processed {
+			log.Printf("%s (%s): already processed", eventId, recorded.Type)
+		} else {
+			log.Printf("%s (%s): processed", eventId, recorded.Type)
+		}
+	}
+
+	return failed
+
+}
@@ -0,0 +1,180 @@
+package geometry
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"trraformapi/internal/api"
+	"trraformapi/pkg/config"
+	"trraformapi/pkg/geometry"
+	plotutils "trraformapi/pkg/plot_utils"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	maxRadius        = 32 // voxels
+	maxRadiusResults = 500
+)
+
+// version of the embedded chunk map, for clients and the chunk worker to check their copy against
+func (h *Handler) GetGeometryVersion(w http.ResponseWriter, r *http.Request) {
+
+	resParams := &api.ResParams{W: w, R: r}
+
+	resParams.ResData = &struct {
+		Version    int    `json:"version"`
+		Hash       string `json:"hash"`
+		WorldSize  int    `json:"worldSize"`
+		PlotCount  int    `json:"plotCount"`
+		ChunkCount int    `json:"chunkCount"`
+	}{
+		Version:    geometry.ChunkMapVersion,
+		Hash:       geometry.Hash(),
+		WorldSize:  geometry.WorldSize,
+		PlotCount:  config.DEP0_PLOT_COUNT,
+		ChunkCount: geometry.ChunkCount(),
+	}
+	resParams.Code = http.StatusOK
+	h.Res(resParams)
+
+}
+
+// chunk, world position and neighbors of a plot. subplots get the position of their
+// depth 0 ancestor and have no neighbors
+func (h *Handler) GetPlotGeometry(w http.ResponseWriter, r *http.Request) {
+
+	resParams := &api.ResParams{W: w, R: r}
+
+	plotIdStr := chi.URLParam(r, "id")
+	resParams.ReqData = plotIdStr
+	plotId, err := plotutils.PlotIdFromHexString(plotIdStr)
+	if err != nil || !plotId.Validate() {
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	root := plotId.Split()[0]
+	pos, _ := geometry.Position(root)
+	neighbors := []string{}
+	if plotId.Depth() == 0 {
+		for _, id := range geometry.Neighbors(root) {
+			neighbors = append(neighbors, (&plotutils.PlotId{Id: id}).ToString())
+		}
+	}
+
+	resParams.ResData = &struct {
+		PlotId    string        `json:"plotId"`
+		Depth     int           `json:"depth"`
+		ChunkId   string        `json:"chunkId"`
+		Position  geometry.Vec3 `json:"position"`
+		Neighbors []string      `json:"neighbors"`
+	}{
+		PlotId:    plotId.ToString(),
+		Depth:     plotId.Depth(),
+		ChunkId:   plotId.GetChunkId(),
+		Position:  pos,
+		Neighbors: neighbors,
+	}
+	resParams.Code = http.StatusOK
+	h.Res(resParams)
+
+}
+
+// plots in a chunk, chunk id in the GetChunkId format
+func (h *Handler) GetChunkGeometry(w http.ResponseWriter, r *http.Request) {
+
+	resParams := &api.ResParams{W: w, R: r}
+
+	chunkId := chi.URLParam(r, "id")
+	resParams.ReqData = chunkId
+	plotIds, err := plotutils.ChunkPlotIds(chunkId)
+	if err != nil || len(plotIds) == 0 {
+		resParams.Code = http.StatusNotFound
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	plotIdStrs := make([]string, len(plotIds))
+	for i, plotId := range plotIds {
+		plotIdStrs[i] = plotId.ToString()
+	}
+
+	resParams.ResData = &struct {
+		ChunkId string   `json:"chunkId"`
+		PlotIds []string `json:"plotIds"`
+	}{
+		ChunkId: chunkId,
+		PlotIds: plotIdStrs,
+	}
+	resParams.Code = http.StatusOK
+	h.Res(resParams)
+
+}
+
+// depth 0 plots within r voxels of a plot (plotId) or a world position (x, y, z), nearest first
+func (h *Handler) GetPlotsWithin(w http.ResponseWriter, r *http.Request) {
+
+	resParams := &api.ResParams{W: w, R: r}
+	query := r.URL.Query()
+	resParams.ReqData = query
+
+	radius, err := strconv.ParseFloat(query.Get("r"), 64)
+	if err != nil || radius < 0 || radius > maxRadius {
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = errors.New("invalid radius")
+		h.Res(resParams)
+		return
+	}
+
+	var center geometry.Vec3
+	if plotIdStr := query.Get("plotId"); plotIdStr != "" {
+		plotId, err := plotutils.PlotIdFromHexString(plotIdStr)
+		if err != nil || !plotId.Validate() {
+			resParams.Code = http.StatusBadRequest
+			resParams.Err = err
+			h.Res(resParams)
+			return
+		}
+		center, _ = geometry.Position(plotId.Split()[0])
+	} else {
+		for i, key := range []string{"x", "y", "z"} {
+			if center[i], err = strconv.Atoi(query.Get(key)); err != nil {
+				resParams.Code = http.StatusBadRequest
+				resParams.Err = err
+				h.Res(resParams)
+				return
+			}
+		}
+	}
+
+	type plotRes struct {
+		PlotId   string  `json:"plotId"`
+		Distance float64 `json:"distance"`
+	}
+	ids := geometry.Within(center, radius)
+	plots := make([]plotRes, 0, min(len(ids), maxRadiusResults))
+	for _, id := range ids[:min(len(ids), maxRadiusResults)] {
+		pos, _ := geometry.Position(id)
+		plots = append(plots, plotRes{
+			PlotId:   (&plotutils.PlotId{Id: id}).ToString(),
+			Distance: geometry.Distance(center, pos),
+		})
+	}
+
+	resParams.ResData = &struct {
+		Center    geometry.Vec3 `json:"center"`
+		Plots     []plotRes     `json:"plots"`
+		Truncated bool          `json:"truncated"`
+	}{
+		Center:    center,
+		Plots:     plots,
+		Truncated: len(ids) > maxRadiusResults,
+	}
+	resParams.Code = http.StatusOK
+	h.Res(resParams)
+
+}
@@ -0,0 +1,5 @@
+package geometry
+
+import "trraformapi/internal/api"
+
+type Handler struct{ *api.Handler }
@@ -0,0 +1,271 @@
+package market
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+	"trraformapi/internal/api"
+	"trraformapi/pkg/config"
+	plotutils "trraformapi/pkg/plot_utils"
+	"trraformapi/pkg/schemas"
+
+	"github.com/google/uuid"
+	"github.com/stripe/stripe-go/v82"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// active listings, or pending listings whose checkout was abandoned
+func buyableFilter(now time.Time) bson.M {
+	return bson.M{"$or": bson.A{
+		bson.M{"status": schemas.LISTING_ACTIVE},
+		bson.M{"status": schemas.LISTING_PENDING, "pendingUntil": bson.M{"$lt": now}},
+	}}
+}
+
+func (h *Handler) BuyListing(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	ctx := r.Context()
+	uid := ctx.Value("uid").(bson.ObjectID)
+	uidStr := uid.Hex()
+	resParams := &api.ResParams{W: w, R: r}
+
+	var reqData struct {
+		ListingId string `json:"listingId" validate:"required,mongodb"`
+	}
+
+	// validate request body
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&reqData); err != nil {
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	resParams.ReqData = reqData
+	if err := h.Validate.Struct(&reqData); err != nil {
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	listingId, _ := bson.ObjectIDFromHex(reqData.ListingId)
+	listingsColl := h.MongoDB.Collection("listings")
+	userColl := h.MongoDB.Collection("users")
+
+	// get listing
+	now := time.Now().UTC()
+	filter := buyableFilter(now)
+	filter["_id"] = listingId
+	var listing schemas.Listing
+	if err := listingsColl.FindOne(ctx, filter).Decode(&listing); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			resParams.Code = http.StatusNotFound
+		} else {
+			resParams.Code = http.StatusInternalServerError
+		}
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	if listing.Seller == uid {
+		resParams.Code = http.StatusBadRequest
+		h.Res(resParams)
+		return
+	}
+
+	// get user data
+	var user schemas.User
+	if err := userColl.FindOne(ctx, bson.M{
+		"_id": uid,
+	}).Decode(&user); err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	// check for user plot limit exceeded
+	if len(user.PlotIds)+1 > config.USER_PLOT_LIMIT {
+		resParams.ResData = &struct {
+			PlotLimitExceeded bool `json:"plotLimitExceeded"`
+		}{PlotLimitExceeded: true}
+		resParams.Code = http.StatusBadRequest
+		h.Res(resParams)
+		return
+	}
+
+	// get seller payout account
+	var seller schemas.User
+	if err := userColl.FindOne(ctx, bson.M{
+		"_id": listing.Seller,
+	}).Decode(&seller); err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	// lock plot for the duration of checkout
+	lockOwner := uuid.NewString()
+	failed, err := plotutils.LockPlots(h.RedisCli, ctx, []string{listing.PlotId}, lockOwner)
+	if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	if len(failed) > 0 {
+		resParams.ResData = &struct {
+			Conflict bool `json:"conflict"`
+		}{Conflict: true}
+		resParams.Code = http.StatusConflict
+		h.Res(resParams)
+		return
+	}
+
+	// mark listing pending, lock ttl matches
+	res, err := listingsColl.UpdateOne(ctx, filter, bson.M{
+		"$set": bson.M{
+			"status":       schemas.LISTING_PENDING,
+			"buyer":        uid,
+			"lockOwner":    lockOwner,
+			"pendingUntil": now.Add(config.CHECKOUT_SESSION_DURATION * 2),
+		},
+	})
+	if err != nil {
+		plotutils.UnlockPlots(h.RedisCli, lockOwner)
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	if res.MatchedCount == 0 {
+		plotutils.UnlockPlots(h.RedisCli, lockOwner)
+		resParams.ResData = &struct {
+			Conflict bool `json:"conflict"`
+		}{Conflict: true}
+		resParams.Code = http.StatusConflict
+		h.Res(resParams)
+		return
+	}
+
+	// undo pending state if checkout can't be created
+	revert := func() {
+		listingsColl.UpdateOne(ctx, bson.M{
+			"_id":       listingId,
+			"lockOwner": lockOwner,
+		}, bson.M{
+			"$set": bson.M{"status": schemas.LISTING_ACTIVE},
+		})
+		plotutils.UnlockPlots(h.RedisCli, lockOwner)
+	}
+
+	// create stripe customer for user if needed
+	var stripeCustomerId string
+	if user.StripeCustomer == "" {
+		cus, err := h.StripeCli.V1Customers.Create(ctx, &stripe.CustomerCreateParams{
+			Email: stripe.String(user.Email),
+		})
+		if err != nil {
+			revert()
+			resParams.Code = http.StatusInternalServerError
+			resParams.Err = err
+			h.Res(resParams)
+			return
+		}
+		stripeCustomerId = cus.ID
+
+		// update user with new stripe customer id
+		if _, err := userColl.UpdateOne(ctx, bson.M{
+			"_id": uid,
+		}, bson.M{
+			"$set": bson.M{
+				"stripeCustomer": cus.ID,
+			},
+		}); err != nil {
+			revert()
+			resParams.Code = http.StatusInternalServerError
+			resParams.Err = err
+			h.Res(resParams)
+			return
+		}
+	} else {
+		stripeCustomerId = user.StripeCustomer
+	}
+
+	// metadata
+	metadata := map[string]string{
+		"type":    "market",
+		"uid":     uidStr,
+		"lo":      lockOwner,
+		"listing": listing.Id.Hex(),
+	}
+
+	paymentIntentData := &stripe.CheckoutSessionCreatePaymentIntentDataParams{
+		Metadata: metadata,
+	}
+	paymentIntentData.ApplicationFeeAmount = stripe.Int64(listing.Price * config.MARKET_FEE_PERCENT / 100)
+	paymentIntentData.TransferData = &stripe.CheckoutSessionCreatePaymentIntentDataTransferDataParams{
+		Destination: stripe.String(seller.StripeConnect),
+	}
+
+	// create stripe checkout session
+	checkoutParams := &stripe.CheckoutSessionCreateParams{
+		Mode:              stripe.String(string(stripe.CheckoutSessionModePayment)),
+		SuccessURL:        stripe.String("https://yourapp.com/checkout/success?session_id={CHECKOUT_SESSION_ID}"),
+		CancelURL:         stripe.String("https://yourapp.com/checkout/cancel"),
+		Customer:          stripe.String(stripeCustomerId),
+		ClientReferenceID: stripe.String(uidStr),
+		ExpiresAt:         stripe.Int64(now.Add(config.CHECKOUT_SESSION_DURATION).Unix()),
+
+		LineItems: []*stripe.CheckoutSessionCreateLineItemParams{
+			{
+				PriceData: &stripe.CheckoutSessionCreateLineItemPriceDataParams{
+					Currency: stripe.String(string(stripe.CurrencyUSD)),
+					ProductData: &stripe.CheckoutSessionCreateLineItemPriceDataProductDataParams{
+						Name: stripe.String("Plot " + listing.PlotId),
+					},
+					UnitAmount: stripe.Int64(listing.Price),
+				},
+				Quantity: stripe.Int64(1),
+			},
+		},
+		Metadata:          metadata,
+		PaymentIntentData: paymentIntentData,
+	}
+	checkoutSession, err := h.StripeCli.V1CheckoutSessions.Create(ctx, checkoutParams)
+	if err != nil {
+		revert()
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	// keep track of this checkout session
+	if _, err := listingsColl.UpdateOne(ctx, bson.M{
+		"_id":       listingId,
+		"lockOwner": lockOwner,
+	}, bson.M{
+		"$set": bson.M{"stripeSession": checkoutSession.ID},
+	}); err != nil {
+		h.StripeCli.V1CheckoutSessions.Expire(ctx, checkoutSession.ID, nil)
+		revert()
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	resParams.ResData = &struct {
+		StripeSession string `json:"stripeSession"`
+	}{StripeSession: checkoutSession.ID}
+	resParams.Code = http.StatusOK
+	h.Res(resParams)
+
+}
@@ -0,0 +1,64 @@
+package market
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+	"trraformapi/internal/api"
+	"trraformapi/pkg/schemas"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func (h *Handler) CancelListing(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	ctx := r.Context()
+	uid := ctx.Value("uid").(bson.ObjectID)
+	resParams := &api.ResParams{W: w, R: r}
+
+	var reqData struct {
+		ListingId string `json:"listingId" validate:"required,mongodb"`
+	}
+
+	// validate request body
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&reqData); err != nil {
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	resParams.ReqData = reqData
+	if err := h.Validate.Struct(&reqData); err != nil {
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	// can't cancel while a buyer is in checkout
+	listingId, _ := bson.ObjectIDFromHex(reqData.ListingId)
+	filter := buyableFilter(time.Now().UTC())
+	filter["_id"] = listingId
+	filter["seller"] = uid
+	res, err := h.MongoDB.Collection("listings").UpdateOne(ctx, filter, bson.M{
+		"$set": bson.M{"status": schemas.LISTING_CANCELED},
+	})
+	if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	if res.MatchedCount == 0 {
+		resParams.Code = http.StatusNotFound
+		h.Res(resParams)
+		return
+	}
+
+	resParams.Code = http.StatusOK
+	h.Res(resParams)
+
+}
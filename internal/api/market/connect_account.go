@@ -0,0 +1,81 @@
+package market
+
+import (
+	"net/http"
+	"trraformapi/internal/api"
+	"trraformapi/pkg/schemas"
+
+	"github.com/stripe/stripe-go/v82"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func (h *Handler) ConnectAccount(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	ctx := r.Context()
+	uid := ctx.Value("uid").(bson.ObjectID)
+	resParams := &api.ResParams{W: w, R: r}
+
+	// get user data
+	var user schemas.User
+	userColl := h.MongoDB.Collection("users")
+	if err := userColl.FindOne(ctx, bson.M{
+		"_id": uid,
+	}).Decode(&user); err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	// create connected account for seller payouts if needed
+	connectId := user.StripeConnect
+	if connectId == "" {
+		account, err := h.StripeCli.V1Accounts.Create(ctx, &stripe.AccountCreateParams{
+			Type:     stripe.String(string(stripe.AccountTypeExpress)),
+			Email:    stripe.String(user.Email),
+			Metadata: map[string]string{"uid": uid.Hex()},
+		})
+		if err != nil {
+			resParams.Code = http.StatusInternalServerError
+			resParams.Err = err
+			h.Res(resParams)
+			return
+		}
+		connectId = account.ID
+
+		if _, err := userColl.UpdateOne(ctx, bson.M{
+			"_id": uid,
+		}, bson.M{
+			"$set": bson.M{
+				"stripeConnect": connectId,
+			},
+		}); err != nil {
+			resParams.Code = http.StatusInternalServerError
+			resParams.Err = err
+			h.Res(resParams)
+			return
+		}
+	}
+
+	// onboarding link, also used to finish incomplete onboarding
+	accountLink, err := h.StripeCli.V1AccountLinks.Create(ctx, &stripe.AccountLinkCreateParams{
+		Account:    stripe.String(connectId),
+		RefreshURL: stripe.String("https://yourapp.com/account/payouts"),
+		ReturnURL:  stripe.String("https://yourapp.com/account/payouts"),
+		Type:       stripe.String("account_onboarding"),
+	})
+	if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	resParams.ResData = &struct {
+		Url string `json:"url"`
+	}{Url: accountLink.URL}
+	resParams.Code = http.StatusOK
+	h.Res(resParams)
+
+}
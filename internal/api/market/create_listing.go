@@ -0,0 +1,166 @@
+package market
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+	"trraformapi/internal/api"
+	"trraformapi/pkg/config"
+	plotutils "trraformapi/pkg/plot_utils"
+	"trraformapi/pkg/schemas"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+func (h *Handler) CreateListing(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	ctx := r.Context()
+	uid := ctx.Value("uid").(bson.ObjectID)
+	resParams := &api.ResParams{W: w, R: r}
+
+	var reqData struct {
+		PlotId string `json:"plotId" validate:"required,plotid"`
+		Price  int64  `json:"price" validate:"required"`
+	}
+
+	// validate request body
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&reqData); err != nil {
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	resParams.ReqData = reqData
+	if err := h.Validate.Struct(&reqData); err != nil {
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	if reqData.Price < config.MARKET_MIN_PRICE || reqData.Price > config.MARKET_MAX_PRICE {
+		resParams.ResData = &struct {
+			InvalidPrice bool `json:"invalidPrice"`
+		}{InvalidPrice: true}
+		resParams.Code = http.StatusBadRequest
+		h.Res(resParams)
+		return
+	}
+
+	plotId, _ := plotutils.PlotIdFromHexString(reqData.PlotId)
+	plotIdStr := plotId.ToString()
+
+	// hold the plot's claim lock across the checks and insert, a release or sale can't land in between
+	lockOwner := uuid.NewString()
+	failedIds, err := plotutils.LockPlots(h.RedisCli, ctx, []string{plotIdStr}, lockOwner)
+	if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	defer plotutils.UnlockPlots(h.RedisCli, lockOwner)
+	if len(failedIds) > 0 {
+		resParams.ResData = &struct {
+			Conflict bool `json:"conflict"`
+		}{Conflict: true}
+		resParams.Code = http.StatusConflict
+		h.Res(resParams)
+		return
+	}
+
+	// get user data, check that user owns plot
+	var user schemas.User
+	if err := h.MongoDB.Collection("users").FindOne(ctx, bson.M{
+		"_id":     uid,
+		"plotIds": plotIdStr,
+	}).Decode(&user); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			resParams.Code = http.StatusUnauthorized
+		} else {
+			resParams.Code = http.StatusInternalServerError
+		}
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	// sellers are paid through stripe connect, which needs a fully onboarded account
+	chargesEnabled := false
+	if user.StripeConnect != "" {
+		account, err := h.StripeCli.V1Accounts.GetByID(ctx, user.StripeConnect, nil)
+		if err != nil {
+			resParams.Code = http.StatusInternalServerError
+			resParams.Err = err
+			h.Res(resParams)
+			return
+		}
+		chargesEnabled = account.ChargesEnabled
+	}
+	if !chargesEnabled {
+		resParams.ResData = &struct {
+			NoConnectAccount bool `json:"noConnectAccount"`
+		}{NoConnectAccount: true}
+		resParams.Code = http.StatusForbidden
+		h.Res(resParams)
+		return
+	}
+
+	now := time.Now().UTC()
+
+	// plot can't be listed while a transfer is pending
+	err = h.MongoDB.Collection("transfers").FindOne(ctx, bson.M{
+		"plotId":    plotIdStr,
+		"status":    schemas.TRANSFER_PENDING,
+		"expiresAt": bson.M{"$gt": now},
+	}).Err()
+	if err == nil {
+		resParams.ResData = &struct {
+			Conflict bool `json:"conflict"`
+		}{Conflict: true}
+		resParams.Code = http.StatusConflict
+		h.Res(resParams)
+		return
+	} else if !errors.Is(err, mongo.ErrNoDocuments) {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	listing := schemas.Listing{
+		PlotId: plotIdStr,
+		Depth:  plotId.Depth(),
+		Seller: uid,
+		Price:  reqData.Price,
+		Status: schemas.LISTING_ACTIVE,
+		Ctime:  now,
+	}
+	// the unique listing index allows only one open listing per plot
+	res, err := h.MongoDB.Collection("listings").InsertOne(ctx, &listing)
+	if mongo.IsDuplicateKeyError(err) {
+		resParams.ResData = &struct {
+			Conflict bool `json:"conflict"`
+		}{Conflict: true}
+		resParams.Code = http.StatusConflict
+		h.Res(resParams)
+		return
+	} else if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	resParams.ResData = &struct {
+		ListingId string `json:"listingId"`
+	}{ListingId: res.InsertedID.(bson.ObjectID).Hex()}
+	resParams.Code = http.StatusOK
+	h.Res(resParams)
+
+}
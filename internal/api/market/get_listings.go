@@ -0,0 +1,136 @@
+package market
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+	"trraformapi/internal/api"
+	"trraformapi/pkg/config"
+	"trraformapi/pkg/schemas"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type listingRes struct {
+	ListingId string    `json:"listingId"`
+	PlotId    string    `json:"plotId"`
+	Price     int64     `json:"price"`
+	Seller    string    `json:"seller"`
+	Ctime     time.Time `json:"ctime"`
+}
+
+func (h *Handler) GetListings(w http.ResponseWriter, r *http.Request) {
+
+	ctx := r.Context()
+	resParams := &api.ResParams{W: w, R: r}
+	query := r.URL.Query()
+	resParams.ReqData = query
+
+	filter := buyableFilter(time.Now().UTC())
+
+	// optional depth filter
+	if depthStr := query.Get("depth"); depthStr != "" {
+		depth, err := strconv.Atoi(depthStr)
+		if err != nil || depth < 0 || depth > config.MAX_DEPTH {
+			resParams.Code = http.StatusBadRequest
+			resParams.Err = err
+			h.Res(resParams)
+			return
+		}
+		filter["depth"] = depth
+	}
+
+	// cursor is the last listing id of the previous page
+	if cursor := query.Get("cursor"); cursor != "" {
+		lastId, err := bson.ObjectIDFromHex(cursor)
+		if err != nil {
+			resParams.Code = http.StatusBadRequest
+			resParams.Err = err
+			h.Res(resParams)
+			return
+		}
+		filter["_id"] = bson.M{"$lt": lastId}
+	}
+
+	limit := 20
+	cur, err := h.MongoDB.Collection("listings").Find(ctx, filter,
+		options.Find().SetSort(bson.M{"_id": -1}).SetLimit(int64(limit)),
+	)
+	if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	var listings []schemas.Listing
+	if err := cur.All(ctx, &listings); err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	// resolve seller usernames
+	uids := make([]bson.ObjectID, len(listings))
+	for i := range listings {
+		uids[i] = listings[i].Seller
+	}
+	usernames, err := h.usernames(ctx, uids)
+	if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	results := make([]listingRes, len(listings))
+	for i, l := range listings {
+		results[i] = listingRes{
+			ListingId: l.Id.Hex(),
+			PlotId:    l.PlotId,
+			Price:     l.Price,
+			Seller:    usernames[l.Seller],
+			Ctime:     l.Ctime,
+		}
+	}
+	nextCursor := ""
+	if len(listings) == limit {
+		nextCursor = listings[len(listings)-1].Id.Hex()
+	}
+
+	resParams.ResData = &struct {
+		Listings   []listingRes `json:"listings"`
+		NextCursor string       `json:"nextCursor"`
+	}{
+		Listings:   results,
+		NextCursor: nextCursor,
+	}
+	resParams.Code = http.StatusOK
+	h.Res(resParams)
+
+}
+
+func (h *Handler) usernames(ctx context.Context, uids []bson.ObjectID) (map[bson.ObjectID]string, error) {
+
+	cur, err := h.MongoDB.Collection("users").Find(ctx,
+		bson.M{"_id": bson.M{"$in": uids}},
+		options.Find().SetProjection(bson.M{"username": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var users []schemas.User
+	if err := cur.All(ctx, &users); err != nil {
+		return nil, err
+	}
+
+	usernames := make(map[bson.ObjectID]string, len(users))
+	for _, u := range users {
+		usernames[u.Id] = u.Username
+	}
+
+	return usernames, nil
+
+}
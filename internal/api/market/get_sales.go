@@ -0,0 +1,86 @@
+package market
+
+import (
+	"net/http"
+	"time"
+	"trraformapi/internal/api"
+	plotutils "trraformapi/pkg/plot_utils"
+	"trraformapi/pkg/schemas"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type saleRes struct {
+	PlotId string    `json:"plotId"`
+	Price  int64     `json:"price"`
+	Seller string    `json:"seller"`
+	Buyer  string    `json:"buyer"`
+	SoldAt time.Time `json:"soldAt"`
+}
+
+func (h *Handler) GetSales(w http.ResponseWriter, r *http.Request) {
+
+	ctx := r.Context()
+	resParams := &api.ResParams{W: w, R: r}
+	query := r.URL.Query()
+	resParams.ReqData = query
+
+	// recent sales, optionally for one plot
+	filter := bson.M{"status": schemas.LISTING_SOLD}
+	if plotIdStr := query.Get("plotId"); plotIdStr != "" {
+		plotId, err := plotutils.PlotIdFromHexString(plotIdStr)
+		if err != nil || !plotId.Validate() {
+			resParams.Code = http.StatusBadRequest
+			resParams.Err = err
+			h.Res(resParams)
+			return
+		}
+		filter["plotId"] = plotId.ToString()
+	}
+
+	cur, err := h.MongoDB.Collection("listings").Find(ctx, filter,
+		options.Find().SetSort(bson.M{"soldAt": -1}).SetLimit(50),
+	)
+	if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	var listings []schemas.Listing
+	if err := cur.All(ctx, &listings); err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	uids := make([]bson.ObjectID, 0, len(listings)*2)
+	for _, l := range listings {
+		uids = append(uids, l.Seller, l.Buyer)
+	}
+	usernames, err := h.usernames(ctx, uids)
+	if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	sales := make([]saleRes, len(listings))
+	for i, l := range listings {
+		sales[i] = saleRes{
+			PlotId: l.PlotId,
+			Price:  l.Price,
+			Seller: usernames[l.Seller],
+			Buyer:  usernames[l.Buyer],
+			SoldAt: l.SoldAt,
+		}
+	}
+
+	resParams.ResData = sales
+	resParams.Code = http.StatusOK
+	h.Res(resParams)
+
+}
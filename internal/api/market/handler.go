@@ -0,0 +1,27 @@
+package market
+
+import (
+	"context"
+	"trraformapi/internal/api"
+	"trraformapi/pkg/schemas"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type Handler struct{ *api.Handler }
+
+// one open listing per plot, active and pending sales share it
+func EnsureListingIndexes(mongoDB *mongo.Database, ctx context.Context) error {
+
+	_, err := mongoDB.Collection("listings").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "plotId", Value: 1}},
+		Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{
+			"status": bson.M{"$in": bson.A{schemas.LISTING_ACTIVE, schemas.LISTING_PENDING}},
+		}),
+	})
+
+	return err
+
+}
@@ -16,7 +16,6 @@ import (
 	"github.com/redis/go-redis/v9"
 	"github.com/stripe/stripe-go/v82"
 	"go.mongodb.org/mongo-driver/v2/bson"
-	"go.mongodb.org/mongo-driver/v2/mongo/options"
 )
 
 type CartSession struct {
@@ -33,7 +32,7 @@ func (h *Handler) CreateCheckoutSession(w http.ResponseWriter, r *http.Request)
 	resParams := &api.ResParams{W: w, R: r}
 
 	var reqData struct {
-		PlotIds []string `json:"plotIds" validate:"required,min=1,max=40"`
+		PlotIds []string `json:"plotIds" validate:"required,min=1,cartsize"`
 	}
 
 	// validate request body
@@ -192,9 +191,8 @@ func (h *Handler) CreateCheckoutSession(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// verify that plots are not already claimed
-	filter := bson.M{"plotId": bson.M{"$in": plotIdStrs}}
-	cursor, err := h.MongoDB.Collection("plots").Find(ctx, filter, options.Find().SetProjection(bson.M{"plotId": 1}))
+	// plots must be unclaimed, subplots placed in the parent's build and allowed by its owner
+	subplotClaims, conflictReasons, err := plotutils.CheckClaimable(h.MongoDB, ctx, uid, plotIds)
 	if err != nil {
 		plotutils.UnlockPlots(h.RedisCli, lockOwner)
 		resParams.Code = http.StatusInternalServerError
@@ -202,42 +200,43 @@ func (h *Handler) CreateCheckoutSession(w http.ResponseWriter, r *http.Request)
 		h.Res(resParams)
 		return
 	}
-	defer cursor.Close(ctx)
-
-	// check for conflicts
-	var results []struct {
-		PlotId string `bson:"plotId"`
-	}
-	if err := cursor.All(ctx, &results); err != nil {
+	if len(conflictReasons) > 0 {
 		plotutils.UnlockPlots(h.RedisCli, lockOwner)
-		resParams.Code = http.StatusInternalServerError
-		resParams.Err = err
-		h.Res(resParams)
-		return
-	}
-
-	// return conflicts if any
-	if len(results) > 0 {
-		plotutils.UnlockPlots(h.RedisCli, lockOwner)
-		conflicts := make([]string, len(results))
-		for i := 0; i < len(results); i++ {
-			conflicts[i] = results[i].PlotId
+		conflicts := make([]string, 0, len(conflictReasons))
+		for plotIdStr := range conflictReasons {
+			conflicts = append(conflicts, plotIdStr)
 		}
 		resParams.ResData = &struct {
-			Conflicts []string `json:"conflicts"`
-		}{Conflicts: conflicts}
+			Conflicts []string          `json:"conflicts"`
+			Reasons   map[string]string `json:"reasons"`
+		}{Conflicts: conflicts, Reasons: conflictReasons}
 		resParams.Code = http.StatusConflict
 		h.Res(resParams)
 		return
 	}
 
-	// get quantities for each plot depth
+	// get quantities for each plot depth, subplots priced by their parent owner are separate items
 	quantities := make([]int64, config.MAX_DEPTH+1)
-	for i := range plotIds {
-		quantities[plotIds[i].Depth()]++
+	lineItems := []*stripe.CheckoutSessionCreateLineItemParams{}
+	var ownerPriced []string
+	for i, plotId := range plotIds {
+		if claim, ok := subplotClaims[plotId.Id]; ok && claim.Price > 0 {
+			lineItems = append(lineItems, &stripe.CheckoutSessionCreateLineItemParams{
+				PriceData: &stripe.CheckoutSessionCreateLineItemPriceDataParams{
+					Currency:   stripe.String(string(stripe.CurrencyUSD)),
+					UnitAmount: stripe.Int64(claim.Price),
+					ProductData: &stripe.CheckoutSessionCreateLineItemPriceDataProductDataParams{
+						Name: stripe.String("Subplot " + plotIdStrs[i]),
+					},
+				},
+				Quantity: stripe.Int64(1),
+			})
+			ownerPriced = append(ownerPriced, fmt.Sprintf("%s:%d", plotIdStrs[i], claim.Price))
+			continue
+		}
+		quantities[plotId.Depth()]++
 	}
 	// create order
-	lineItems := []*stripe.CheckoutSessionCreateLineItemParams{}
 	for depth, q := range quantities {
 		if q > 0 {
 			lineItems = append(lineItems, &stripe.CheckoutSessionCreateLineItemParams{
@@ -247,7 +246,7 @@ func (h *Handler) CreateCheckoutSession(w http.ResponseWriter, r *http.Request)
 		}
 	}
 
-	// metadata
+	// metadata, owner priced subplots are "plotId:price" pairs so the parent owner can be paid
 	metadata := map[string]string{
 		"uid": uidStr,
 		"lo":  lockOwner,
@@ -255,6 +254,7 @@ func (h *Handler) CreateCheckoutSession(w http.ResponseWriter, r *http.Request)
 	for i, plotId := range plotIdStrs {
 		metadata[fmt.Sprintf("%d", i)] = plotId
 	}
+	plotutils.SetSubplotPricesMetadata(metadata, ownerPriced)
 
 	// create stripe customer for user if needed
 	var stripeCustomerId string
@@ -0,0 +1,163 @@
+package payment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+	"trraformapi/pkg/schemas"
+
+	"github.com/stripe/stripe-go/v82"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// every webhook event is recorded in the stripeEvents ledger before it's handled. an event is
+// handled once, deliveries of a processed event are acknowledged without running it again and a
+// failed event can be re-driven from the ledger by a retry or the stripe_events command
+
+const stripeEventLease = 10 * time.Minute // longer than a webhook request can run
+
+var ErrEventInProgress = errors.New("stripe event is being processed by another delivery")
+var errBadEvent = errors.New("malformed stripe event")
+
+func EnsureStripeEventIndexes(mongoDB *mongo.Database, ctx context.Context) error {
+
+	_, err := mongoDB.Collection("stripeEvents").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "object", Value: 1}, {Key: "type", Value: 1}, {Key: "status", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "receivedAt", Value: 1}}},
+	})
+
+	return err
+
+}
+
+// records and handles an event. processed is false when the event was already processed
+func (h *Handler) ProcessEvent(ctx context.Context, event *stripe.Event, payload []byte) (bool, error) {
+
+	claimed, err := h.claimEvent(ctx, event, payload)
+	if err != nil || !claimed {
+		return false, err
+	}
+
+	eventErr := h.HandleEvent(ctx, event)
+	if err := h.finishEvent(ctx, event.ID, eventErr); err != nil {
+		return false, err
+	}
+
+	return eventErr == nil, eventErr
+
+}
+
+// takes the event for this delivery. new events are inserted, failed events and events whose
+// delivery died are taken over, processed events aren't claimed
+func (h *Handler) claimEvent(ctx context.Context, event *stripe.Event, payload []byte) (bool, error) {
+
+	eventsColl := h.MongoDB.Collection("stripeEvents")
+	now := time.Now().UTC()
+
+	_, err := eventsColl.UpdateOne(ctx,
+		bson.M{"_id": event.ID, "$or": bson.A{
+			bson.M{"status": schemas.STRIPE_EVENT_FAILED},
+			bson.M{"status": schemas.STRIPE_EVENT_PROCESSING, "leaseUntil": bson.M{"$lt": now}},
+		}},
+		bson.M{
+			"$set": bson.M{
+				"status":     schemas.STRIPE_EVENT_PROCESSING,
+				"leaseUntil": now.Add(stripeEventLease),
+				"mtime":      now,
+			},
+			"$inc": bson.M{"attempts": 1},
+			"$setOnInsert": bson.M{
+				"type":       string(event.Type),
+				"object":     eventObject(event),
+				"created":    time.Unix(event.Created, 0).UTC(),
+				"receivedAt": now,
+				"error":      "",
+				"payload":    payload,
+			},
+		},
+		options.UpdateOne().SetUpsert(true),
+	)
+	if err == nil {
+		return true, nil
+	} else if !mongo.IsDuplicateKeyError(err) {
+		return false, err
+	}
+
+	// event exists and can't be claimed
+	var recorded schemas.StripeEvent
+	if err := eventsColl.FindOne(ctx, bson.M{"_id": event.ID},
+		options.FindOne().SetProjection(bson.M{"status": 1}),
+	).Decode(&recorded); err != nil {
+		return false, err
+	}
+	if recorded.Status == schemas.STRIPE_EVENT_PROCESSING {
+		return false, ErrEventInProgress
+	}
+
+	return false, nil
+
+}
+
+func (h *Handler) finishEvent(ctx context.Context, eventId string, eventErr error) error {
+
+	// record the outcome even if the request was canceled
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+
+	status := schemas.STRIPE_EVENT_PROCESSED
+	errStr := ""
+	if eventErr != nil {
+		status = schemas.STRIPE_EVENT_FAILED
+		errStr = eventErr.Error()
+	}
+
+	_, err := h.MongoDB.Collection("stripeEvents").UpdateOne(ctx,
+		bson.M{"_id": eventId, "status": schemas.STRIPE_EVENT_PROCESSING},
+		bson.M{"$set": bson.M{
+			"status":     status,
+			"error":      errStr,
+			"mtime":      time.Now().UTC(),
+			"leaseUntil": time.Time{},
+		}},
+	)
+
+	return err
+
+}
+
+// subscription id for subscription and invoice events, otherwise the id of the event's object
+func eventObject(event *stripe.Event) string {
+
+	switch event.Type {
+	case stripe.EventTypeInvoicePaid:
+		var invoice stripe.Invoice
+		if err := json.Unmarshal(event.Data.Raw, &invoice); err == nil &&
+			invoice.Parent != nil && invoice.Parent.SubscriptionDetails != nil && invoice.Parent.SubscriptionDetails.Subscription != nil {
+			return invoice.Parent.SubscriptionDetails.Subscription.ID
+		}
+	}
+
+	if id, ok := event.Data.Object["id"].(string); ok {
+		return id
+	}
+
+	return ""
+
+}
+
+// a subscription's deletion can be delivered before the invoice that created it,
+// it must not be activated after it was canceled
+func (h *Handler) subscriptionDeleted(ctx context.Context, subscriptionId string) (bool, error) {
+
+	n, err := h.MongoDB.Collection("stripeEvents").CountDocuments(ctx, bson.M{
+		"object": subscriptionId,
+		"type":   string(stripe.EventTypeCustomerSubscriptionDeleted),
+		"status": schemas.STRIPE_EVENT_PROCESSED,
+	}, options.Count().SetLimit(1))
+
+	return n > 0, err
+
+}
@@ -7,7 +7,6 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"strconv"
 	"time"
 	"trraformapi/internal/api"
 	"trraformapi/pkg/config"
@@ -18,7 +17,11 @@ import (
 	"github.com/stripe/stripe-go/v82"
 	"github.com/stripe/stripe-go/v82/webhook"
 	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
 	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"go.mongodb.org/mongo-driver/v2/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/v2/mongo/writeconcern"
+	"go.uber.org/zap"
 )
 
 func (h *Handler) StripeWebhook(w http.ResponseWriter, r *http.Request) {
@@ -42,58 +45,69 @@ func (h *Handler) StripeWebhook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	processed, err := h.ProcessEvent(ctx, &event, payload)
+	if errors.Is(err, ErrEventInProgress) { // stripe retries once the other delivery is done
+		resParams.Code = http.StatusConflict
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	} else if errors.Is(err, errBadEvent) {
+		h.Bad(resParams, err)
+		return
+	} else if err != nil {
+		h.Err(resParams, err)
+		return
+	}
+
+	resParams.ResData = &struct {
+		Duplicate bool `json:"duplicate"`
+	}{Duplicate: !processed}
+	resParams.Code = http.StatusOK
+	h.Res(resParams)
+
+}
+
+// handles a verified event, used by the webhook and to re-drive events from the ledger
+func (h *Handler) HandleEvent(ctx context.Context, event *stripe.Event) error {
+
 	switch event.Type {
 
 	// handle plot purchase success
 	case stripe.EventTypeCheckoutSessionCompleted:
 		var checkoutSession stripe.CheckoutSession
-		if err = json.Unmarshal(event.Data.Raw, &checkoutSession); err != nil {
-			h.Bad(resParams, err)
-			return
+		if err := json.Unmarshal(event.Data.Raw, &checkoutSession); err != nil {
+			return fmt.Errorf("%w: %v", errBadEvent, err)
 		}
 		if checkoutSession.Mode == stripe.CheckoutSessionModePayment {
-			if err := checkoutCompleted(h, ctx, &checkoutSession); err != nil {
-				h.Err(resParams, err)
-				return
+			if checkoutSession.Metadata["type"] == "market" {
+				return marketSaleCompleted(h, ctx, &checkoutSession)
 			}
+			return checkoutCompleted(h, ctx, &checkoutSession)
 		}
 
 	// handle plot purchase failed
 	case stripe.EventTypeCheckoutSessionExpired:
 		var checkoutSession stripe.CheckoutSession
 		if err := json.Unmarshal(event.Data.Raw, &checkoutSession); err != nil {
-			h.Bad(resParams, err)
-			return
+			return fmt.Errorf("%w: %v", errBadEvent, err)
 		}
 		if checkoutSession.Mode == stripe.CheckoutSessionModePayment {
-			if err := checkoutCanceled(h, &checkoutSession); err != nil {
-				resParams.Code = http.StatusInternalServerError
-				resParams.Err = err
-				h.Res(resParams)
-				return
-			}
+			return checkoutCanceled(h, &checkoutSession)
 		}
 
 	// handle subscription creation/cycle
 	case stripe.EventTypeInvoicePaid:
 		var invoice stripe.Invoice
 		if err := json.Unmarshal(event.Data.Raw, &invoice); err != nil {
-			h.Bad(resParams, err)
-			return
+			return fmt.Errorf("%w: %v", errBadEvent, err)
 		}
 		switch invoice.BillingReason {
 
 		case stripe.InvoiceBillingReasonSubscriptionCreate:
-			if err := createSubscription(h, ctx, &invoice); err != nil {
-				h.Err(resParams, err)
-				return
-			}
+			return createSubscription(h, ctx, &invoice)
 
 		case stripe.InvoiceBillingReasonSubscriptionCycle:
-			if err := renewSubscription(h, ctx, &invoice); err != nil {
-				h.Err(resParams, err)
-				return
-			}
+			return renewSubscription(h, ctx, &invoice)
 
 		}
 
@@ -101,18 +115,13 @@ func (h *Handler) StripeWebhook(w http.ResponseWriter, r *http.Request) {
 	case stripe.EventTypeCustomerSubscriptionDeleted:
 		var sub stripe.Subscription
 		if err := json.Unmarshal(event.Data.Raw, &sub); err != nil {
-			h.Bad(resParams, err)
-			return
-		}
-		if err := cancelSubscription(h, ctx, &sub); err != nil {
-			h.Err(resParams, err)
-			return
+			return fmt.Errorf("%w: %v", errBadEvent, err)
 		}
+		return cancelSubscription(h, ctx, &sub)
 
 	}
 
-	resParams.Code = http.StatusOK
-	h.Res(resParams)
+	return nil
 
 }
 
@@ -134,13 +143,11 @@ func checkoutCompleted(h *Handler, ctx context.Context, checkoutSession *stripe.
 
 	// extract purchased plot ids
 	var plotIds []*plotutils.PlotId
-	var plotIdStrs []string
 	for i := range config.MAX_CART_SIZE {
 		plotIdStr, ok := checkoutSession.Metadata[fmt.Sprintf("%d", i)]
 		if !ok {
 			break
 		}
-		plotIdStrs = append(plotIdStrs, plotIdStr)
 		plotId, err := plotutils.PlotIdFromHexString(plotIdStr)
 		if err != nil {
 			return err
@@ -148,40 +155,42 @@ func checkoutCompleted(h *Handler, ctx context.Context, checkoutSession *stripe.
 		plotIds = append(plotIds, plotId)
 	}
 
-	// update and get user
-	var user schemas.User
-	if err := h.MongoDB.Collection("users").FindOneAndUpdate(ctx, bson.M{
-		"_id": uid,
-	}, bson.M{
-		"$addToSet": bson.M{
-			"plotIds": bson.M{
-				"$each": plotIdStrs,
-			},
-			"purchasedIds": bson.M{
-				"$each": plotIdStrs,
-			},
-		},
-	}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&user); err != nil {
+	subplotPrices, err := plotutils.SubplotPricesFromMetadata(checkoutSession.Metadata)
+	if err != nil {
 		return err
 	}
-
-	// create plot entries in mongo
-	plotDocs := make([]schemas.Plot, len(plotIds))
-	now := time.Now().UTC()
-	for i := range plotIds {
-		doc := &plotDocs[i]
-		doc.PlotId = plotIds[i].Id
-		doc.Ctime = now
-	}
-	if _, err := h.MongoDB.Collection("plots").InsertMany(ctx, plotDocs); err != nil {
-		return err
+	var paymentIntentId string
+	if checkoutSession.PaymentIntent != nil {
+		paymentIntentId = checkoutSession.PaymentIntent.ID
 	}
 
-	// create default plot data
-	for _, plotId := range plotIds {
-		if err := plotutils.SetDefaultPlot(h.RedisCli, h.R2Cli, ctx, plotId, &user); err != nil {
+	// plots stay locked by the checkout session and subplots are rechecked by the claim,
+	// which records what parent owners are owed for their subplots
+	_, err = plotutils.ClaimPlots(h.MongoDB, h.RedisCli, h.R2Cli, ctx, &plotutils.ClaimRequest{
+		Uid:           uid,
+		PlotIds:       plotIds,
+		Source:        schemas.PLOT_SOURCE_PURCHASE,
+		LockOwner:     lockOwner,
+		SubplotPrices: subplotPrices,
+		Session:       checkoutSession.ID,
+		PaymentIntent: paymentIntentId,
+	})
+
+	// lock expired and plots were claimed by someone else before payment went through, refund buyer
+	var conflictErr *plotutils.ClaimConflictError
+	if errors.As(err, &conflictErr) {
+		if err := refundCheckout(h, ctx, checkoutSession); err != nil {
 			return err
 		}
+		_, err := plotutils.UnlockPlots(h.RedisCli, lockOwner)
+		return err
+	} else if err != nil {
+		return err
+	}
+
+	// the purchase is fulfilled, payouts that can't be paid yet stay owed for reconcile
+	if err := SettleSubplotPayouts(h.StripeCli, h.MongoDB, ctx, bson.M{"session": checkoutSession.ID}); err != nil {
+		h.Logger.Error("Error settling subplot payouts", zap.String("session", checkoutSession.ID), zap.Error(err))
 	}
 
 	_, err = plotutils.UnlockPlots(h.RedisCli, lockOwner)
@@ -193,6 +202,22 @@ func checkoutCompleted(h *Handler, ctx context.Context, checkoutSession *stripe.
 
 }
 
+func refundCheckout(h *Handler, ctx context.Context, checkoutSession *stripe.CheckoutSession) error {
+
+	if checkoutSession.PaymentIntent == nil {
+		return errors.New("checkout session has no payment intent")
+	}
+
+	params := &stripe.RefundCreateParams{
+		PaymentIntent: stripe.String(checkoutSession.PaymentIntent.ID),
+	}
+	params.SetIdempotencyKey("refund:" + checkoutSession.ID)
+
+	_, err := h.StripeCli.V1Refunds.Create(ctx, params)
+	return err
+
+}
+
 func checkoutCanceled(h *Handler, checkoutSession *stripe.CheckoutSession) error {
 
 	// cart session id
@@ -201,6 +226,20 @@ func checkoutCanceled(h *Handler, checkoutSession *stripe.CheckoutSession) error
 		return errors.New("sid field missing from payment intent metadata")
 	}
 
+	// put abandoned marketplace listing back up for sale
+	if checkoutSession.Metadata["type"] == "market" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if _, err := h.MongoDB.Collection("listings").UpdateOne(ctx, bson.M{
+			"stripeSession": checkoutSession.ID,
+			"status":        schemas.LISTING_PENDING,
+		}, bson.M{
+			"$set": bson.M{"status": schemas.LISTING_ACTIVE},
+		}); err != nil {
+			return err
+		}
+	}
+
 	_, err := plotutils.UnlockPlots(h.RedisCli, lockOwner)
 	if err != nil {
 		return err
@@ -210,6 +249,154 @@ func checkoutCanceled(h *Handler, checkoutSession *stripe.CheckoutSession) error
 
 }
 
+func marketSaleCompleted(h *Handler, ctx context.Context, checkoutSession *stripe.CheckoutSession) error {
+
+	// extract buyer, listing and lock owner
+	uidStr, ok := checkoutSession.Metadata["uid"]
+	if !ok {
+		return errors.New("uid field missing from checkout session metadata")
+	}
+	uid, err := bson.ObjectIDFromHex(uidStr)
+	if err != nil {
+		return err
+	}
+	listingId, err := bson.ObjectIDFromHex(checkoutSession.Metadata["listing"])
+	if err != nil {
+		return err
+	}
+	lockOwner, ok := checkoutSession.Metadata["lo"]
+	if !ok {
+		return errors.New("lo field missing from checkout session metadata")
+	}
+
+	listingsColl := h.MongoDB.Collection("listings")
+	var listing schemas.Listing
+	if err := listingsColl.FindOne(ctx, bson.M{"_id": listingId}).Decode(&listing); err != nil {
+		return err
+	}
+
+	// already handled (webhook retry)
+	if listing.Status == schemas.LISTING_SOLD && listing.StripeSession == checkoutSession.ID {
+		return nil
+	}
+
+	// listing was canceled or went to another buyer after checkout expired
+	if listing.StripeSession != checkoutSession.ID || listing.Status == schemas.LISTING_SOLD || listing.Status == schemas.LISTING_CANCELED {
+		if err := refundSale(h, ctx, checkoutSession); err != nil {
+			return err
+		}
+		_, err := plotutils.UnlockPlots(h.RedisCli, lockOwner)
+		return err
+	}
+
+	plotId, err := plotutils.PlotIdFromHexString(listing.PlotId)
+	if err != nil {
+		return err
+	}
+
+	// create transaction session
+	txSession, err := h.MongoDB.Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer txSession.EndSession(ctx)
+	txOpts := options.Transaction().SetReadConcern(readconcern.Snapshot()).SetWriteConcern(writeconcern.Majority())
+
+	var versionKeys []string
+	_, err = txSession.WithTransaction(ctx, func(txCtx context.Context) (interface{}, error) {
+
+		res, err := listingsColl.UpdateOne(txCtx, bson.M{
+			"_id":           listingId,
+			"stripeSession": checkoutSession.ID,
+			"status":        bson.M{"$in": bson.A{schemas.LISTING_ACTIVE, schemas.LISTING_PENDING}},
+		}, bson.M{
+			"$set": bson.M{
+				"status": schemas.LISTING_SOLD,
+				"buyer":  uid,
+				"soldAt": time.Now().UTC(),
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if res.MatchedCount == 0 {
+			return nil, mongo.ErrNoDocuments
+		}
+
+		versionKeys, err = plotutils.TransferPlot(h.MongoDB, txCtx, plotId, listing.Seller, uid, "sale")
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, nil
+
+	}, txOpts)
+
+	// sale can't go through, refund buyer
+	if errors.Is(err, plotutils.ErrNotPlotOwner) || errors.Is(err, plotutils.ErrPlotLimit) {
+		status := schemas.LISTING_ACTIVE
+		if errors.Is(err, plotutils.ErrNotPlotOwner) {
+			status = schemas.LISTING_CANCELED
+		}
+		if _, err := listingsColl.UpdateOne(ctx, bson.M{"_id": listingId}, bson.M{
+			"$set": bson.M{"status": status},
+		}); err != nil {
+			return err
+		}
+		if err := refundSale(h, ctx, checkoutSession); err != nil {
+			return err
+		}
+		_, err := plotutils.UnlockPlots(h.RedisCli, lockOwner)
+		return err
+	} else if errors.Is(err, mongo.ErrNoDocuments) { // completed concurrently
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	// the sale has committed and a retry returns before reaching the steps after it, so they're
+	// logged rather than failing the event. reconcile repairs stale owner metadata
+	logFields := []zap.Field{zap.String("plotId", listing.PlotId), zap.String("session", checkoutSession.ID)}
+
+	// the seller's history is already gone from mongo, leftover objects can't be read
+	if err := plotutils.DeleteVersionObjects(h.R2Cli, ctx, versionKeys); err != nil {
+		h.Logger.Error("Error deleting version objects", append(logFields, zap.Error(err))...)
+	}
+
+	// update owner shown on the map
+	var user schemas.User
+	if err := h.MongoDB.Collection("users").FindOne(ctx, bson.M{"_id": uid}).Decode(&user); err != nil {
+		h.Logger.Error("Error getting buyer", append(logFields, zap.Error(err))...)
+	} else if err := plotutils.UpdatePlotMetadata(h.RedisCli, h.R2Cli, ctx, plotId, plotutils.PlotMetadata(&user)); err != nil {
+		h.Logger.Error("Error updating plot metadata", append(logFields, zap.Error(err))...)
+	} else if err := utils.PurgeCacheCDN(h.HttpCli, ctx, []string{config.PLOT_CDN_URL + "/" + listing.PlotId + ".dat"}); err != nil {
+		h.Logger.Error("Error purging plot cache", append(logFields, zap.Error(err))...)
+	}
+
+	_, err = plotutils.UnlockPlots(h.RedisCli, lockOwner)
+	return err
+
+}
+
+// refunds the buyer and takes the seller's transfer and the fee back
+func refundSale(h *Handler, ctx context.Context, checkoutSession *stripe.CheckoutSession) error {
+
+	if checkoutSession.PaymentIntent == nil {
+		return errors.New("checkout session has no payment intent")
+	}
+
+	params := &stripe.RefundCreateParams{
+		PaymentIntent:        stripe.String(checkoutSession.PaymentIntent.ID),
+		ReverseTransfer:      stripe.Bool(true),
+		RefundApplicationFee: stripe.Bool(true),
+	}
+	params.SetIdempotencyKey("refund:" + checkoutSession.ID)
+
+	_, err := h.StripeCli.V1Refunds.Create(ctx, params)
+	return err
+
+}
+
 func createSubscription(h *Handler, ctx context.Context, invoice *stripe.Invoice) error {
 
 	uidStr, ok := invoice.Parent.SubscriptionDetails.Metadata["uid"]
@@ -221,6 +408,15 @@ func createSubscription(h *Handler, ctx context.Context, invoice *stripe.Invoice
 		return err
 	}
 
+	// canceled before this invoice was delivered, only the invoice is recorded
+	deleted, err := h.subscriptionDeleted(ctx, invoice.Parent.SubscriptionDetails.Subscription.ID)
+	if err != nil {
+		return err
+	}
+	if deleted {
+		return renewSubscription(h, ctx, invoice)
+	}
+
 	var user schemas.User
 	if err := h.MongoDB.Collection("users").FindOneAndUpdate(ctx, bson.M{
 		"_id": uid,
@@ -234,14 +430,11 @@ func createSubscription(h *Handler, ctx context.Context, invoice *stripe.Invoice
 	}
 
 	// set metadata verified true for all plots
-	for _, plotId := range user.PlotIds {
-		metadata := map[string]string{
-			"owner":    user.Username,
-			"verified": strconv.FormatBool(true),
-		}
-		if err := utils.UpdateMetadataR2(h.R2Cli, ctx, config.CF_PLOT_BUCKET, plotId+".dat", "application/octet-stream", metadata); err != nil {
-			return err
-		}
+	if err := plotutils.UpdatePlotsMetadata(h.RedisCli, h.R2Cli, ctx, &user); err != nil {
+		return err
+	}
+	if err := plotutils.UpdatePlotsSearchFields(h.MongoDB, ctx, &user); err != nil {
+		return err
 	}
 
 	return renewSubscription(h, ctx, invoice)
@@ -324,19 +517,16 @@ func cancelSubscription(h *Handler, ctx context.Context, subscription *stripe.Su
 		"$set": bson.M{
 			"subscription.isActive": false,
 		},
-	}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(user); err != nil {
+	}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&user); err != nil {
 		return err
 	}
 
 	// set metadata verified false for all plots
-	for _, plotId := range user.PlotIds {
-		metadata := map[string]string{
-			"owner":    user.Username,
-			"verified": strconv.FormatBool(false),
-		}
-		if err := utils.UpdateMetadataR2(h.R2Cli, ctx, config.CF_PLOT_BUCKET, plotId+".dat", "application/octet-stream", metadata); err != nil {
-			return err
-		}
+	if err := plotutils.UpdatePlotsMetadata(h.RedisCli, h.R2Cli, ctx, &user); err != nil {
+		return err
+	}
+	if err := plotutils.UpdatePlotsSearchFields(h.MongoDB, ctx, &user); err != nil {
+		return err
 	}
 
 	return nil
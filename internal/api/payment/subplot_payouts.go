@@ -0,0 +1,90 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"trraformapi/pkg/schemas"
+
+	"github.com/stripe/stripe-go/v82"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+var errNoPayoutAccount = errors.New("parent owner has no connect account")
+
+// pays the owed subplot payouts matching filter by connect transfers from the charge of the checkout
+// that sold the subplot. each payout is tried, payouts that fail (a parent owner without a connect
+// account) stay owed for reconcile and their errors are joined
+func SettleSubplotPayouts(stripeCli *stripe.Client, mongoDB *mongo.Database, ctx context.Context, filter bson.M) error {
+
+	filter["status"] = schemas.SUBPLOT_PAYOUT_OWED
+	cursor, err := mongoDB.Collection("subplotPayouts").Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	var payouts []schemas.SubplotPayout
+	if err := cursor.All(ctx, &payouts); err != nil {
+		return err
+	}
+
+	var errs []error
+	for i := range payouts {
+		if err := settleSubplotPayout(stripeCli, mongoDB, ctx, &payouts[i]); err != nil {
+			errs = append(errs, fmt.Errorf("payout %s for %s: %w", payouts[i].Id.Hex(), payouts[i].PlotId, err))
+		}
+	}
+
+	return errors.Join(errs...)
+
+}
+
+func settleSubplotPayout(stripeCli *stripe.Client, mongoDB *mongo.Database, ctx context.Context, payout *schemas.SubplotPayout) error {
+
+	var payee schemas.User
+	if err := mongoDB.Collection("users").FindOne(ctx,
+		bson.M{"_id": payout.Payee},
+		options.FindOne().SetProjection(bson.M{"stripeConnect": 1}),
+	).Decode(&payee); err != nil {
+		return err
+	}
+	if payee.StripeConnect == "" {
+		return errNoPayoutAccount
+	}
+
+	paymentIntent, err := stripeCli.V1PaymentIntents.Retrieve(ctx, payout.PaymentIntent, nil)
+	if err != nil {
+		return err
+	}
+	if paymentIntent.LatestCharge == nil {
+		return errors.New("payment intent has no charge")
+	}
+
+	// the idempotency key makes a transfer whose status update failed safe to retry
+	params := &stripe.TransferCreateParams{
+		Amount:            stripe.Int64(payout.Amount),
+		Currency:          stripe.String(string(stripe.CurrencyUSD)),
+		Destination:       stripe.String(payee.StripeConnect),
+		SourceTransaction: stripe.String(paymentIntent.LatestCharge.ID),
+		TransferGroup:     stripe.String(payout.Session),
+		Metadata:          map[string]string{"plotId": payout.PlotId},
+	}
+	params.SetIdempotencyKey("subplot:" + payout.Session + ":" + payout.PlotId)
+	transfer, err := stripeCli.V1Transfers.Create(ctx, params)
+	if err != nil {
+		return err
+	}
+
+	_, err = mongoDB.Collection("subplotPayouts").UpdateOne(ctx,
+		bson.M{"_id": payout.Id, "status": schemas.SUBPLOT_PAYOUT_OWED},
+		bson.M{"$set": bson.M{
+			"status":   schemas.SUBPLOT_PAYOUT_PAID,
+			"transfer": transfer.ID,
+			"paidAt":   time.Now().UTC(),
+		}},
+	)
+	return err
+
+}
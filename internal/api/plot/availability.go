@@ -0,0 +1,176 @@
+package plot
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"trraformapi/internal/api"
+	"trraformapi/pkg/config"
+	"trraformapi/pkg/geometry"
+	plotutils "trraformapi/pkg/plot_utils"
+)
+
+const (
+	maxRandomOpenPlots = 50
+	nearChunkRadius    = 12 // voxels
+)
+
+// availability of up to MAX_CART_SIZE plots, so a cart can be checked before checkout
+func (h *Handler) CheckAvailability(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	ctx := r.Context()
+	resParams := &api.ResParams{W: w, R: r}
+
+	var reqData struct {
+		PlotIds []string `json:"plotIds" validate:"required,min=1,cartsize,dive,plotid"`
+	}
+
+	// validate request body
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&reqData); err != nil {
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	resParams.ReqData = reqData
+	if err := h.Validate.Struct(&reqData); err != nil {
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	plotIds := make([]*plotutils.PlotId, len(reqData.PlotIds))
+	for i, plotIdStr := range reqData.PlotIds {
+		plotIds[i], _ = plotutils.PlotIdFromHexString(plotIdStr)
+	}
+	available, err := plotutils.PlotsAvailable(h.RedisCli, ctx, plotIds)
+	if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	// keyed by the ids as sent
+	availability := make(map[string]bool, len(plotIds))
+	for i, plotIdStr := range reqData.PlotIds {
+		availability[plotIdStr] = available[i]
+	}
+
+	resParams.ResData = &struct {
+		Available map[string]bool `json:"available"`
+	}{Available: availability}
+	resParams.Code = http.StatusOK
+	h.Res(resParams)
+
+}
+
+// n random available plots at a depth, plots in and near the given chunk first
+func (h *Handler) GetRandomOpenPlots(w http.ResponseWriter, r *http.Request) {
+
+	ctx := r.Context()
+	resParams := &api.ResParams{W: w, R: r}
+	query := r.URL.Query()
+	resParams.ReqData = query
+
+	depth := 0
+	if depthStr := query.Get("depth"); depthStr != "" {
+		var err error
+		depth, err = strconv.Atoi(depthStr)
+		if err != nil || depth < 0 || depth > config.MAX_DEPTH {
+			resParams.Code = http.StatusBadRequest
+			resParams.Err = err
+			h.Res(resParams)
+			return
+		}
+	}
+	n := 1
+	if nStr := query.Get("n"); nStr != "" {
+		var err error
+		n, err = strconv.Atoi(nStr)
+		if err != nil || n < 1 || n > maxRandomOpenPlots {
+			resParams.Code = http.StatusBadRequest
+			resParams.Err = err
+			h.Res(resParams)
+			return
+		}
+	}
+
+	var candidates []*plotutils.PlotId
+	if chunkId := query.Get("chunk"); chunkId != "" {
+		chunkPlots, err := plotutils.ChunkPlotIds(chunkId)
+		if err != nil {
+			resParams.Code = http.StatusBadRequest
+			resParams.Err = err
+			h.Res(resParams)
+			return
+		}
+		for _, plotId := range chunkPlots {
+			if plotId.Depth() == depth {
+				candidates = append(candidates, plotId)
+			}
+		}
+		if len(candidates) == 0 {
+			resParams.Code = http.StatusBadRequest
+			resParams.Err = fmt.Errorf("chunk %q has no plots at depth %d", chunkId, depth)
+			h.Res(resParams)
+			return
+		}
+
+		// depth 0 chunks are followed by the plots around them, nearest first
+		if depth == 0 {
+			chunk, _ := geometry.Chunk(candidates[0].Id)
+			center, _ := geometry.ChunkCenter(chunk)
+			for _, id := range geometry.Within(center, nearChunkRadius) {
+				candidates = append(candidates, &plotutils.PlotId{Id: id})
+			}
+		}
+	}
+
+	plotIds, err := plotutils.RandomAvailablePlots(h.RedisCli, ctx, depth, n, candidates)
+	if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	plotIdStrs := make([]string, len(plotIds))
+	for i, plotId := range plotIds {
+		plotIdStrs[i] = plotId.ToString()
+	}
+
+	resParams.ResData = &struct {
+		PlotIds []string `json:"plotIds"`
+	}{PlotIds: plotIdStrs}
+	resParams.Code = http.StatusOK
+	h.Res(resParams)
+
+}
+
+// unclaimed plot count for each depth, indexed by depth
+func (h *Handler) GetOpenPlotCounts(w http.ResponseWriter, r *http.Request) {
+
+	ctx := r.Context()
+	resParams := &api.ResParams{W: w, R: r}
+
+	counts, err := plotutils.OpenPlotCounts(h.RedisCli, ctx)
+	if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	resParams.ResData = &struct {
+		Counts []int64 `json:"counts"`
+	}{Counts: counts}
+	resParams.Code = http.StatusOK
+	h.Res(resParams)
+
+}
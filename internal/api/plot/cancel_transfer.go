@@ -0,0 +1,66 @@
+package plot
+
+import (
+	"encoding/json"
+	"net/http"
+	"trraformapi/internal/api"
+	"trraformapi/pkg/schemas"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func (h *Handler) CancelTransfer(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	ctx := r.Context()
+	uid := ctx.Value("uid").(bson.ObjectID)
+	resParams := &api.ResParams{W: w, R: r}
+
+	var reqData struct {
+		TransferId string `json:"transferId" validate:"required,mongodb"`
+	}
+
+	// validate request body
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&reqData); err != nil {
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	resParams.ReqData = reqData
+	if err := h.Validate.Struct(&reqData); err != nil {
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	transferId, _ := bson.ObjectIDFromHex(reqData.TransferId)
+	res, err := h.MongoDB.Collection("transfers").UpdateOne(ctx,
+		bson.M{
+			"_id":    transferId,
+			"from":   uid,
+			"status": schemas.TRANSFER_PENDING,
+		},
+		bson.M{
+			"$set": bson.M{"status": schemas.TRANSFER_CANCELED},
+		},
+	)
+	if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	if res.MatchedCount == 0 {
+		resParams.Code = http.StatusNotFound
+		h.Res(resParams)
+		return
+	}
+
+	resParams.Code = http.StatusOK
+	h.Res(resParams)
+
+}
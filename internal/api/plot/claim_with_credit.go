@@ -1,23 +1,18 @@
 package plot
 
 import (
-	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
-	"time"
 	"trraformapi/internal/api"
 	plotutils "trraformapi/pkg/plot_utils"
 	"trraformapi/pkg/schemas"
 
-	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/v2/bson"
-	"go.mongodb.org/mongo-driver/v2/mongo"
-	"go.mongodb.org/mongo-driver/v2/mongo/options"
-	"go.mongodb.org/mongo-driver/v2/mongo/readconcern"
-	"go.mongodb.org/mongo-driver/v2/mongo/writeconcern"
 )
 
+// claims plots with the free plot and plot credits. the free plot is used first.
+// claims are all or nothing, if any plot conflicts none are claimed and every plot gets a result
 func (h *Handler) ClaimWithCredit(w http.ResponseWriter, r *http.Request) {
 
 	defer r.Body.Close()
@@ -26,7 +21,8 @@ func (h *Handler) ClaimWithCredit(w http.ResponseWriter, r *http.Request) {
 	resParams := &api.ResParams{W: w, R: r}
 
 	var reqData struct {
-		PlotId string `json:"plotId" validate:"required,plotid"`
+		PlotId  string   `json:"plotId" validate:"required_without=PlotIds,excluded_with=PlotIds,omitempty,plotid"`
+		PlotIds []string `json:"plotIds" validate:"required_without=PlotId,omitempty,cartsize,dive,plotid"`
 	}
 
 	// validate request body
@@ -46,121 +42,65 @@ func (h *Handler) ClaimWithCredit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	plotId, _ := plotutils.PlotIdFromHexString(reqData.PlotId)
-	plotIdStr := plotId.ToString()
-	lockOwner := uuid.NewString()
+	// single plotId is kept for older clients, it can't be sent alongside plotIds
+	plotIdStrs := reqData.PlotIds
+	if reqData.PlotId != "" {
+		plotIdStrs = []string{reqData.PlotId}
+	}
+	plotIds := make([]*plotutils.PlotId, len(plotIdStrs))
+	for i := range plotIdStrs {
+		plotIds[i], _ = plotutils.PlotIdFromHexString(plotIdStrs[i])
+	}
 
-	// lock plot to prevent duplicate claims
-	failedIds, err := plotutils.LockPlots(h.RedisCli, ctx, []string{plotIdStr}, lockOwner)
-	if err != nil {
-		resParams.Code = http.StatusInternalServerError
-		resParams.Err = err
+	res, err := plotutils.ClaimPlots(h.MongoDB, h.RedisCli, h.R2Cli, ctx, &plotutils.ClaimRequest{
+		Uid:     uid,
+		PlotIds: plotIds,
+		Source:  schemas.PLOT_SOURCE_CREDIT,
+	})
+
+	var conflictErr *plotutils.ClaimConflictError
+	if errors.As(err, &conflictErr) {
+		resParams.ResData = &struct {
+			Conflict bool              `json:"conflict"`
+			Results  map[string]string `json:"results"`
+		}{Conflict: true, Results: conflictErr.Results}
+		resParams.Code = http.StatusConflict
 		h.Res(resParams)
 		return
-	}
-	if len(failedIds) > 0 {
+	} else if errors.Is(err, plotutils.ErrDuplicatePlotId) {
 		resParams.ResData = &struct {
-			Conflict bool `json:"conflict"`
-		}{Conflict: true}
-		resParams.Code = http.StatusConflict
+			DuplicatePlotId bool `json:"duplicatePlotId"`
+		}{DuplicatePlotId: true}
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = err
 		h.Res(resParams)
 		return
-	}
-	defer plotutils.UnlockPlots(h.RedisCli, lockOwner)
-
-	// create transaction session
-	txSession, err := h.MongoDB.Client().StartSession()
-	if err != nil {
-		resParams.Code = http.StatusInternalServerError
+	} else if errors.Is(err, plotutils.ErrInsufficientCredits) {
+		resParams.ResData = &struct {
+			InsufficientCredits bool `json:"insufficientCredits"`
+		}{InsufficientCredits: true}
+		resParams.Code = http.StatusBadRequest
 		resParams.Err = err
 		h.Res(resParams)
 		return
-	}
-	defer txSession.EndSession(ctx)
-	txOpts := options.Transaction().SetReadConcern(readconcern.Snapshot()).SetWriteConcern(writeconcern.Majority())
-
-	var updatedUser schemas.User
-
-	_, err = txSession.WithTransaction(ctx, func(txCtx context.Context) (interface{}, error) {
-
-		userColl := h.MongoDB.Collection("users")
-
-		// user free plot first
-		err := userColl.FindOneAndUpdate(txCtx,
-			bson.M{
-				"_id":      uid,
-				"freePlot": "",
-			},
-			bson.M{
-				"$set": bson.M{
-					"freePlot": plotIdStr,
-				},
-				"$addToSet": bson.M{
-					"plotIds": plotIdStr,
-				},
-			},
-			options.FindOneAndUpdate().SetReturnDocument(options.After),
-		).Decode(&updatedUser)
-
-		// use credit if free plot
-		if errors.Is(err, mongo.ErrNoDocuments) {
-			err = h.MongoDB.Collection("users").FindOneAndUpdate(txCtx,
-				bson.M{
-					"_id":         uid,
-					"plotCredits": bson.M{"$gt": 0},
-				},
-				bson.M{
-					"$inc": bson.M{
-						"plotCredits": -1,
-					},
-					"$addToSet": bson.M{
-						"plotIds": plotIdStr,
-					},
-				},
-				options.FindOneAndUpdate().SetReturnDocument(options.After),
-			).Decode(&updatedUser)
-		}
-
-		if err != nil {
-			return nil, err
-		}
-
-		// claim plot
-		plotEntry := schemas.Plot{
-			PlotId: plotId.Id,
-			Ctime:  time.Now().UTC(),
-			Owner:  uid,
-			Votes:  0,
-		}
-		if _, err := h.MongoDB.Collection("plots").InsertOne(txCtx, &plotEntry); err != nil {
-			return nil, err
-		}
-
-		return nil, nil
-
-	}, txOpts)
-
-	if err != nil {
-		if errors.Is(err, mongo.ErrNoDocuments) || mongo.IsDuplicateKeyError(err) {
-			resParams.ResData = &struct {
-				Conflict bool `json:"conflict"`
-			}{Conflict: true}
-			resParams.Code = http.StatusConflict
-		} else {
-			resParams.Code = http.StatusInternalServerError
-		}
+	} else if errors.Is(err, plotutils.ErrPlotLimit) {
+		resParams.ResData = &struct {
+			PlotLimitExceeded bool `json:"plotLimitExceeded"`
+		}{PlotLimitExceeded: true}
+		resParams.Code = http.StatusBadRequest
 		resParams.Err = err
 		h.Res(resParams)
 		return
-	}
-
-	if err := plotutils.SetDefaultPlot(h.RedisCli, h.R2Cli, ctx, plotId, &updatedUser); err != nil {
+	} else if err != nil {
 		resParams.Code = http.StatusInternalServerError
 		resParams.Err = err
 		h.Res(resParams)
 		return
 	}
 
+	resParams.ResData = &struct {
+		Results map[string]string `json:"results"`
+	}{Results: res.Results}
 	resParams.Code = http.StatusOK
 	h.Res(resParams)
 
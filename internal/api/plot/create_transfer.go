@@ -0,0 +1,186 @@
+package plot
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+	"trraformapi/internal/api"
+	"trraformapi/pkg/config"
+	plotutils "trraformapi/pkg/plot_utils"
+	"trraformapi/pkg/schemas"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+func (h *Handler) CreateTransfer(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	ctx := r.Context()
+	uid := ctx.Value("uid").(bson.ObjectID)
+	resParams := &api.ResParams{W: w, R: r}
+
+	var reqData struct {
+		PlotId     string `json:"plotId" validate:"required,plotid"`
+		ToUsername string `json:"toUsername" validate:"required,username"`
+	}
+
+	// validate request body
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&reqData); err != nil {
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	resParams.ReqData = reqData
+	if err := h.Validate.Struct(&reqData); err != nil {
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	plotId, _ := plotutils.PlotIdFromHexString(reqData.PlotId)
+	plotIdStr := plotId.ToString()
+	usersColl := h.MongoDB.Collection("users")
+
+	// hold the plot's claim lock across the checks and insert, a release or sale can't land in between
+	lockOwner := uuid.NewString()
+	failedIds, err := plotutils.LockPlots(h.RedisCli, ctx, []string{plotIdStr}, lockOwner)
+	if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	defer plotutils.UnlockPlots(h.RedisCli, lockOwner)
+	if len(failedIds) > 0 {
+		resParams.ResData = &struct {
+			Conflict bool `json:"conflict"`
+		}{Conflict: true}
+		resParams.Code = http.StatusConflict
+		h.Res(resParams)
+		return
+	}
+
+	// check that user owns plot
+	if err := usersColl.FindOne(ctx, bson.M{
+		"_id":     uid,
+		"plotIds": plotIdStr,
+	}).Err(); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			resParams.Code = http.StatusUnauthorized
+		} else {
+			resParams.Code = http.StatusInternalServerError
+		}
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	// find recipient
+	var recipient schemas.User
+	if err := usersColl.FindOne(ctx, bson.M{"username": reqData.ToUsername}).Decode(&recipient); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			resParams.ResData = &struct {
+				UserNotFound bool `json:"userNotFound"`
+			}{UserNotFound: true}
+			resParams.Code = http.StatusNotFound
+		} else {
+			resParams.Code = http.StatusInternalServerError
+		}
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	if recipient.Id == uid {
+		resParams.Code = http.StatusBadRequest
+		h.Res(resParams)
+		return
+	}
+
+	// only one pending transfer per plot, enforced by an index once expired ones are out of the way
+	if err := plotutils.ExpireTransfers(h.MongoDB, ctx, plotIdStr); err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	now := time.Now().UTC()
+	transfersColl := h.MongoDB.Collection("transfers")
+	err = transfersColl.FindOne(ctx, bson.M{
+		"plotId":    plotIdStr,
+		"status":    schemas.TRANSFER_PENDING,
+		"expiresAt": bson.M{"$gt": now},
+	}).Err()
+	if err == nil {
+		resParams.ResData = &struct {
+			Conflict bool `json:"conflict"`
+		}{Conflict: true}
+		resParams.Code = http.StatusConflict
+		h.Res(resParams)
+		return
+	} else if !errors.Is(err, mongo.ErrNoDocuments) {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	// plot can't be gifted while listed for sale
+	err = h.MongoDB.Collection("listings").FindOne(ctx, bson.M{
+		"plotId": plotIdStr,
+		"status": bson.M{"$in": bson.A{schemas.LISTING_ACTIVE, schemas.LISTING_PENDING}},
+	}).Err()
+	if err == nil {
+		resParams.ResData = &struct {
+			Listed bool `json:"listed"`
+		}{Listed: true}
+		resParams.Code = http.StatusConflict
+		h.Res(resParams)
+		return
+	} else if !errors.Is(err, mongo.ErrNoDocuments) {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	transfer := schemas.PlotTransfer{
+		PlotId:    plotIdStr,
+		From:      uid,
+		To:        recipient.Id,
+		Status:    schemas.TRANSFER_PENDING,
+		Ctime:     now,
+		ExpiresAt: now.Add(config.TRANSFER_DURATION),
+	}
+	res, err := transfersColl.InsertOne(ctx, &transfer)
+	if mongo.IsDuplicateKeyError(err) {
+		resParams.ResData = &struct {
+			Conflict bool `json:"conflict"`
+		}{Conflict: true}
+		resParams.Code = http.StatusConflict
+		h.Res(resParams)
+		return
+	} else if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	resParams.ResData = &struct {
+		TransferId string    `json:"transferId"`
+		ExpiresAt  time.Time `json:"expiresAt"`
+	}{
+		TransferId: res.InsertedID.(bson.ObjectID).Hex(),
+		ExpiresAt:  transfer.ExpiresAt,
+	}
+	resParams.Code = http.StatusOK
+	h.Res(resParams)
+
+}
@@ -0,0 +1,137 @@
+package plot
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"trraformapi/internal/api"
+	"trraformapi/pkg/config"
+	plotutils "trraformapi/pkg/plot_utils"
+	"trraformapi/pkg/render"
+	"trraformapi/pkg/schemas"
+	"trraformapi/pkg/utils"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+var exportFormats = map[string]struct{ ext, contentType string }{
+	"vox":  {"vox", "application/octet-stream"},
+	"gltf": {"glb", "model/gltf-binary"},
+	"obj":  {"obj", "model/obj"},
+}
+
+// exports a plot build as MagicaVoxel .vox, binary gltf or obj. plots of owners hiding their
+// plots can only be exported by the owner and collaborators, every other plot is public
+func (h *Handler) ExportPlot(w http.ResponseWriter, r *http.Request) {
+
+	ctx := r.Context()
+	resParams := &api.ResParams{W: w, R: r}
+
+	plotIdStr := chi.URLParam(r, "id")
+	formatStr := r.URL.Query().Get("format")
+	resParams.ReqData = map[string]string{"plotId": plotIdStr, "format": formatStr}
+
+	plotId, err := plotutils.PlotIdFromHexString(plotIdStr)
+	if err != nil || !plotId.Validate() {
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	format, ok := exportFormats[formatStr]
+	if !ok {
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = fmt.Errorf("unsupported export format %q", formatStr)
+		h.Res(resParams)
+		return
+	}
+
+	var owner schemas.User
+	if err := h.MongoDB.Collection("users").FindOne(ctx, bson.M{"plotIds": plotId.ToString()}).Decode(&owner); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			resParams.Code = http.StatusNotFound
+		} else {
+			resParams.Code = http.StatusInternalServerError
+		}
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	// token is optional, only needed for hidden plots
+	if owner.Privacy.HidePlots {
+		authToken, err := utils.ValidateAuthToken(r)
+		if err != nil {
+			resParams.Code = http.StatusUnauthorized
+			resParams.Err = err
+			h.Res(resParams)
+			return
+		}
+		uid, err := authToken.GetUidObjectId()
+		if err != nil {
+			resParams.Code = http.StatusUnauthorized
+			resParams.Err = err
+			h.Res(resParams)
+			return
+		}
+		if _, _, err := h.plotAccess(ctx, uid, plotId); err != nil {
+			if errors.Is(err, errNoPlotAccess) {
+				resParams.Code = http.StatusUnauthorized
+			} else {
+				resParams.Code = http.StatusInternalServerError
+			}
+			resParams.Err = err
+			h.Res(resParams)
+			return
+		}
+	}
+
+	data, _, err := utils.GetObjectR2(h.R2Cli, ctx, config.CF_PLOT_BUCKET, plotId.ToString()+".dat")
+	if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	plotData, err := plotutils.Decode(data)
+	if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	var out bytes.Buffer
+	switch formatStr {
+	case "vox":
+		err = render.WriteVOX(&out, plotData.BuildData)
+	case "gltf", "obj":
+		var quads []render.Quad
+		quads, err = render.GreedyMesh(plotData.BuildData)
+		if err == nil && formatStr == "gltf" {
+			err = render.WriteGLB(&out, quads)
+		} else if err == nil {
+			err = render.WriteOBJ(&out, quads)
+		}
+	}
+	if errors.Is(err, render.ErrNoPalette) {
+		resParams.Code = http.StatusServiceUnavailable
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	} else if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	w.Header().Set("Content-Type", format.contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, plotId.ToString(), format.ext))
+	w.WriteHeader(http.StatusOK)
+	w.Write(out.Bytes())
+
+}
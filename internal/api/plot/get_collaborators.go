@@ -0,0 +1,193 @@
+package plot
+
+import (
+	"errors"
+	"net/http"
+	"time"
+	"trraformapi/internal/api"
+	plotutils "trraformapi/pkg/plot_utils"
+	"trraformapi/pkg/schemas"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type collaboratorRes struct {
+	Username string    `json:"username"`
+	Role     string    `json:"role"`
+	AddedAt  time.Time `json:"addedAt"`
+}
+
+type collabInviteRes struct {
+	InviteId  string    `json:"inviteId"`
+	PlotId    string    `json:"plotId"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Role      string    `json:"role"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// collaborators and pending invites of a plot, visible to its owner and collaborators
+func (h *Handler) GetCollaborators(w http.ResponseWriter, r *http.Request) {
+
+	ctx := r.Context()
+	uid := ctx.Value("uid").(bson.ObjectID)
+	resParams := &api.ResParams{W: w, R: r}
+
+	plotIdStr := r.URL.Query().Get("plotId")
+	resParams.ReqData = plotIdStr
+	plotId, err := plotutils.PlotIdFromHexString(plotIdStr)
+	if err != nil || !plotId.Validate() {
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	_, role, err := h.plotAccess(ctx, uid, plotId)
+	if err != nil {
+		if errors.Is(err, errNoPlotAccess) {
+			resParams.Code = http.StatusUnauthorized
+		} else {
+			resParams.Code = http.StatusInternalServerError
+		}
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	var plot schemas.Plot
+	if err := h.MongoDB.Collection("plots").FindOne(ctx, bson.M{"plotId": plotId.Id}).Decode(&plot); err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	// pending invites only shown to owner
+	var invites []schemas.CollabInvite
+	if role == schemas.COLLAB_OWNER {
+		cursor, err := h.MongoDB.Collection("collabInvites").Find(ctx, bson.M{
+			"plotId":    plotId.ToString(),
+			"status":    schemas.COLLAB_INVITE_PENDING,
+			"expiresAt": bson.M{"$gt": time.Now().UTC()},
+		})
+		if err != nil {
+			resParams.Code = http.StatusInternalServerError
+			resParams.Err = err
+			h.Res(resParams)
+			return
+		}
+		if err := cursor.All(ctx, &invites); err != nil {
+			resParams.Code = http.StatusInternalServerError
+			resParams.Err = err
+			h.Res(resParams)
+			return
+		}
+	}
+
+	uids := make([]bson.ObjectID, 0, len(plot.Collaborators)+len(invites))
+	for _, c := range plot.Collaborators {
+		uids = append(uids, c.Uid)
+	}
+	for _, inv := range invites {
+		uids = append(uids, inv.To)
+	}
+	usernames, err := h.usernames(ctx, uids)
+	if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	collaborators := make([]collaboratorRes, len(plot.Collaborators))
+	for i, c := range plot.Collaborators {
+		collaborators[i] = collaboratorRes{
+			Username: usernames[c.Uid],
+			Role:     c.Role,
+			AddedAt:  c.AddedAt,
+		}
+	}
+	pending := make([]collabInviteRes, len(invites))
+	for i, inv := range invites {
+		pending[i] = collabInviteRes{
+			InviteId:  inv.Id.Hex(),
+			PlotId:    inv.PlotId,
+			To:        usernames[inv.To],
+			Role:      inv.Role,
+			ExpiresAt: inv.ExpiresAt,
+		}
+	}
+
+	resParams.ResData = &struct {
+		Role          string            `json:"role"`
+		Collaborators []collaboratorRes `json:"collaborators"`
+		Invites       []collabInviteRes `json:"invites"`
+	}{
+		Role:          role,
+		Collaborators: collaborators,
+		Invites:       pending,
+	}
+	resParams.Code = http.StatusOK
+	h.Res(resParams)
+
+}
+
+// pending invites received by user
+func (h *Handler) GetCollabInvites(w http.ResponseWriter, r *http.Request) {
+
+	ctx := r.Context()
+	uid := ctx.Value("uid").(bson.ObjectID)
+	resParams := &api.ResParams{W: w, R: r}
+
+	cursor, err := h.MongoDB.Collection("collabInvites").Find(ctx,
+		bson.M{
+			"to":        uid,
+			"status":    schemas.COLLAB_INVITE_PENDING,
+			"expiresAt": bson.M{"$gt": time.Now().UTC()},
+		},
+		options.Find().SetSort(bson.M{"ctime": -1}),
+	)
+	if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	var invites []schemas.CollabInvite
+	if err := cursor.All(ctx, &invites); err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	uids := make([]bson.ObjectID, len(invites))
+	for i, inv := range invites {
+		uids[i] = inv.From
+	}
+	usernames, err := h.usernames(ctx, uids)
+	if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	results := make([]collabInviteRes, len(invites))
+	for i, inv := range invites {
+		results[i] = collabInviteRes{
+			InviteId:  inv.Id.Hex(),
+			PlotId:    inv.PlotId,
+			From:      usernames[inv.From],
+			Role:      inv.Role,
+			ExpiresAt: inv.ExpiresAt,
+		}
+	}
+
+	resParams.ResData = results
+	resParams.Code = http.StatusOK
+	h.Res(resParams)
+
+}
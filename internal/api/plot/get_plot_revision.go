@@ -0,0 +1,53 @@
+package plot
+
+import (
+	"errors"
+	"net/http"
+	"trraformapi/internal/api"
+	plotutils "trraformapi/pkg/plot_utils"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// current revision of a plot, sent back in If-Match when saving
+func (h *Handler) GetPlotRevision(w http.ResponseWriter, r *http.Request) {
+
+	ctx := r.Context()
+	uid := ctx.Value("uid").(bson.ObjectID)
+	resParams := &api.ResParams{W: w, R: r}
+
+	plotIdStr := r.URL.Query().Get("plotId")
+	resParams.ReqData = plotIdStr
+	plotId, err := plotutils.PlotIdFromHexString(plotIdStr)
+	if err != nil || !plotId.Validate() {
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	if _, _, err := h.plotAccess(ctx, uid, plotId); err != nil {
+		if errors.Is(err, errNoPlotAccess) {
+			resParams.Code = http.StatusUnauthorized
+		} else {
+			resParams.Code = http.StatusInternalServerError
+		}
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	plot, err := h.plotRevision(ctx, plotId)
+	if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	setRevisionHeader(w, plot.Revision)
+	resParams.Code = http.StatusOK
+	resParams.ResData = map[string]any{"revision": plot.Revision}
+	h.Res(resParams)
+
+}
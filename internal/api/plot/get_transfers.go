@@ -0,0 +1,90 @@
+package plot
+
+import (
+	"net/http"
+	"time"
+	"trraformapi/internal/api"
+	"trraformapi/pkg/schemas"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type transferRes struct {
+	TransferId string    `json:"transferId"`
+	PlotId     string    `json:"plotId"`
+	From       string    `json:"from"`
+	To         string    `json:"to"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+func (h *Handler) GetTransfers(w http.ResponseWriter, r *http.Request) {
+
+	ctx := r.Context()
+	uid := ctx.Value("uid").(bson.ObjectID)
+	resParams := &api.ResParams{W: w, R: r}
+
+	// pending transfers sent or received by user
+	cursor, err := h.MongoDB.Collection("transfers").Find(ctx,
+		bson.M{
+			"$or":       bson.A{bson.M{"from": uid}, bson.M{"to": uid}},
+			"status":    schemas.TRANSFER_PENDING,
+			"expiresAt": bson.M{"$gt": time.Now().UTC()},
+		},
+		options.Find().SetSort(bson.M{"ctime": -1}),
+	)
+	if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	var transfers []schemas.PlotTransfer
+	if err := cursor.All(ctx, &transfers); err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	// resolve usernames
+	uids := make([]bson.ObjectID, 0, len(transfers)*2)
+	for _, t := range transfers {
+		uids = append(uids, t.From, t.To)
+	}
+	usernames, err := h.usernames(ctx, uids)
+	if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	incoming := []transferRes{}
+	outgoing := []transferRes{}
+	for _, t := range transfers {
+		entry := transferRes{
+			TransferId: t.Id.Hex(),
+			PlotId:     t.PlotId,
+			From:       usernames[t.From],
+			To:         usernames[t.To],
+			ExpiresAt:  t.ExpiresAt,
+		}
+		if t.To == uid {
+			incoming = append(incoming, entry)
+		} else {
+			outgoing = append(outgoing, entry)
+		}
+	}
+
+	resParams.ResData = &struct {
+		Incoming []transferRes `json:"incoming"`
+		Outgoing []transferRes `json:"outgoing"`
+	}{
+		Incoming: incoming,
+		Outgoing: outgoing,
+	}
+	resParams.Code = http.StatusOK
+	h.Res(resParams)
+
+}
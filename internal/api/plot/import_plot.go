@@ -0,0 +1,144 @@
+package plot
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"trraformapi/internal/api"
+	"trraformapi/pkg/config"
+	plotutils "trraformapi/pkg/plot_utils"
+	"trraformapi/pkg/render"
+	"trraformapi/pkg/schemas"
+	"trraformapi/pkg/utils"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// imports a MagicaVoxel .vox file sent as the raw request body. the build is fit into the
+// largest build size the owner is entitled to and returned as a preview, or saved over the
+// plot's current build when save=true. not subject to the global request size limit
+func (h *Handler) ImportPlot(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	ctx := r.Context()
+	uid := ctx.Value("uid").(bson.ObjectID)
+	resParams := &api.ResParams{W: w, R: r}
+
+	query := r.URL.Query()
+	plotIdStr := query.Get("plotId")
+	resParams.ReqData = map[string]string{"plotId": plotIdStr, "save": query.Get("save")}
+	plotId, err := plotutils.PlotIdFromHexString(plotIdStr)
+	if err != nil || !plotId.Validate() {
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	save := false
+	if s := query.Get("save"); s != "" {
+		if save, err = strconv.ParseBool(s); err != nil {
+			resParams.Code = http.StatusBadRequest
+			resParams.Err = err
+			h.Res(resParams)
+			return
+		}
+	}
+
+	ifMatch, err := parseIfMatch(r)
+	if err != nil {
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	// only owners and editors can import, build size depends on the owner's subscription
+	owner, role, err := h.plotAccess(ctx, uid, plotId)
+	if err != nil && !errors.Is(err, errNoPlotAccess) {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	if role != schemas.COLLAB_OWNER && role != schemas.COLLAB_EDITOR {
+		resParams.Code = http.StatusUnauthorized
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	maxBuildSize := config.STD_BUILD_SIZE
+	if owner.Subscription.IsActive {
+		maxBuildSize = config.LRG_BUILD_SIZE
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, config.VOX_IMPORT_MAX_SIZE))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			resParams.Code = http.StatusRequestEntityTooLarge
+		} else {
+			resParams.Code = http.StatusBadRequest
+		}
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	model, err := render.ReadVOX(data)
+	if err != nil {
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	buildData, err := model.BuildData(maxBuildSize)
+	if err != nil {
+		resParams.Code = http.StatusServiceUnavailable
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	if !save {
+		resParams.Code = http.StatusOK
+		resParams.ResData = map[string]any{
+			"buildData":  base64.StdEncoding.EncodeToString(utils.Uint16ArrToBytes(buildData)),
+			"buildSize":  buildData[1],
+			"blockCount": plotutils.BlockCount(buildData),
+			"cropped":    max(model.Size[0], model.Size[1], model.Size[2]) > maxBuildSize,
+		}
+		h.Res(resParams)
+		return
+	}
+
+	// keep the plot's name, description and link, only the build is replaced
+	current, _, err := utils.GetObjectR2(h.R2Cli, ctx, config.CF_PLOT_BUCKET, plotIdStr+".dat")
+	if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	plotData, err := plotutils.Decode(current)
+	if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = fmt.Errorf("decoding current plot: %w", err)
+		h.Res(resParams)
+		return
+	}
+	plotData.BuildData = buildData
+
+	revision, err := h.savePlot(ctx, uid, plotId, plotData, ifMatch)
+	if err != nil {
+		h.saveErr(resParams, err)
+		return
+	}
+
+	setRevisionHeader(w, revision)
+	resParams.Code = http.StatusOK
+	resParams.ResData = map[string]any{"revision": revision}
+	h.Res(resParams)
+
+}
@@ -0,0 +1,163 @@
+package plot
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+	"trraformapi/internal/api"
+	"trraformapi/pkg/config"
+	plotutils "trraformapi/pkg/plot_utils"
+	"trraformapi/pkg/schemas"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+func (h *Handler) InviteCollaborator(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	ctx := r.Context()
+	uid := ctx.Value("uid").(bson.ObjectID)
+	resParams := &api.ResParams{W: w, R: r}
+
+	var reqData struct {
+		PlotId   string `json:"plotId" validate:"required,plotid"`
+		Username string `json:"username" validate:"required,username"`
+		Role     string `json:"role" validate:"required,oneof=editor viewer"`
+	}
+
+	// validate request body
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&reqData); err != nil {
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	resParams.ReqData = reqData
+	if err := h.Validate.Struct(&reqData); err != nil {
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	plotId, _ := plotutils.PlotIdFromHexString(reqData.PlotId)
+	plotIdStr := plotId.ToString()
+
+	// only the owner can invite
+	_, role, err := h.plotAccess(ctx, uid, plotId)
+	if err != nil && !errors.Is(err, errNoPlotAccess) {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	if role != schemas.COLLAB_OWNER {
+		resParams.Code = http.StatusUnauthorized
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	// find invitee
+	var invitee schemas.User
+	if err := h.MongoDB.Collection("users").FindOne(ctx, bson.M{"username": reqData.Username}).Decode(&invitee); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			resParams.ResData = &struct {
+				UserNotFound bool `json:"userNotFound"`
+			}{UserNotFound: true}
+			resParams.Code = http.StatusNotFound
+		} else {
+			resParams.Code = http.StatusInternalServerError
+		}
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	if invitee.Id == uid {
+		resParams.Code = http.StatusBadRequest
+		h.Res(resParams)
+		return
+	}
+
+	// check collaborator limit and existing membership
+	var plot schemas.Plot
+	if err := h.MongoDB.Collection("plots").FindOne(ctx, bson.M{"plotId": plotId.Id}).Decode(&plot); err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	if len(plot.Collaborators) >= config.PLOT_COLLABORATOR_LIMIT {
+		resParams.ResData = &struct {
+			CollaboratorLimit bool `json:"collaboratorLimit"`
+		}{CollaboratorLimit: true}
+		resParams.Code = http.StatusBadRequest
+		h.Res(resParams)
+		return
+	}
+	for _, c := range plot.Collaborators {
+		if c.Uid == invitee.Id {
+			resParams.ResData = &struct {
+				Conflict bool `json:"conflict"`
+			}{Conflict: true}
+			resParams.Code = http.StatusConflict
+			h.Res(resParams)
+			return
+		}
+	}
+
+	// one pending invite per user per plot
+	now := time.Now().UTC()
+	invitesColl := h.MongoDB.Collection("collabInvites")
+	err = invitesColl.FindOne(ctx, bson.M{
+		"plotId":    plotIdStr,
+		"to":        invitee.Id,
+		"status":    schemas.COLLAB_INVITE_PENDING,
+		"expiresAt": bson.M{"$gt": now},
+	}).Err()
+	if err == nil {
+		resParams.ResData = &struct {
+			Conflict bool `json:"conflict"`
+		}{Conflict: true}
+		resParams.Code = http.StatusConflict
+		h.Res(resParams)
+		return
+	} else if !errors.Is(err, mongo.ErrNoDocuments) {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	invite := schemas.CollabInvite{
+		PlotId:    plotIdStr,
+		From:      uid,
+		To:        invitee.Id,
+		Role:      reqData.Role,
+		Status:    schemas.COLLAB_INVITE_PENDING,
+		Ctime:     now,
+		ExpiresAt: now.Add(config.COLLAB_INVITE_DURATION),
+	}
+	res, err := invitesColl.InsertOne(ctx, &invite)
+	if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	resParams.ResData = &struct {
+		InviteId  string    `json:"inviteId"`
+		ExpiresAt time.Time `json:"expiresAt"`
+	}{
+		InviteId:  res.InsertedID.(bson.ObjectID).Hex(),
+		ExpiresAt: invite.ExpiresAt,
+	}
+	resParams.Code = http.StatusOK
+	h.Res(resParams)
+
+}
@@ -0,0 +1,164 @@
+package plot
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"trraformapi/internal/api"
+	"trraformapi/pkg/config"
+	plotutils "trraformapi/pkg/plot_utils"
+	"trraformapi/pkg/schemas"
+	"trraformapi/pkg/utils"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type blockEdit struct {
+	Index int    `json:"i" validate:"min=0"`
+	Value uint16 `json:"v"` // checked against config.MAX_COLOR_IDX
+}
+
+type rangeEdit struct {
+	Start  int      `json:"start" validate:"min=0"`
+	Values []uint16 `json:"values" validate:"required"` // checked against config.MAX_COLOR_IDX
+}
+
+// applies block edits and replaced voxel ranges to the current build of a plot.
+// voxel indexes are x + z*bs + y*bs^2, values are color indexes (0 for empty)
+func (h *Handler) PatchPlot(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	ctx := r.Context()
+	uid := ctx.Value("uid").(bson.ObjectID)
+	resParams := &api.ResParams{W: w, R: r}
+
+	var reqData struct {
+		PlotId   string      `json:"plotId" validate:"required,plotid"`
+		Revision *int64      `json:"revision" validate:"required"`
+		Edits    []blockEdit `json:"edits" validate:"max=4096,dive"`
+		Ranges   []rangeEdit `json:"ranges" validate:"max=4096,dive"`
+	}
+
+	// validate request body
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&reqData); err != nil {
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	resParams.ReqData = reqData
+	if err := h.Validate.Struct(&reqData); err != nil {
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	if err := checkColors(reqData.Edits, reqData.Ranges); err != nil {
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	plotId, _ := plotutils.PlotIdFromHexString(reqData.PlotId)
+
+	// check access before fetching the build
+	_, role, err := h.plotAccess(ctx, uid, plotId)
+	if err == nil && role != schemas.COLLAB_OWNER && role != schemas.COLLAB_EDITOR {
+		err = errNoPlotAccess
+	}
+	if err != nil {
+		h.saveErr(resParams, err)
+		return
+	}
+
+	// reject stale base revisions before doing any work, savePlot checks again
+	plot, err := h.plotRevision(ctx, plotId)
+	if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	if plot.Revision != *reqData.Revision {
+		h.saveErr(resParams, &staleRevisionError{revision: plot.Revision})
+		return
+	}
+
+	data, _, err := utils.GetObjectR2(h.R2Cli, ctx, config.CF_PLOT_BUCKET, plotId.ToString()+".dat")
+	if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	plotData, err := plotutils.Decode(data)
+	if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	voxels, err := plotutils.ExpandBuildData(plotData.BuildData)
+	if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	// apply ranges, then single block edits
+	for _, re := range reqData.Ranges {
+		if re.Start > len(voxels) || len(re.Values) > len(voxels)-re.Start {
+			resParams.Code = http.StatusBadRequest
+			resParams.Err = fmt.Errorf("range at %d exceeds build size", re.Start)
+			h.Res(resParams)
+			return
+		}
+		copy(voxels[re.Start:], re.Values)
+	}
+	for _, e := range reqData.Edits {
+		if e.Index >= len(voxels) {
+			resParams.Code = http.StatusBadRequest
+			resParams.Err = fmt.Errorf("block %d exceeds build size", e.Index)
+			h.Res(resParams)
+			return
+		}
+		voxels[e.Index] = e.Value
+	}
+
+	// re-encoded build is validated like any other save
+	plotData.BuildData = plotutils.CompressBuildData(plotData.BuildData[0], int(plotData.BuildData[1]), voxels)
+	revision, err := h.savePlot(ctx, uid, plotId, plotData, reqData.Revision)
+	if err != nil {
+		h.saveErr(resParams, err)
+		return
+	}
+
+	setRevisionHeader(w, revision)
+	resParams.Code = http.StatusOK
+	resParams.ResData = map[string]any{"revision": revision}
+	h.Res(resParams)
+
+}
+
+// color indexes beyond the palette
+func checkColors(edits []blockEdit, ranges []rangeEdit) error {
+
+	for _, e := range edits {
+		if e.Value > config.MAX_COLOR_IDX {
+			return fmt.Errorf("color index %d out of range", e.Value)
+		}
+	}
+	for _, re := range ranges {
+		if i := slices.IndexFunc(re.Values, func(v uint16) bool { return v > config.MAX_COLOR_IDX }); i >= 0 {
+			return fmt.Errorf("color index %d out of range", re.Values[i])
+		}
+	}
+
+	return nil
+
+}
@@ -0,0 +1,76 @@
+package plot
+
+import (
+	"context"
+	"errors"
+	plotutils "trraformapi/pkg/plot_utils"
+	"trraformapi/pkg/schemas"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+var errNoPlotAccess = errors.New("user has no access to plot")
+
+// returns the plot owner and the role uid has on the plot
+func (h *Handler) plotAccess(ctx context.Context, uid bson.ObjectID, plotId *plotutils.PlotId) (*schemas.User, string, error) {
+
+	var owner schemas.User
+	if err := h.MongoDB.Collection("users").FindOne(ctx, bson.M{
+		"plotIds": plotId.ToString(),
+	}).Decode(&owner); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, "", errNoPlotAccess
+		}
+		return nil, "", err
+	}
+	if owner.Id == uid {
+		return &owner, schemas.COLLAB_OWNER, nil
+	}
+
+	var plot schemas.Plot
+	if err := h.MongoDB.Collection("plots").FindOne(ctx,
+		bson.M{
+			"plotId":            plotId.Id,
+			"collaborators.uid": uid,
+		},
+		options.FindOne().SetProjection(bson.M{"collaborators": 1}),
+	).Decode(&plot); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, "", errNoPlotAccess
+		}
+		return nil, "", err
+	}
+	for _, c := range plot.Collaborators {
+		if c.Uid == uid {
+			return &owner, c.Role, nil
+		}
+	}
+
+	return nil, "", errNoPlotAccess
+
+}
+
+func (h *Handler) usernames(ctx context.Context, uids []bson.ObjectID) (map[bson.ObjectID]string, error) {
+
+	cursor, err := h.MongoDB.Collection("users").Find(ctx,
+		bson.M{"_id": bson.M{"$in": uids}},
+		options.Find().SetProjection(bson.M{"username": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var users []schemas.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+
+	usernames := make(map[bson.ObjectID]string, len(users))
+	for _, u := range users {
+		usernames[u.Id] = u.Username
+	}
+
+	return usernames, nil
+
+}
@@ -0,0 +1,171 @@
+package plot
+
+import (
+	"maps"
+	"net/http"
+	"trraformapi/internal/api"
+	"trraformapi/pkg/config"
+	plotutils "trraformapi/pkg/plot_utils"
+	"trraformapi/pkg/schemas"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type plotNode struct {
+	PlotId    string      `json:"plotId"`
+	Slot      int         `json:"slot,omitempty"` // subplot slot in the parent build
+	Claimed   bool        `json:"claimed"`
+	Owner     string      `json:"owner,omitempty"`
+	Name      string      `json:"name,omitempty"`
+	Thumbnail string      `json:"thumbnail,omitempty"`
+	Subplots  []*plotNode `json:"subplots,omitempty"`
+}
+
+// a plot's parent chain (root first) and its subplot tree down to MAX_DEPTH.
+// each depth is one batched plots lookup, owners are resolved in one lookup at the end
+func (h *Handler) GetPlotTree(w http.ResponseWriter, r *http.Request) {
+
+	ctx := r.Context()
+	resParams := &api.ResParams{W: w, R: r}
+
+	plotIdStr := chi.URLParam(r, "id")
+	resParams.ReqData = plotIdStr
+	plotId, err := plotutils.PlotIdFromHexString(plotIdStr)
+	if err != nil || !plotId.Validate() {
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	nodes := make(map[uint64]*plotNode)
+	newNode := func(id *plotutils.PlotId) *plotNode {
+		node := &plotNode{PlotId: id.ToString()}
+		if id.Depth() > 0 {
+			split := id.Split()
+			node.Slot = int(split[len(split)-1])
+		}
+		nodes[id.Id] = node
+		return node
+	}
+
+	// parent chain and the plot itself
+	var parents []*plotNode
+	ids := []uint64{plotId.Id}
+	for p := plotId.GetParent(); p != nil; p = p.GetParent() {
+		parents = append([]*plotNode{newNode(p)}, parents...)
+		ids = append(ids, p.Id)
+	}
+	root := newNode(plotId)
+
+	docs, err := plotutils.PlotsWithSubplots(h.MongoDB, ctx, ids)
+	if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	// walk down a depth at a time, only claimed plots have builds with subplots
+	level := []*plotutils.PlotId{plotId}
+	for depth := plotId.Depth(); depth < config.MAX_DEPTH && len(level) > 0; depth++ {
+		var next []*plotutils.PlotId
+		var nextIds []uint64
+		for _, id := range level {
+			doc, ok := docs[id.Id]
+			if !ok {
+				continue
+			}
+			node := nodes[id.Id]
+			node.Subplots = []*plotNode{}
+			for _, slot := range doc.Subplots {
+				childId := plotutils.CreateSubplotId(id, uint64(slot))
+				node.Subplots = append(node.Subplots, newNode(childId))
+				next = append(next, childId)
+				nextIds = append(nextIds, childId.Id)
+			}
+		}
+		if len(nextIds) == 0 {
+			break
+		}
+		levelDocs, err := plotutils.PlotsWithSubplots(h.MongoDB, ctx, nextIds)
+		if err != nil {
+			resParams.Code = http.StatusInternalServerError
+			resParams.Err = err
+			h.Res(resParams)
+			return
+		}
+		maps.Copy(docs, levelDocs)
+		level = next
+	}
+
+	// owners of every claimed plot in the tree
+	claimedIdStrs := make([]string, 0, len(docs))
+	for id := range docs {
+		claimedIdStrs = append(claimedIdStrs, nodes[id].PlotId)
+	}
+	cursor, err := h.MongoDB.Collection("users").Find(ctx,
+		bson.M{"plotIds": bson.M{"$in": claimedIdStrs}},
+		options.Find().SetProjection(bson.M{"username": 1, "privacy": 1, "plotIds": 1}),
+	)
+	if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	var owners []schemas.User
+	if err := cursor.All(ctx, &owners); err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	ownerOf := make(map[string]*schemas.User)
+	for i := range owners {
+		for _, id := range owners[i].PlotIds {
+			ownerOf[id] = &owners[i]
+		}
+	}
+
+	// thumbnails are rendered after a save, plots not saved since they were claimed have none
+	hasThumbnail, err := plotutils.HasThumbnails(h.RedisCli, ctx, claimedIdStrs)
+	if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	thumbnails := make(map[string]bool, len(claimedIdStrs))
+	for i, idStr := range claimedIdStrs {
+		thumbnails[idStr] = hasThumbnail[i]
+	}
+
+	// owners hiding their plots only show as claimed
+	for id, doc := range docs {
+		node := nodes[id]
+		node.Claimed = true
+		owner, ok := ownerOf[node.PlotId]
+		if !ok || owner.Privacy.HidePlots {
+			continue
+		}
+		node.Owner = plotutils.PlotMetadata(owner)["owner"]
+		node.Name = doc.Name
+		if thumbnails[node.PlotId] {
+			node.Thumbnail = config.PLOT_CDN_URL + "/" + node.PlotId + ".png"
+		}
+	}
+
+	resParams.ResData = &struct {
+		Parents []*plotNode `json:"parents"`
+		Plot    *plotNode   `json:"plot"`
+	}{
+		Parents: parents,
+		Plot:    root,
+	}
+	resParams.Code = http.StatusOK
+	h.Res(resParams)
+
+}
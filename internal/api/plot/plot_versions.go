@@ -0,0 +1,254 @@
+package plot
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+	"trraformapi/internal/api"
+	"trraformapi/pkg/config"
+	plotutils "trraformapi/pkg/plot_utils"
+	"trraformapi/pkg/schemas"
+	"trraformapi/pkg/utils"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type plotVersionRes struct {
+	VersionId  string    `json:"versionId"`
+	Revision   int64     `json:"revision"`
+	Ctime      time.Time `json:"ctime"`
+	Size       int       `json:"size"`
+	BlockCount int       `json:"blockCount"`
+	Author     string    `json:"author"`
+}
+
+// saved versions of a plot, newest first
+func (h *Handler) GetPlotVersions(w http.ResponseWriter, r *http.Request) {
+
+	ctx := r.Context()
+	uid := ctx.Value("uid").(bson.ObjectID)
+	resParams := &api.ResParams{W: w, R: r}
+
+	plotIdStr := r.URL.Query().Get("plotId")
+	resParams.ReqData = plotIdStr
+	plotId, err := plotutils.PlotIdFromHexString(plotIdStr)
+	if err != nil || !plotId.Validate() {
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	if _, _, err := h.plotAccess(ctx, uid, plotId); err != nil {
+		if errors.Is(err, errNoPlotAccess) {
+			resParams.Code = http.StatusUnauthorized
+		} else {
+			resParams.Code = http.StatusInternalServerError
+		}
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	cursor, err := h.MongoDB.Collection("plotVersions").Find(ctx,
+		bson.M{"plotId": plotId.ToString()},
+		options.Find().SetSort(bson.M{"_id": -1}),
+	)
+	if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	var versions []schemas.PlotVersion
+	if err := cursor.All(ctx, &versions); err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	uids := make([]bson.ObjectID, 0, len(versions))
+	for _, v := range versions {
+		uids = append(uids, v.Author)
+	}
+	names, err := h.usernames(ctx, uids)
+	if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	res := make([]plotVersionRes, 0, len(versions))
+	for _, v := range versions {
+		res = append(res, plotVersionRes{
+			VersionId:  v.Id.Hex(),
+			Revision:   v.Revision,
+			Ctime:      v.Ctime,
+			Size:       v.Size,
+			BlockCount: v.BlockCount,
+			Author:     names[v.Author],
+		})
+	}
+
+	resParams.Code = http.StatusOK
+	resParams.ResData = map[string]any{"versions": res}
+	h.Res(resParams)
+
+}
+
+// finds a version of a plot the user has access to
+func (h *Handler) findPlotVersion(r *http.Request, plotIdStr string, versionIdStr string) (*plotutils.PlotId, *schemas.PlotVersion, int, error) {
+
+	ctx := r.Context()
+	uid := ctx.Value("uid").(bson.ObjectID)
+
+	plotId, err := plotutils.PlotIdFromHexString(plotIdStr)
+	if err != nil || !plotId.Validate() {
+		return nil, nil, http.StatusBadRequest, err
+	}
+	versionId, err := bson.ObjectIDFromHex(versionIdStr)
+	if err != nil {
+		return nil, nil, http.StatusBadRequest, err
+	}
+
+	if _, _, err := h.plotAccess(ctx, uid, plotId); err != nil {
+		if errors.Is(err, errNoPlotAccess) {
+			return nil, nil, http.StatusUnauthorized, err
+		}
+		return nil, nil, http.StatusInternalServerError, err
+	}
+
+	var version schemas.PlotVersion
+	err = h.MongoDB.Collection("plotVersions").FindOne(ctx, bson.M{"_id": versionId, "plotId": plotId.ToString()}).Decode(&version)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil, http.StatusNotFound, err
+	} else if err != nil {
+		return nil, nil, http.StatusInternalServerError, err
+	}
+
+	return plotId, &version, http.StatusOK, nil
+
+}
+
+// raw plot data of a saved version
+func (h *Handler) GetPlotVersion(w http.ResponseWriter, r *http.Request) {
+
+	ctx := r.Context()
+	resParams := &api.ResParams{W: w, R: r}
+
+	query := r.URL.Query()
+	resParams.ReqData = query
+	_, version, code, err := h.findPlotVersion(r, query.Get("plotId"), query.Get("versionId"))
+	if err != nil {
+		resParams.Code = code
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	data, _, err := utils.GetObjectR2(h.R2Cli, ctx, config.CF_VERSION_BUCKET, version.Key)
+	if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	// stored versions may be in a newer format, downloads stay v0 so older clients can read them
+	plotData, err := plotutils.Decode(data)
+	if err == nil {
+		data, err = plotData.EncodeVersion(0)
+	}
+	if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+
+}
+
+// saves an old version as the current plot data
+func (h *Handler) RestorePlotVersion(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	ctx := r.Context()
+	uid := ctx.Value("uid").(bson.ObjectID)
+	resParams := &api.ResParams{W: w, R: r}
+
+	var reqData struct {
+		PlotId    string `json:"plotId" validate:"required,plotid"`
+		VersionId string `json:"versionId" validate:"required,mongodb"`
+	}
+
+	ifMatch, err := parseIfMatch(r)
+	if err != nil {
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	// validate request body
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&reqData); err != nil {
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	resParams.ReqData = reqData
+	if err := h.Validate.Struct(&reqData); err != nil {
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	plotId, version, code, err := h.findPlotVersion(r, reqData.PlotId, reqData.VersionId)
+	if err != nil {
+		resParams.Code = code
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	data, _, err := utils.GetObjectR2(h.R2Cli, ctx, config.CF_VERSION_BUCKET, version.Key)
+	if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	plotData, err := plotutils.Decode(data)
+	if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	// restoring is a normal save, so it is re-validated and creates a new version
+	revision, err := h.savePlot(ctx, uid, plotId, plotData, ifMatch)
+	if err != nil {
+		h.saveErr(resParams, err)
+		return
+	}
+
+	setRevisionHeader(w, revision)
+	resParams.Code = http.StatusOK
+	resParams.ResData = map[string]any{"revision": revision}
+	h.Res(resParams)
+
+}
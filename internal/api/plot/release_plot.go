@@ -0,0 +1,311 @@
+package plot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+	"trraformapi/internal/api"
+	"trraformapi/pkg/config"
+	plotutils "trraformapi/pkg/plot_utils"
+	"trraformapi/pkg/schemas"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"go.mongodb.org/mongo-driver/v2/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/v2/mongo/writeconcern"
+	"go.uber.org/zap"
+)
+
+const (
+	REFUND_NONE      = "none"
+	REFUND_CREDIT    = "credit"
+	REFUND_FREE_PLOT = "freePlot"
+)
+
+var errReleaseCooldown = errors.New("plot released too recently")
+var errReleaseConflict = errors.New("plot has subplots, an open listing or a pending transfer")
+
+// gives up a plot. the plot goes back to the unclaimed pool and, under the release policy in config,
+// its credit or the free plot slot is refunded
+func (h *Handler) ReleasePlot(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	ctx := r.Context()
+	uid := ctx.Value("uid").(bson.ObjectID)
+	resParams := &api.ResParams{W: w, R: r}
+
+	var reqData struct {
+		PlotId string `json:"plotId" validate:"required,plotid"`
+	}
+
+	// validate request body
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&reqData); err != nil {
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	resParams.ReqData = reqData
+	if err := h.Validate.Struct(&reqData); err != nil {
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	plotId, _ := plotutils.PlotIdFromHexString(reqData.PlotId)
+	plotIdStr := plotId.ToString()
+
+	// only the owner can release
+	_, role, err := h.plotAccess(ctx, uid, plotId)
+	if err != nil && !errors.Is(err, errNoPlotAccess) {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	if role != schemas.COLLAB_OWNER {
+		resParams.Code = http.StatusUnauthorized
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	var plot schemas.Plot
+	if err := h.MongoDB.Collection("plots").FindOne(ctx, bson.M{"plotId": plotId.Id}).Decode(&plot); err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	// lock the plot and its subplots so claims, sales, transfers and listings of them
+	// can't land between the conflict checks and the release
+	lockIds := []string{plotIdStr}
+	if plotId.Depth() < config.MAX_DEPTH {
+		for i := range config.SUBPLOT_COUNT {
+			lockIds = append(lockIds, plotutils.CreateSubplotId(plotId, uint64(i+1)).ToString())
+		}
+	}
+	lockOwner := uuid.NewString()
+	failedIds, err := plotutils.LockPlots(h.RedisCli, ctx, lockIds, lockOwner)
+	if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	defer plotutils.UnlockPlots(h.RedisCli, lockOwner)
+	if len(failedIds) > 0 {
+		resParams.ResData = &struct {
+			Conflict string `json:"conflict"`
+		}{Conflict: "locked"}
+		resParams.Code = http.StatusConflict
+		h.Res(resParams)
+		return
+	}
+
+	// create transaction session
+	txSession, err := h.MongoDB.Client().StartSession()
+	if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	defer txSession.EndSession(ctx)
+	txOpts := options.Transaction().SetReadConcern(readconcern.Snapshot()).SetWriteConcern(writeconcern.Majority())
+
+	now := time.Now().UTC()
+	var refund, conflict string
+	var releasedAt time.Time
+	release := &schemas.PlotRelease{
+		Id:        bson.NewObjectID(),
+		PlotId:    plotIdStr,
+		Uid:       uid,
+		Source:    plot.Source,
+		Transfers: plot.Transfers,
+		Ctime:     now,
+	}
+	_, err = txSession.WithTransaction(ctx, func(txCtx context.Context) (interface{}, error) {
+
+		// the refund and cooldown are decided from the user as of the transaction,
+		// a concurrent release or claim of the free plot aborts it
+		var user schemas.User
+		if err := h.MongoDB.Collection("users").FindOne(txCtx, bson.M{"_id": uid}).Decode(&user); err != nil {
+			return nil, err
+		}
+		if !slices.Contains(user.PlotIds, plotIdStr) {
+			return nil, plotutils.ErrNotPlotOwner
+		}
+		// one release per cooldown
+		if releasedAt = user.ReleasedAt; now.Sub(releasedAt) < config.RELEASE_COOLDOWN {
+			return nil, errReleaseCooldown
+		}
+		refund = releaseRefund(&user, &plot, plotIdStr)
+
+		// plots with claimed subplots, open listings or pending transfers can't be released
+		var err error
+		if conflict, err = h.releaseConflict(txCtx, plotId); err != nil {
+			return nil, err
+		} else if conflict != "" {
+			return nil, errReleaseConflict
+		}
+
+		update := bson.M{
+			"$pull": bson.M{"plotIds": plotIdStr, "purchasedIds": plotIdStr},
+			"$set":  bson.M{"releasedAt": now},
+		}
+		switch refund {
+		case REFUND_FREE_PLOT:
+			update["$set"].(bson.M)["freePlot"] = ""
+		case REFUND_CREDIT:
+			update["$inc"] = bson.M{"plotCredits": 1}
+		}
+		if _, err := h.MongoDB.Collection("users").UpdateOne(txCtx, bson.M{"_id": uid}, update); err != nil {
+			return nil, err
+		}
+
+		if _, err := h.MongoDB.Collection("plots").DeleteOne(txCtx, bson.M{"plotId": plotId.Id}); err != nil {
+			return nil, err
+		}
+		release.Refund = refund
+		if _, err := h.MongoDB.Collection("plotReleases").InsertOne(txCtx, release); err != nil {
+			return nil, err
+		}
+
+		// invites to a released plot can't be accepted anymore
+		if _, err := h.MongoDB.Collection("collabInvites").UpdateMany(txCtx,
+			bson.M{"plotId": plotIdStr, "status": schemas.COLLAB_INVITE_PENDING},
+			bson.M{"$set": bson.M{"status": schemas.COLLAB_INVITE_CANCELED}},
+		); err != nil {
+			return nil, err
+		}
+
+		return nil, nil
+
+	}, txOpts)
+
+	if errors.Is(err, errReleaseCooldown) {
+		resParams.ResData = &struct {
+			Cooldown   bool      `json:"cooldown"`
+			RetryAfter time.Time `json:"retryAfter"`
+		}{Cooldown: true, RetryAfter: releasedAt.Add(config.RELEASE_COOLDOWN)}
+		resParams.Code = http.StatusTooManyRequests
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	} else if errors.Is(err, errReleaseConflict) {
+		resParams.ResData = &struct {
+			Conflict string `json:"conflict"`
+		}{Conflict: conflict}
+		resParams.Code = http.StatusConflict
+		h.Res(resParams)
+		return
+	} else if errors.Is(err, plotutils.ErrNotPlotOwner) { // released or transferred concurrently
+		resParams.ResData = &struct {
+			Conflict bool `json:"conflict"`
+		}{Conflict: true}
+		resParams.Code = http.StatusConflict
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	} else if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	// the plot starts over for the next owner. the release has committed, a failed cleanup
+	// is left for reconcile
+	if err := plotutils.CleanReleasedPlot(h.MongoDB, h.RedisCli, h.R2Cli, h.HttpCli, ctx, release); err != nil {
+		h.Logger.Error("Error cleaning released plot", zap.String("plotId", plotIdStr), zap.Error(err))
+	}
+
+	resParams.ResData = &struct {
+		Refund string `json:"refund"`
+	}{Refund: refund}
+	resParams.Code = http.StatusOK
+	h.Res(resParams)
+
+}
+
+// reason a plot can't be released, empty if it can
+func (h *Handler) releaseConflict(ctx context.Context, plotId *plotutils.PlotId) (string, error) {
+
+	plotIdStr := plotId.ToString()
+
+	if plotId.Depth() < config.MAX_DEPTH {
+		ids := make([]uint64, config.SUBPLOT_COUNT)
+		for i := range ids {
+			ids[i] = plotutils.CreateSubplotId(plotId, uint64(i+1)).Id
+		}
+		if err := h.MongoDB.Collection("plots").FindOne(ctx, bson.M{"plotId": bson.M{"$in": ids}}).Err(); err == nil {
+			return "hasSubplots", nil
+		} else if !errors.Is(err, mongo.ErrNoDocuments) {
+			return "", err
+		}
+	}
+
+	if err := h.MongoDB.Collection("listings").FindOne(ctx, bson.M{
+		"plotId": plotIdStr,
+		"status": bson.M{"$in": bson.A{schemas.LISTING_ACTIVE, schemas.LISTING_PENDING}},
+	}).Err(); err == nil {
+		return "listed", nil
+	} else if !errors.Is(err, mongo.ErrNoDocuments) {
+		return "", err
+	}
+
+	if err := h.MongoDB.Collection("transfers").FindOne(ctx, bson.M{
+		"plotId":    plotIdStr,
+		"status":    schemas.TRANSFER_PENDING,
+		"expiresAt": bson.M{"$gt": time.Now().UTC()},
+	}).Err(); err == nil {
+		return "pendingTransfer", nil
+	} else if !errors.Is(err, mongo.ErrNoDocuments) {
+		return "", err
+	}
+
+	return "", nil
+
+}
+
+// refund for releasing a plot. only the user who claimed the plot gets one, within the refund window
+func releaseRefund(owner *schemas.User, plot *schemas.Plot, plotIdStr string) string {
+
+	if len(plot.Transfers) > 0 || time.Since(plot.Ctime) > config.RELEASE_REFUND_WINDOW {
+		return REFUND_NONE
+	}
+
+	// plots claimed before the source was recorded
+	source := plot.Source
+	if source == "" {
+		switch {
+		case owner.FreePlot == plotIdStr:
+			source = schemas.PLOT_SOURCE_FREE
+		case slices.Contains(owner.PurchasedIds, plotIdStr):
+			source = schemas.PLOT_SOURCE_PURCHASE
+		default:
+			source = schemas.PLOT_SOURCE_CREDIT
+		}
+	}
+
+	switch {
+	case source == schemas.PLOT_SOURCE_FREE && config.RELEASE_REFUND_FREE && owner.FreePlot == plotIdStr:
+		return REFUND_FREE_PLOT
+	case source == schemas.PLOT_SOURCE_CREDIT && config.RELEASE_REFUND_CREDIT,
+		source == schemas.PLOT_SOURCE_PURCHASE && config.RELEASE_REFUND_PURCHASE:
+		return REFUND_CREDIT
+	}
+
+	return REFUND_NONE
+
+}
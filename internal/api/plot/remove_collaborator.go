@@ -0,0 +1,92 @@
+package plot
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"trraformapi/internal/api"
+	plotutils "trraformapi/pkg/plot_utils"
+	"trraformapi/pkg/schemas"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+func (h *Handler) RemoveCollaborator(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	ctx := r.Context()
+	uid := ctx.Value("uid").(bson.ObjectID)
+	resParams := &api.ResParams{W: w, R: r}
+
+	var reqData struct {
+		PlotId   string `json:"plotId" validate:"required,plotid"`
+		Username string `json:"username" validate:"required,username"`
+	}
+
+	// validate request body
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&reqData); err != nil {
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	resParams.ReqData = reqData
+	if err := h.Validate.Struct(&reqData); err != nil {
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	plotId, _ := plotutils.PlotIdFromHexString(reqData.PlotId)
+
+	var target schemas.User
+	if err := h.MongoDB.Collection("users").FindOne(ctx, bson.M{"username": reqData.Username}).Decode(&target); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			resParams.Code = http.StatusNotFound
+		} else {
+			resParams.Code = http.StatusInternalServerError
+		}
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	// owner can remove anyone, collaborators can only leave
+	_, role, err := h.plotAccess(ctx, uid, plotId)
+	if err != nil && !errors.Is(err, errNoPlotAccess) {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	if role == "" || (role != schemas.COLLAB_OWNER && target.Id != uid) {
+		resParams.Code = http.StatusUnauthorized
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	res, err := h.MongoDB.Collection("plots").UpdateOne(ctx,
+		bson.M{"plotId": plotId.Id},
+		bson.M{"$pull": bson.M{"collaborators": bson.M{"uid": target.Id}}},
+	)
+	if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	if res.ModifiedCount == 0 {
+		resParams.Code = http.StatusNotFound
+		h.Res(resParams)
+		return
+	}
+
+	resParams.Code = http.StatusOK
+	h.Res(resParams)
+
+}
@@ -0,0 +1,181 @@
+package plot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+	"trraformapi/internal/api"
+	"trraformapi/pkg/config"
+	plotutils "trraformapi/pkg/plot_utils"
+	"trraformapi/pkg/schemas"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"go.mongodb.org/mongo-driver/v2/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/v2/mongo/writeconcern"
+)
+
+func (h *Handler) RespondCollabInvite(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	ctx := r.Context()
+	uid := ctx.Value("uid").(bson.ObjectID)
+	resParams := &api.ResParams{W: w, R: r}
+
+	var reqData struct {
+		InviteId string `json:"inviteId" validate:"required,mongodb"`
+		Accept   bool   `json:"accept"`
+	}
+
+	// validate request body
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&reqData); err != nil {
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	resParams.ReqData = reqData
+	if err := h.Validate.Struct(&reqData); err != nil {
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	inviteId, _ := bson.ObjectIDFromHex(reqData.InviteId)
+	invitesColl := h.MongoDB.Collection("collabInvites")
+	pendingFilter := bson.M{
+		"_id":       inviteId,
+		"to":        uid,
+		"status":    schemas.COLLAB_INVITE_PENDING,
+		"expiresAt": bson.M{"$gt": time.Now().UTC()},
+	}
+
+	// decline
+	if !reqData.Accept {
+		res, err := invitesColl.UpdateOne(ctx, pendingFilter, bson.M{
+			"$set": bson.M{"status": schemas.COLLAB_INVITE_DECLINED},
+		})
+		if err != nil {
+			resParams.Code = http.StatusInternalServerError
+			resParams.Err = err
+			h.Res(resParams)
+			return
+		}
+		if res.MatchedCount == 0 {
+			resParams.Code = http.StatusNotFound
+			h.Res(resParams)
+			return
+		}
+		resParams.Code = http.StatusOK
+		h.Res(resParams)
+		return
+	}
+
+	var invite schemas.CollabInvite
+	if err := invitesColl.FindOne(ctx, pendingFilter).Decode(&invite); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			resParams.Code = http.StatusNotFound
+		} else {
+			resParams.Code = http.StatusInternalServerError
+		}
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	plotId, err := plotutils.PlotIdFromHexString(invite.PlotId)
+	if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	// create transaction session
+	txSession, err := h.MongoDB.Client().StartSession()
+	if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	defer txSession.EndSession(ctx)
+	txOpts := options.Transaction().SetReadConcern(readconcern.Snapshot()).SetWriteConcern(writeconcern.Majority())
+
+	_, err = txSession.WithTransaction(ctx, func(txCtx context.Context) (interface{}, error) {
+
+		res, err := invitesColl.UpdateOne(txCtx, pendingFilter, bson.M{
+			"$set": bson.M{"status": schemas.COLLAB_INVITE_ACCEPTED},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if res.MatchedCount == 0 {
+			return nil, mongo.ErrNoDocuments
+		}
+
+		// inviter must still own the plot
+		if err := h.MongoDB.Collection("users").FindOne(txCtx, bson.M{
+			"_id":     invite.From,
+			"plotIds": invite.PlotId,
+		}).Err(); err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return nil, plotutils.ErrNotPlotOwner
+			}
+			return nil, err
+		}
+
+		// add collaborator if not already present and under limit
+		collabLimitThreshold := fmt.Sprintf("collaborators.%d", config.PLOT_COLLABORATOR_LIMIT-1)
+		res, err = h.MongoDB.Collection("plots").UpdateOne(txCtx,
+			bson.M{
+				"plotId":             plotId.Id,
+				"collaborators.uid":  bson.M{"$ne": uid},
+				collabLimitThreshold: bson.M{"$exists": false},
+			},
+			bson.M{
+				"$push": bson.M{"collaborators": schemas.Collaborator{
+					Uid:     uid,
+					Role:    invite.Role,
+					AddedAt: time.Now().UTC(),
+				}},
+			},
+		)
+		if err != nil {
+			return nil, err
+		}
+		if res.MatchedCount == 0 {
+			return nil, errNoPlotAccess
+		}
+
+		return nil, nil
+
+	}, txOpts)
+
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			resParams.Code = http.StatusNotFound
+		} else if errors.Is(err, plotutils.ErrNotPlotOwner) || errors.Is(err, errNoPlotAccess) {
+			resParams.ResData = &struct {
+				Conflict bool `json:"conflict"`
+			}{Conflict: true}
+			resParams.Code = http.StatusConflict
+		} else {
+			resParams.Code = http.StatusInternalServerError
+		}
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	resParams.Code = http.StatusOK
+	h.Res(resParams)
+
+}
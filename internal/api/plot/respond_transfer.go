@@ -0,0 +1,194 @@
+package plot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+	"trraformapi/internal/api"
+	"trraformapi/pkg/config"
+	plotutils "trraformapi/pkg/plot_utils"
+	"trraformapi/pkg/schemas"
+	"trraformapi/pkg/utils"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"go.mongodb.org/mongo-driver/v2/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/v2/mongo/writeconcern"
+	"go.uber.org/zap"
+)
+
+func (h *Handler) RespondTransfer(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	ctx := r.Context()
+	uid := ctx.Value("uid").(bson.ObjectID)
+	resParams := &api.ResParams{W: w, R: r}
+
+	var reqData struct {
+		TransferId string `json:"transferId" validate:"required,mongodb"`
+		Accept     bool   `json:"accept"`
+	}
+
+	// validate request body
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&reqData); err != nil {
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	resParams.ReqData = reqData
+	if err := h.Validate.Struct(&reqData); err != nil {
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	transferId, _ := bson.ObjectIDFromHex(reqData.TransferId)
+	transfersColl := h.MongoDB.Collection("transfers")
+	pendingFilter := bson.M{
+		"_id":       transferId,
+		"to":        uid,
+		"status":    schemas.TRANSFER_PENDING,
+		"expiresAt": bson.M{"$gt": time.Now().UTC()},
+	}
+
+	// decline
+	if !reqData.Accept {
+		res, err := transfersColl.UpdateOne(ctx, pendingFilter, bson.M{
+			"$set": bson.M{"status": schemas.TRANSFER_DECLINED},
+		})
+		if err != nil {
+			resParams.Code = http.StatusInternalServerError
+			resParams.Err = err
+			h.Res(resParams)
+			return
+		}
+		if res.MatchedCount == 0 {
+			resParams.Code = http.StatusNotFound
+			h.Res(resParams)
+			return
+		}
+		resParams.Code = http.StatusOK
+		h.Res(resParams)
+		return
+	}
+
+	var transfer schemas.PlotTransfer
+	if err := transfersColl.FindOne(ctx, pendingFilter).Decode(&transfer); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			resParams.Code = http.StatusNotFound
+		} else {
+			resParams.Code = http.StatusInternalServerError
+		}
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	plotId, err := plotutils.PlotIdFromHexString(transfer.PlotId)
+	if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	lockOwner := uuid.NewString()
+
+	// lock plot to prevent concurrent ownership changes
+	failedIds, err := plotutils.LockPlots(h.RedisCli, ctx, []string{transfer.PlotId}, lockOwner)
+	if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	if len(failedIds) > 0 {
+		resParams.ResData = &struct {
+			Conflict bool `json:"conflict"`
+		}{Conflict: true}
+		resParams.Code = http.StatusConflict
+		h.Res(resParams)
+		return
+	}
+	defer plotutils.UnlockPlots(h.RedisCli, lockOwner)
+
+	// create transaction session
+	txSession, err := h.MongoDB.Client().StartSession()
+	if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	defer txSession.EndSession(ctx)
+	txOpts := options.Transaction().SetReadConcern(readconcern.Snapshot()).SetWriteConcern(writeconcern.Majority())
+
+	var versionKeys []string
+	_, err = txSession.WithTransaction(ctx, func(txCtx context.Context) (interface{}, error) {
+
+		res, err := transfersColl.UpdateOne(txCtx, pendingFilter, bson.M{
+			"$set": bson.M{"status": schemas.TRANSFER_ACCEPTED},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if res.MatchedCount == 0 {
+			return nil, mongo.ErrNoDocuments
+		}
+
+		versionKeys, err = plotutils.TransferPlot(h.MongoDB, txCtx, plotId, transfer.From, uid, "gift")
+		return nil, err
+
+	}, txOpts)
+
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			resParams.Code = http.StatusNotFound
+		} else if errors.Is(err, plotutils.ErrNotPlotOwner) {
+			resParams.ResData = &struct {
+				Conflict bool `json:"conflict"`
+			}{Conflict: true}
+			resParams.Code = http.StatusConflict
+		} else if errors.Is(err, plotutils.ErrPlotLimit) {
+			resParams.ResData = &struct {
+				PlotLimitExceeded bool `json:"plotLimitExceeded"`
+			}{PlotLimitExceeded: true}
+			resParams.Code = http.StatusBadRequest
+		} else {
+			resParams.Code = http.StatusInternalServerError
+		}
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	// the transfer has committed, the steps after it are logged rather than failing it.
+	// reconcile repairs stale owner metadata
+	logFields := []zap.Field{zap.String("plotId", transfer.PlotId), zap.String("transfer", transfer.Id.Hex())}
+
+	// the sender's history is already gone from mongo, leftover objects can't be read
+	if err := plotutils.DeleteVersionObjects(h.R2Cli, ctx, versionKeys); err != nil {
+		h.Logger.Error("Error deleting version objects", append(logFields, zap.Error(err))...)
+	}
+
+	// update owner shown on the map
+	var user schemas.User
+	if err := h.MongoDB.Collection("users").FindOne(ctx, bson.M{"_id": uid}).Decode(&user); err != nil {
+		h.Logger.Error("Error getting recipient", append(logFields, zap.Error(err))...)
+	} else if err := plotutils.UpdatePlotMetadata(h.RedisCli, h.R2Cli, ctx, plotId, plotutils.PlotMetadata(&user)); err != nil {
+		h.Logger.Error("Error updating plot metadata", append(logFields, zap.Error(err))...)
+	} else if err := utils.PurgeCacheCDN(h.HttpCli, ctx, []string{config.PLOT_CDN_URL + "/" + transfer.PlotId + ".dat"}); err != nil {
+		h.Logger.Error("Error purging plot cache", append(logFields, zap.Error(err))...)
+	}
+
+	resParams.Code = http.StatusOK
+	h.Res(resParams)
+
+}
@@ -0,0 +1,269 @@
+package plot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"trraformapi/internal/api"
+	"trraformapi/pkg/config"
+	plotutils "trraformapi/pkg/plot_utils"
+	"trraformapi/pkg/schemas"
+	"trraformapi/pkg/utils"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"go.uber.org/zap"
+)
+
+var errInvalidPlotData = errors.New("invalid plot data")
+var errNotEntitled = errors.New("plot data requires an active subscription")
+
+type staleRevisionError struct {
+	revision int64
+}
+
+func (e *staleRevisionError) Error() string {
+	return fmt.Sprintf("stale plot revision, current revision is %d", e.revision)
+}
+
+// parses an If-Match header holding a plot revision, nil if absent or *
+func parseIfMatch(r *http.Request) (*int64, error) {
+
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return nil, nil
+	}
+	header = strings.Trim(strings.TrimPrefix(header, "W/"), `"`)
+	revision, err := strconv.ParseInt(header, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid If-Match header: %w", err)
+	}
+
+	return &revision, nil
+
+}
+
+// current revision and R2 etag of a plot
+func (h *Handler) plotRevision(ctx context.Context, plotId *plotutils.PlotId) (*schemas.Plot, error) {
+
+	var plot schemas.Plot
+	if err := h.MongoDB.Collection("plots").FindOne(ctx,
+		bson.M{"plotId": plotId.Id},
+		options.FindOne().SetProjection(bson.M{"revision": 1, "etag": 1, "subplots": 1}),
+	).Decode(&plot); err != nil {
+		return nil, err
+	}
+
+	return &plot, nil
+
+}
+
+// matches the plot document only while it's at the revision and etag read in plot
+func revisionFilter(plotId *plotutils.PlotId, plot *schemas.Plot) bson.M {
+
+	filter := bson.M{"plotId": plotId.Id, "revision": plot.Revision}
+	if plot.Revision == 0 {
+		filter["revision"] = bson.M{"$in": bson.A{0, nil}}
+	}
+
+	return filter
+
+}
+
+// R2 can hold an object mongo doesn't know about when a save's put lands but its revision update doesn't.
+// a save that loses the conditional put while the revision is unchanged adopts the current R2 etag, so
+// the save after it isn't rejected forever. a concurrent save that put first commits the same etag
+func (h *Handler) resyncETag(ctx context.Context, plotId *plotutils.PlotId, plot *schemas.Plot) error {
+
+	current, found, err := utils.ObjectETagR2(h.R2Cli, ctx, config.CF_PLOT_BUCKET, plotId.ToString()+".dat")
+	if err != nil || !found || current == plot.ETag {
+		return err
+	}
+
+	filter := revisionFilter(plotId, plot)
+	if plot.ETag == "" {
+		filter["etag"] = bson.M{"$exists": false}
+	} else {
+		filter["etag"] = plot.ETag
+	}
+	result, err := h.MongoDB.Collection("plots").UpdateOne(ctx, filter, bson.M{"$set": bson.M{"etag": current}})
+	if err != nil {
+		return err
+	}
+	if result.ModifiedCount > 0 {
+		h.Logger.Warn("Resynced plot etag from R2", zap.String("plotId", plotId.ToString()), zap.Int64("revision", plot.Revision))
+	}
+
+	return nil
+
+}
+
+func (h *Handler) staleRevision(ctx context.Context, plotId *plotutils.PlotId) error {
+
+	plot, err := h.plotRevision(ctx, plotId)
+	if err != nil {
+		return err
+	}
+
+	return &staleRevisionError{revision: plot.Revision}
+
+}
+
+// validates, stores and versions plot data on behalf of an owner or editor, returns the new revision.
+// if ifMatch is set the save is rejected unless it equals the current revision
+func (h *Handler) savePlot(ctx context.Context, uid bson.ObjectID, plotId *plotutils.PlotId, plotData *plotutils.PlotData, ifMatch *int64) (int64, error) {
+
+	plotIdStr := plotId.ToString()
+
+	// check that user owns plot or is an editor
+	owner, role, err := h.plotAccess(ctx, uid, plotId)
+	if err != nil {
+		return 0, err
+	}
+	if role != schemas.COLLAB_OWNER && role != schemas.COLLAB_EDITOR {
+		return 0, errNoPlotAccess
+	}
+
+	// validate plot data
+	if err := h.Validate.Struct(plotData); err != nil {
+		return 0, fmt.Errorf("%w: %w", errInvalidPlotData, err)
+	}
+
+	// check that plot is within build size constraints for subscription status
+	// link and large build size only allowed for subscribed users, editors use the owner's subscription
+	buildSize := plotData.BuildData[1]
+	if buildSize < config.MIN_BUILD_SIZE || buildSize > config.LRG_BUILD_SIZE || (!owner.Subscription.IsActive && (plotData.Link != "" || plotData.LinkTitle != "" || buildSize > config.STD_BUILD_SIZE)) {
+		return 0, errNotEntitled
+	}
+
+	// encode plot data, the CDN served object stays in the public version and history uses the compact one
+	plotDataBytes, err := plotData.EncodePublic()
+	if err != nil {
+		return 0, err
+	}
+	versionBytes, err := plotData.Encode()
+	if err != nil {
+		return 0, err
+	}
+
+	plot, err := h.plotRevision(ctx, plotId)
+	if err != nil {
+		return 0, err
+	}
+	if ifMatch != nil && *ifMatch != plot.Revision {
+		return 0, &staleRevisionError{revision: plot.Revision}
+	}
+
+	// upload plot data (don't set verified status here)
+	// the put is conditional on the etag of the revision read above, so a concurrent save can't slip in between.
+	// plots saved before revisions were tracked have no etag, theirs is read from R2 first
+	matchETag := plot.ETag
+	if matchETag == "" {
+		if matchETag, _, err = utils.ObjectETagR2(h.R2Cli, ctx, config.CF_PLOT_BUCKET, plotIdStr+".dat"); err != nil {
+			return 0, err
+		}
+	}
+	metadata := plotutils.PlotMetadata(owner)
+	etag, err := utils.PutObjectIfMatchR2(h.R2Cli, ctx, config.CF_PLOT_BUCKET, plotIdStr+".dat", bytes.NewReader(plotDataBytes), "application/octet-stream", metadata, matchETag)
+	if errors.Is(err, utils.ErrPreconditionFailed) {
+		if err := h.resyncETag(ctx, plotId, plot); err != nil {
+			return 0, err
+		}
+		return 0, h.staleRevision(ctx, plotId)
+	} else if err != nil {
+		return 0, err
+	}
+
+	revFilter := revisionFilter(plotId, plot)
+	// mirror the searchable fields from the plot data and owner
+	set := bson.M{"etag": etag}
+	maps.Copy(set, plotutils.PlotDataSearchFields(plotId, plotData))
+	maps.Copy(set, plotutils.PlotOwnerSearchFields(owner))
+	markers := plotutils.SubplotMarkers(plotData.BuildData)
+	set["subplots"] = markers
+	result, err := h.MongoDB.Collection("plots").UpdateOne(ctx, revFilter, bson.M{
+		"$set": set,
+		"$inc": bson.M{"revision": 1},
+	})
+	if err != nil {
+		return 0, err
+	}
+	if result.MatchedCount == 0 {
+		return 0, h.staleRevision(ctx, plotId)
+	}
+	revision := plot.Revision + 1
+
+	// the save has committed, the steps after it are logged rather than failing it
+	logFields := []zap.Field{zap.String("plotId", plotIdStr), zap.Int64("revision", revision)}
+
+	// subplots are only claimable while placed in the build
+	if plot.Subplots == nil || !slices.Equal(plot.Subplots, markers) {
+		if err := plotutils.SyncSubplotAvailability(h.MongoDB, h.RedisCli, ctx, plotId, markers); err != nil {
+			h.Logger.Error("Error syncing subplot availability", append(logFields, zap.Error(err))...)
+		}
+	}
+
+	// keep an immutable copy of this save
+	version := schemas.PlotVersion{
+		Id:         bson.NewObjectID(),
+		PlotId:     plotIdStr,
+		Revision:   revision,
+		Ctime:      time.Now().UTC(),
+		Size:       len(versionBytes),
+		BlockCount: plotutils.BlockCount(plotData.BuildData),
+		Author:     uid,
+	}
+	version.Key = plotIdStr + "/" + version.Id.Hex() + ".dat"
+	if err := utils.PutObjectR2(h.R2Cli, ctx, config.CF_VERSION_BUCKET, version.Key, bytes.NewReader(versionBytes), "application/octet-stream", nil); err != nil {
+		h.Logger.Error("Error uploading plot version", append(logFields, zap.Error(err))...)
+	} else if _, err := h.MongoDB.Collection("plotVersions").InsertOne(ctx, &version); err != nil {
+		h.Logger.Error("Error recording plot version", append(logFields, zap.Error(err))...)
+	}
+
+	retention := config.VERSION_RETENTION_STD
+	if owner.Subscription.IsActive {
+		retention = config.VERSION_RETENTION_SUB
+	}
+	if err := plotutils.PruneVersions(h.MongoDB, h.R2Cli, ctx, plotIdStr, retention); err != nil {
+		h.Logger.Error("Error pruning plot versions", append(logFields, zap.Error(err))...)
+	}
+
+	// the chunk is flagged for a rebuild once the levels of detail are written
+	go h.renderThumbnail(plotId, plotData.BuildData, revision)
+	go h.renderLODs(plotId, plotData, revision)
+
+	return revision, nil
+
+}
+
+// maps savePlot errors to a response
+func (h *Handler) saveErr(resParams *api.ResParams, err error) {
+
+	var staleErr *staleRevisionError
+	switch {
+	case errors.As(err, &staleErr):
+		resParams.Code = http.StatusConflict
+		resParams.ResData = map[string]any{"revision": staleErr.revision}
+		setRevisionHeader(resParams.W, staleErr.revision)
+	case errors.Is(err, errNoPlotAccess), errors.Is(err, errNotEntitled):
+		resParams.Code = http.StatusUnauthorized
+	case errors.Is(err, errInvalidPlotData):
+		resParams.Code = http.StatusBadRequest
+	default:
+		resParams.Code = http.StatusInternalServerError
+	}
+	resParams.Err = err
+	h.Res(resParams)
+
+}
+
+func setRevisionHeader(w http.ResponseWriter, revision int64) {
+	w.Header().Set("ETag", `"`+strconv.FormatInt(revision, 10)+`"`)
+}
@@ -0,0 +1,177 @@
+package plot
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"trraformapi/internal/api"
+	"trraformapi/pkg/config"
+	plotutils "trraformapi/pkg/plot_utils"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+const searchPageSize = 20
+
+type searchResult struct {
+	Id          bson.ObjectID `bson:"_id"`
+	PlotId      uint64        `bson:"plotId"`
+	Name        string        `bson:"name"`
+	Description string        `bson:"description"`
+	LinkTitle   string        `bson:"linkTitle"`
+	OwnerName   string        `bson:"ownerName"`
+	Depth       int           `bson:"depth"`
+	Verified    bool          `bson:"verified"`
+	Votes       int           `bson:"votes"`
+	Rank        float64       `bson:"rank"`
+}
+
+type searchRes struct {
+	PlotId      string `json:"plotId"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	LinkTitle   string `json:"linkTitle"`
+	Owner       string `json:"owner"`
+	Depth       int    `json:"depth"`
+	Verified    bool   `json:"verified"`
+	Votes       int    `json:"votes"`
+}
+
+// full text search over plot names, descriptions, link titles and owners.
+// text relevance is boosted by votes, the cursor is the rank and id of the last result
+func (h *Handler) SearchPlots(w http.ResponseWriter, r *http.Request) {
+
+	ctx := r.Context()
+	resParams := &api.ResParams{W: w, R: r}
+	query := r.URL.Query()
+	resParams.ReqData = query
+
+	q := strings.TrimSpace(query.Get("q"))
+	if q == "" || len(q) > 100 {
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = fmt.Errorf("invalid search query")
+		h.Res(resParams)
+		return
+	}
+
+	filter := bson.M{
+		"$text":  bson.M{"$search": q},
+		"hidden": bson.M{"$ne": true},
+	}
+
+	// optional filters
+	if depthStr := query.Get("depth"); depthStr != "" {
+		depth, err := strconv.Atoi(depthStr)
+		if err != nil || depth < 0 || depth > config.MAX_DEPTH {
+			resParams.Code = http.StatusBadRequest
+			resParams.Err = err
+			h.Res(resParams)
+			return
+		}
+		filter["depth"] = depth
+	}
+	if verifiedStr := query.Get("verified"); verifiedStr != "" {
+		verified, err := strconv.ParseBool(verifiedStr)
+		if err != nil {
+			resParams.Code = http.StatusBadRequest
+			resParams.Err = err
+			h.Res(resParams)
+			return
+		}
+		filter["verified"] = verified
+	}
+	if minVotesStr := query.Get("minVotes"); minVotesStr != "" {
+		minVotes, err := strconv.Atoi(minVotesStr)
+		if err != nil {
+			resParams.Code = http.StatusBadRequest
+			resParams.Err = err
+			h.Res(resParams)
+			return
+		}
+		filter["votes"] = bson.M{"$gte": minVotes}
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		// rank = text score * (1 + log10(1 + votes))
+		{{Key: "$addFields", Value: bson.M{"rank": bson.M{"$multiply": bson.A{
+			bson.M{"$meta": "textScore"},
+			bson.M{"$add": bson.A{1, bson.M{"$log10": bson.M{"$add": bson.A{bson.M{"$max": bson.A{"$votes", 0}}, 1}}}}},
+		}}}}},
+	}
+
+	// cursor is "<rank>_<id>" of the last result of the previous page
+	if cursor := query.Get("cursor"); cursor != "" {
+		rankStr, idStr, _ := strings.Cut(cursor, "_")
+		rank, err := strconv.ParseFloat(rankStr, 64)
+		if err != nil {
+			resParams.Code = http.StatusBadRequest
+			resParams.Err = err
+			h.Res(resParams)
+			return
+		}
+		lastId, err := bson.ObjectIDFromHex(idStr)
+		if err != nil {
+			resParams.Code = http.StatusBadRequest
+			resParams.Err = err
+			h.Res(resParams)
+			return
+		}
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.M{"$or": bson.A{
+			bson.M{"rank": bson.M{"$lt": rank}},
+			bson.M{"rank": rank, "_id": bson.M{"$gt": lastId}},
+		}}}})
+	}
+
+	pipeline = append(pipeline,
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "rank", Value: -1}, {Key: "_id", Value: 1}}}},
+		bson.D{{Key: "$limit", Value: searchPageSize}},
+	)
+
+	cur, err := h.MongoDB.Collection("plots").Aggregate(ctx, pipeline)
+	if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	var plots []searchResult
+	if err := cur.All(ctx, &plots); err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	results := make([]searchRes, len(plots))
+	for i, p := range plots {
+		results[i] = searchRes{
+			PlotId:      (&plotutils.PlotId{Id: p.PlotId}).ToString(),
+			Name:        p.Name,
+			Description: p.Description,
+			LinkTitle:   p.LinkTitle,
+			Owner:       p.OwnerName,
+			Depth:       p.Depth,
+			Verified:    p.Verified,
+			Votes:       p.Votes,
+		}
+	}
+	nextCursor := ""
+	if len(plots) == searchPageSize {
+		last := plots[len(plots)-1]
+		nextCursor = strconv.FormatFloat(last.Rank, 'g', -1, 64) + "_" + last.Id.Hex()
+	}
+
+	resParams.ResData = &struct {
+		Plots      []searchRes `json:"plots"`
+		NextCursor string      `json:"nextCursor"`
+	}{
+		Plots:      results,
+		NextCursor: nextCursor,
+	}
+	resParams.Code = http.StatusOK
+	h.Res(resParams)
+
+}
@@ -0,0 +1,241 @@
+package plot
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"trraformapi/internal/api"
+	"trraformapi/pkg/config"
+	plotutils "trraformapi/pkg/plot_utils"
+	"trraformapi/pkg/schemas"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type subplotSettingsRes struct {
+	Reserved        []int    `json:"reserved"`
+	RequireApproval bool     `json:"requireApproval"`
+	Approved        []string `json:"approved"` // usernames
+	Price           int64    `json:"price"`
+}
+
+// only the owner of a plot that can have subplots
+func (h *Handler) subplotSettingsAccess(resParams *api.ResParams, uid bson.ObjectID, plotId *plotutils.PlotId) bool {
+
+	if plotId.Depth() >= config.MAX_DEPTH {
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = errors.New("plot can't have subplots")
+		h.Res(resParams)
+		return false
+	}
+
+	_, role, err := h.plotAccess(resParams.R.Context(), uid, plotId)
+	if err != nil && !errors.Is(err, errNoPlotAccess) {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return false
+	}
+	if role != schemas.COLLAB_OWNER {
+		resParams.Code = http.StatusUnauthorized
+		resParams.Err = err
+		h.Res(resParams)
+		return false
+	}
+
+	return true
+
+}
+
+func (h *Handler) GetSubplotSettings(w http.ResponseWriter, r *http.Request) {
+
+	ctx := r.Context()
+	uid := ctx.Value("uid").(bson.ObjectID)
+	resParams := &api.ResParams{W: w, R: r}
+
+	plotIdStr := r.URL.Query().Get("plotId")
+	resParams.ReqData = plotIdStr
+	plotId, err := plotutils.PlotIdFromHexString(plotIdStr)
+	if err != nil || !plotId.Validate() {
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	if !h.subplotSettingsAccess(resParams, uid, plotId) {
+		return
+	}
+
+	var plot schemas.Plot
+	if err := h.MongoDB.Collection("plots").FindOne(ctx,
+		bson.M{"plotId": plotId.Id},
+		options.FindOne().SetProjection(bson.M{"subplotSettings": 1}),
+	).Decode(&plot); err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	settings := plot.SubplotSettings
+
+	usernames, err := h.usernames(ctx, settings.Approved)
+	if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	approved := make([]string, 0, len(settings.Approved))
+	for _, approvedUid := range settings.Approved {
+		if username, ok := usernames[approvedUid]; ok {
+			approved = append(approved, username)
+		}
+	}
+	reserved := settings.Reserved
+	if reserved == nil {
+		reserved = []int{}
+	}
+
+	resParams.ResData = &subplotSettingsRes{
+		Reserved:        reserved,
+		RequireApproval: settings.RequireApproval,
+		Approved:        approved,
+		Price:           settings.Price,
+	}
+	resParams.Code = http.StatusOK
+	h.Res(resParams)
+
+}
+
+// replaces the subplot settings of a plot. approved users are given by username
+func (h *Handler) UpdateSubplotSettings(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	ctx := r.Context()
+	uid := ctx.Value("uid").(bson.ObjectID)
+	resParams := &api.ResParams{W: w, R: r}
+
+	var reqData struct {
+		PlotId          string   `json:"plotId" validate:"required,plotid"`
+		Reserved        []int    `json:"reserved" validate:"max=24,unique,dive,min=1,max=24"`
+		RequireApproval bool     `json:"requireApproval"`
+		Approved        []string `json:"approved" validate:"max=100,unique,dive,username"`
+		Price           int64    `json:"price"` // cents, 0 for the depth price
+	}
+
+	// validate request body
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&reqData); err != nil {
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	resParams.ReqData = reqData
+	if err := h.Validate.Struct(&reqData); err != nil {
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	if reqData.Price != 0 && (reqData.Price < config.MARKET_MIN_PRICE || reqData.Price > config.MARKET_MAX_PRICE) {
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = errors.New("price out of range")
+		h.Res(resParams)
+		return
+	}
+
+	plotId, _ := plotutils.PlotIdFromHexString(reqData.PlotId)
+	if !h.subplotSettingsAccess(resParams, uid, plotId) {
+		return
+	}
+
+	// priced subplots are paid out to the parent owner's connect account
+	if reqData.Price > 0 {
+		var user schemas.User
+		if err := h.MongoDB.Collection("users").FindOne(ctx,
+			bson.M{"_id": uid},
+			options.FindOne().SetProjection(bson.M{"stripeConnect": 1}),
+		).Decode(&user); err != nil {
+			resParams.Code = http.StatusInternalServerError
+			resParams.Err = err
+			h.Res(resParams)
+			return
+		}
+		chargesEnabled := false
+		if user.StripeConnect != "" {
+			account, err := h.StripeCli.V1Accounts.GetByID(ctx, user.StripeConnect, nil)
+			if err != nil {
+				resParams.Code = http.StatusInternalServerError
+				resParams.Err = err
+				h.Res(resParams)
+				return
+			}
+			chargesEnabled = account.ChargesEnabled
+		}
+		if !chargesEnabled {
+			resParams.ResData = &struct {
+				NoConnectAccount bool `json:"noConnectAccount"`
+			}{NoConnectAccount: true}
+			resParams.Code = http.StatusForbidden
+			h.Res(resParams)
+			return
+		}
+	}
+
+	// resolve approved usernames
+	approved := []bson.ObjectID{}
+	if len(reqData.Approved) > 0 {
+		cursor, err := h.MongoDB.Collection("users").Find(ctx,
+			bson.M{"username": bson.M{"$in": reqData.Approved}},
+			options.Find().SetProjection(bson.M{"_id": 1}),
+		)
+		if err != nil {
+			resParams.Code = http.StatusInternalServerError
+			resParams.Err = err
+			h.Res(resParams)
+			return
+		}
+		var users []schemas.User
+		if err := cursor.All(ctx, &users); err != nil {
+			resParams.Code = http.StatusInternalServerError
+			resParams.Err = err
+			h.Res(resParams)
+			return
+		}
+		if len(users) != len(reqData.Approved) {
+			resParams.ResData = &struct {
+				UserNotFound bool `json:"userNotFound"`
+			}{UserNotFound: true}
+			resParams.Code = http.StatusNotFound
+			h.Res(resParams)
+			return
+		}
+		for _, user := range users {
+			approved = append(approved, user.Id)
+		}
+	}
+
+	settings := schemas.SubplotSettings{
+		Reserved:        reqData.Reserved,
+		RequireApproval: reqData.RequireApproval,
+		Approved:        approved,
+		Price:           reqData.Price,
+	}
+	if _, err := h.MongoDB.Collection("plots").UpdateOne(ctx,
+		bson.M{"plotId": plotId.Id},
+		bson.M{"$set": bson.M{"subplotSettings": settings}},
+	); err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	resParams.Code = http.StatusOK
+	h.Res(resParams)
+
+}
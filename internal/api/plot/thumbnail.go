@@ -0,0 +1,92 @@
+package plot
+
+import (
+	"context"
+	"errors"
+	"time"
+	"trraformapi/pkg/config"
+	plotutils "trraformapi/pkg/plot_utils"
+	"trraformapi/pkg/render"
+
+	"go.uber.org/zap"
+)
+
+// limits concurrent thumbnail and level of detail renders so a burst of saves can't starve request handling
+var thumbnailSem = make(chan struct{}, 4)
+
+// renders and uploads <plotId>.png next to the plot data, runs after the save response is sent.
+// a render is dropped if a newer revision was saved while it ran, or skipped without the palette.
+// thumbnails are png only, there's no pure go webp encoder so webp output was dropped
+func (h *Handler) renderThumbnail(plotId *plotutils.PlotId, buildData []uint16, revision int64) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	thumbnailSem <- struct{}{}
+	defer func() { <-thumbnailSem }()
+
+	img, err := render.IsometricPNG(buildData, config.THUMBNAIL_SIZE)
+	if errors.Is(err, render.ErrNoPalette) {
+		return
+	} else if err != nil {
+		h.Logger.Error("Error rendering thumbnail", zap.String("plotId", plotId.ToString()), zap.Error(err))
+		return
+	}
+
+	plot, err := h.plotRevision(ctx, plotId)
+	if err != nil {
+		h.Logger.Error("Error rendering thumbnail", zap.String("plotId", plotId.ToString()), zap.Error(err))
+		return
+	}
+	if plot.Revision != revision {
+		return
+	}
+
+	if err := plotutils.SaveThumbnail(h.RedisCli, h.R2Cli, ctx, plotId, img); err != nil {
+		h.Logger.Error("Error uploading thumbnail", zap.String("plotId", plotId.ToString()), zap.Error(err))
+	}
+
+}
+
+// downsamples and uploads the levels of detail, then flags the chunk for a rebuild so it never reads
+// levels older than the save. same as renderThumbnail they're dropped if a newer revision was saved
+// while they rendered, that save flags the chunk after writing its own
+func (h *Handler) renderLODs(plotId *plotutils.PlotId, plotData *plotutils.PlotData, revision int64) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	thumbnailSem <- struct{}{}
+	defer func() { <-thumbnailSem }()
+
+	logFields := []zap.Field{zap.String("plotId", plotId.ToString()), zap.Int64("revision", revision)}
+
+	lods, err := plotutils.EncodeLODs(plotData)
+	if err != nil {
+		h.Logger.Error("Error rendering levels of detail", append(logFields, zap.Error(err))...)
+	}
+
+	// when the revision can't be checked the levels might be stale, they're removed instead
+	plot, err := h.plotRevision(ctx, plotId)
+	if err != nil {
+		h.Logger.Error("Error rendering levels of detail", append(logFields, zap.Error(err))...)
+		lods = nil
+	} else if plot.Revision != revision {
+		return
+	}
+
+	// without levels the rebuild uses the full plot data
+	if lods == nil {
+		err = plotutils.DeleteLODs(h.RedisCli, h.R2Cli, ctx, plotId)
+	} else {
+		err = plotutils.SaveLODs(h.RedisCli, h.R2Cli, ctx, plotId, lods)
+	}
+	if err != nil {
+		h.Logger.Error("Error uploading levels of detail", append(logFields, zap.Error(err))...)
+	}
+
+	if err := plotutils.FlagPlotForUpdate(h.RedisCli, ctx, plotId, false); err != nil {
+		h.Logger.Error("Error flagging plot for update", append(logFields, zap.Error(err))...)
+	}
+
+}
@@ -1,20 +1,14 @@
 package plot
 
 import (
-	"bytes"
 	"encoding/base64"
 	"encoding/json"
-	"errors"
 	"net/http"
-	"strconv"
 	"trraformapi/internal/api"
-	"trraformapi/pkg/config"
 	plotutils "trraformapi/pkg/plot_utils"
-	"trraformapi/pkg/schemas"
 	"trraformapi/pkg/utils"
 
 	"go.mongodb.org/mongo-driver/v2/bson"
-	"go.mongodb.org/mongo-driver/v2/mongo"
 )
 
 func (h *Handler) UpdatePlot(w http.ResponseWriter, r *http.Request) {
@@ -33,6 +27,14 @@ func (h *Handler) UpdatePlot(w http.ResponseWriter, r *http.Request) {
 		BuildData   string `json:"buildData" validate:"required,base64"`
 	}
 
+	ifMatch, err := parseIfMatch(r)
+	if err != nil {
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
 	// validate request body
 	decoder := json.NewDecoder(r.Body)
 	decoder.DisallowUnknownFields()
@@ -50,9 +52,7 @@ func (h *Handler) UpdatePlot(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// validate plot id
-	plotId, err := plotutils.PlotIdFromHexString(reqData.PlotId)
-	plotIdStr := plotId.ToString()
+	plotId, _ := plotutils.PlotIdFromHexString(reqData.PlotId)
 
 	// decode base64 buildData
 	buildDataBytes, _ := base64.StdEncoding.DecodeString(reqData.BuildData)
@@ -64,22 +64,6 @@ func (h *Handler) UpdatePlot(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// get user data, check that user owns plot
-	var user schemas.User
-	if err := h.MongoDB.Collection("users").FindOne(ctx, bson.M{
-		"_id":     uid,
-		"plotIds": plotIdStr,
-	}).Decode(&user); err != nil {
-		if errors.Is(err, mongo.ErrNoDocuments) {
-			resParams.Code = http.StatusUnauthorized
-		} else {
-			resParams.Code = http.StatusInternalServerError
-		}
-		resParams.Err = err
-		h.Res(resParams)
-		return
-	}
-
 	// create plot data (don't set verified status here)
 	plotData := plotutils.PlotData{
 		Name:        reqData.Name,
@@ -89,53 +73,15 @@ func (h *Handler) UpdatePlot(w http.ResponseWriter, r *http.Request) {
 		BuildData:   buildData,
 	}
 
-	// validate plot data
-	if err := h.Validate.Struct(&plotData); err != nil {
-		resParams.Code = http.StatusBadRequest
-		resParams.Err = err
-		h.Res(resParams)
-		return
-	}
-
-	// check that plot is within build size constraints for subscription status
-	// link and large build size only allowed for subscribed users
-	buildSize := buildData[1]
-	if buildSize < config.MIN_BUILD_SIZE || buildSize > config.LRG_BUILD_SIZE || (!user.Subscription.IsActive && (plotData.Link != "" || plotData.LinkTitle != "" || buildSize > config.STD_BUILD_SIZE)) {
-		resParams.Code = http.StatusUnauthorized
-		resParams.Err = err
-		h.Res(resParams)
-		return
-	}
-
-	// encode plot data
-	plotDataBytes, err := plotData.Encode()
+	revision, err := h.savePlot(ctx, uid, plotId, &plotData, ifMatch)
 	if err != nil {
-		resParams.Code = http.StatusInternalServerError
-		resParams.Err = err
-		h.Res(resParams)
-		return
-	}
-
-	// upload plot data
-	metadata := map[string]string{
-		"owner":    user.Username,
-		"verified": strconv.FormatBool(user.Subscription.IsActive),
-	}
-	if err := utils.PutObjectR2(h.R2Cli, ctx, config.CF_PLOT_BUCKET, plotIdStr+".dat", bytes.NewReader(plotDataBytes), "application/octet-stream", metadata); err != nil {
-		resParams.Code = http.StatusInternalServerError
-		resParams.Err = err
-		h.Res(resParams)
-		return
-	}
-
-	if err := plotutils.FlagPlotForUpdate(h.RedisCli, ctx, plotId, false); err != nil {
-		resParams.Code = http.StatusInternalServerError
-		resParams.Err = err
-		h.Res(resParams)
+		h.saveErr(resParams, err)
 		return
 	}
 
+	setRevisionHeader(w, revision)
 	resParams.Code = http.StatusOK
+	resParams.ResData = map[string]any{"revision": revision}
 	h.Res(resParams)
 
 }
@@ -0,0 +1,107 @@
+package plot
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"trraformapi/internal/api"
+	"trraformapi/pkg/config"
+	plotutils "trraformapi/pkg/plot_utils"
+
+	"github.com/klauspost/compress/zstd"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// saves plot data sent as a raw application/octet-stream body in the PlotData.Encode framing,
+// optionally compressed with zstd or gzip. not subject to the global request size limit
+func (h *Handler) UploadPlot(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	ctx := r.Context()
+	uid := ctx.Value("uid").(bson.ObjectID)
+	resParams := &api.ResParams{W: w, R: r}
+
+	plotIdStr := r.URL.Query().Get("plotId")
+	resParams.ReqData = plotIdStr
+	plotId, err := plotutils.PlotIdFromHexString(plotIdStr)
+	if err != nil || !plotId.Validate() {
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	ifMatch, err := parseIfMatch(r)
+	if err != nil {
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/octet-stream" {
+		resParams.Code = http.StatusUnsupportedMediaType
+		resParams.Err = fmt.Errorf("unsupported content type %q", r.Header.Get("Content-Type"))
+		h.Res(resParams)
+		return
+	}
+
+	// limit compressed size, the decompressed stream is bounded by DecodeReader
+	var body io.Reader = http.MaxBytesReader(w, r.Body, config.PLOT_UPLOAD_MAX_SIZE)
+	switch r.Header.Get("Content-Encoding") {
+	case "", "identity":
+	case "gzip":
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			resParams.Code = http.StatusBadRequest
+			resParams.Err = err
+			h.Res(resParams)
+			return
+		}
+		defer gz.Close()
+		body = gz
+	case "zstd":
+		zr, err := zstd.NewReader(body, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(config.PLOT_UPLOAD_MAX_SIZE))
+		if err != nil {
+			resParams.Code = http.StatusBadRequest
+			resParams.Err = err
+			h.Res(resParams)
+			return
+		}
+		defer zr.Close()
+		body = zr
+	default:
+		resParams.Code = http.StatusUnsupportedMediaType
+		resParams.Err = fmt.Errorf("unsupported content encoding %q", r.Header.Get("Content-Encoding"))
+		h.Res(resParams)
+		return
+	}
+
+	plotData, err := plotutils.DecodeReader(body, config.LRG_BUILD_SIZE)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			resParams.Code = http.StatusRequestEntityTooLarge
+		} else {
+			resParams.Code = http.StatusBadRequest
+		}
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	revision, err := h.savePlot(ctx, uid, plotId, plotData, ifMatch)
+	if err != nil {
+		h.saveErr(resParams, err)
+		return
+	}
+
+	setRevisionHeader(w, revision)
+	resParams.Code = http.StatusOK
+	resParams.ResData = map[string]any{"revision": revision}
+	h.Res(resParams)
+
+}
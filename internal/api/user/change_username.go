@@ -7,6 +7,7 @@ import (
 	"strings"
 	"time"
 	"trraformapi/internal/api"
+	plotutils "trraformapi/pkg/plot_utils"
 
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
@@ -89,6 +90,14 @@ func (h *Handler) ChangeUsername(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// owner metadata on plots is rewritten in the background
+	if err := plotutils.QueueOwnerSync(h.MongoDB, ctx, uid); err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
 	resParams.Code = http.StatusOK
 	h.Res(resParams)
 
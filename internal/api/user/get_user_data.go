@@ -56,6 +56,7 @@ func (h *Handler) GetUserData(w http.ResponseWriter, r *http.Request) {
 		HasFreePlot bool              `json:"hasFreePlot"`
 		PlotCredits int               `json:"plotCredits"`
 		PlotIds     []string          `json:"plotIds"`
+		Privacy     schemas.Privacy   `json:"privacy"`
 		Offenses    []schemas.Offense `json:"offenses"`
 	}{
 		Token:       token,
@@ -64,6 +65,7 @@ func (h *Handler) GetUserData(w http.ResponseWriter, r *http.Request) {
 		HasFreePlot: user.FreePlot == "",
 		PlotCredits: user.PlotCredits,
 		PlotIds:     user.PlotIds,
+		Privacy:     user.Privacy,
 		Offenses:    user.Offenses,
 	}
 	resParams.Code = http.StatusOK
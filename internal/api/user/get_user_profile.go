@@ -0,0 +1,119 @@
+package user
+
+import (
+	"errors"
+	"net/http"
+	"time"
+	"trraformapi/internal/api"
+	plotutils "trraformapi/pkg/plot_utils"
+	"trraformapi/pkg/schemas"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type profilePlot struct {
+	PlotId string `json:"plotId"`
+	Name   string `json:"name"`
+	Votes  int    `json:"votes"`
+}
+
+func (h *Handler) GetUserProfile(w http.ResponseWriter, r *http.Request) {
+
+	ctx := r.Context()
+	resParams := &api.ResParams{W: w, R: r}
+
+	username := chi.URLParam(r, "username")
+	resParams.ReqData = username
+	if err := h.Validate.Var(username, "required,username"); err != nil {
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	// get user data
+	var user schemas.User
+	if err := h.MongoDB.Collection("users").FindOne(ctx, bson.M{"username": username}).Decode(&user); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			resParams.Code = http.StatusNotFound
+		} else {
+			resParams.Code = http.StatusInternalServerError
+		}
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	// get names and votes for each plot, a bad plot id is skipped rather than failing the profile
+	ids := make([]uint64, 0, len(user.PlotIds))
+	idStrs := make([]string, 0, len(user.PlotIds))
+	for _, plotIdStr := range user.PlotIds {
+		if plotId, err := plotutils.PlotIdFromHexString(plotIdStr); err == nil {
+			ids = append(ids, plotId.Id)
+			idStrs = append(idStrs, plotIdStr)
+		}
+	}
+	cursor, err := h.MongoDB.Collection("plots").Find(ctx,
+		bson.M{"plotId": bson.M{"$in": ids}},
+		options.Find().SetProjection(bson.M{"plotId": 1, "name": 1, "votes": 1}),
+	)
+	if err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	var plotDocs []schemas.Plot
+	if err := cursor.All(ctx, &plotDocs); err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	totalVotes := 0
+	docs := make(map[uint64]*schemas.Plot, len(plotDocs))
+	for i := range plotDocs {
+		docs[plotDocs[i].PlotId] = &plotDocs[i]
+		totalVotes += plotDocs[i].Votes
+	}
+
+	// names are mirrored from the plot data when the plot is saved. plots without a document
+	// are left out, reconcile reports them. anonymous owners' plots aren't tied to their profile
+	plotsHidden := user.Privacy.HidePlots || user.Privacy.AnonymousOwner
+	var plots []profilePlot
+	if !plotsHidden {
+		plots = make([]profilePlot, 0, len(ids))
+		for i, id := range ids {
+			if doc, ok := docs[id]; ok {
+				plots = append(plots, profilePlot{
+					PlotId: idStrs[i],
+					Name:   doc.Name,
+					Votes:  doc.Votes,
+				})
+			}
+		}
+	}
+
+	resParams.ResData = &struct {
+		Username    string        `json:"username"`
+		Joined      time.Time     `json:"joined"`
+		Verified    bool          `json:"verified"`
+		PlotsHidden bool          `json:"plotsHidden"`
+		Plots       []profilePlot `json:"plots"`
+		TotalVotes  int           `json:"totalVotes"`
+	}{
+		Username:    user.Username,
+		Joined:      user.Ctime,
+		Verified:    user.Subscription.IsActive,
+		PlotsHidden: plotsHidden,
+		Plots:       plots,
+		TotalVotes:  totalVotes,
+	}
+	resParams.Code = http.StatusOK
+	h.Res(resParams)
+
+}
@@ -0,0 +1,72 @@
+package user
+
+import (
+	"encoding/json"
+	"net/http"
+	"trraformapi/internal/api"
+	plotutils "trraformapi/pkg/plot_utils"
+	"trraformapi/pkg/schemas"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+func (h *Handler) UpdatePrivacy(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	ctx := r.Context()
+	uid := ctx.Value("uid").(bson.ObjectID)
+	resParams := &api.ResParams{W: w, R: r}
+
+	var reqData schemas.Privacy
+
+	// validate request body
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&reqData); err != nil {
+		resParams.Code = http.StatusBadRequest
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+	resParams.ReqData = reqData
+
+	// update settings, keep previous document to see what changed
+	var prevUser schemas.User
+	if err := h.MongoDB.Collection("users").FindOneAndUpdate(ctx,
+		bson.M{"_id": uid},
+		bson.M{"$set": bson.M{"privacy": reqData}},
+		options.FindOneAndUpdate().SetReturnDocument(options.Before),
+	).Decode(&prevUser); err != nil {
+		resParams.Code = http.StatusInternalServerError
+		resParams.Err = err
+		h.Res(resParams)
+		return
+	}
+
+	// owner shown in plot metadata changed
+	if prevUser.Privacy.AnonymousOwner != reqData.AnonymousOwner {
+		if err := plotutils.QueueOwnerSync(h.MongoDB, ctx, uid); err != nil {
+			resParams.Code = http.StatusInternalServerError
+			resParams.Err = err
+			h.Res(resParams)
+			return
+		}
+	}
+
+	// hidden plots are left out of search
+	if prevUser.Privacy.HidePlots != reqData.HidePlots {
+		user := prevUser
+		user.Privacy = reqData
+		if err := plotutils.UpdatePlotsSearchFields(h.MongoDB, ctx, &user); err != nil {
+			resParams.Code = http.StatusInternalServerError
+			resParams.Err = err
+			h.Res(resParams)
+			return
+		}
+	}
+
+	resParams.Code = http.StatusOK
+	h.Res(resParams)
+
+}
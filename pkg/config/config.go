@@ -1,20 +1,25 @@
 package config
 
 import (
+	"errors"
+	"io/fs"
 	"log"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
 )
 
 const (
-	CF_ZONE_ID       = "64097c6d2cf0e0810ca05cdf8d4d1273"
-	CF_ACCOUNT_ID    = "1534f5e1cce37d41a018df4c9716751e"
-	CF_PLOT_BUCKET   = "plots-dev"
-	GOOGLE_CLIENT_ID = "505214281747-g26m4g2lv692ff819neq6pbus4q6f36f.apps.googleusercontent.com"
-	ORIGIN           = "http://localhost:5173"
-	MONGO_DB         = "TrraformDev"
+	CF_ZONE_ID        = "64097c6d2cf0e0810ca05cdf8d4d1273"
+	CF_ACCOUNT_ID     = "1534f5e1cce37d41a018df4c9716751e"
+	CF_PLOT_BUCKET    = "plots-dev"
+	PLOT_CDN_URL      = "https://plots-dev.trraform.com"
+	CF_VERSION_BUCKET = "plot-versions-dev"
+	GOOGLE_CLIENT_ID  = "505214281747-g26m4g2lv692ff819neq6pbus4q6f36f.apps.googleusercontent.com"
+	ORIGIN            = "http://localhost:5173"
+	MONGO_DB          = "TrraformDev"
 
 	MAX_COLOR_IDX   = 30649
 	DEP0_PLOT_COUNT = 34998
@@ -25,10 +30,33 @@ const (
 	LRG_BUILD_SIZE  = 72
 	MIN_BUILD_SIZE  = 6
 
+	THUMBNAIL_SIZE        = 512                                                          // px
+	LOD_LEVELS            = 2                                                            // 1/2 and 1/4 resolution
+	BUILD_DATA_VERSION    = 0                                                            // first value of run length build data, the only build version clients write
+	PLOT_DATA_VERSION     = 1                                                            // version written by PlotData.Encode to private storage, Decode reads every version
+	PLOT_PUBLIC_VERSION   = 0                                                            // version of CDN served plot objects, read by clients and the chunk builder
+	PLOT_JSON_MAX_SIZE    = 1 << 14                                                      // bytes
+	PLOT_BUILD_MAX_LEN    = 2 + LRG_BUILD_SIZE*LRG_BUILD_SIZE*LRG_BUILD_SIZE             // uint16 values
+	PLOT_BUILD_V1_MAX_LEN = 3*3 + 3*127 + 6*LRG_BUILD_SIZE*LRG_BUILD_SIZE*LRG_BUILD_SIZE // bytes, decompressed v1 stream with a 3 byte header and palette varints and 6 bytes per run
+	PLOT_UPLOAD_MAX_SIZE  = 8 + PLOT_JSON_MAX_SIZE + 2*PLOT_BUILD_MAX_LEN                // bytes
+	VOX_IMPORT_MAX_SIZE   = 1 << 24                                                      // bytes
+
 	USER_PLOT_LIMIT          = 100
+	PLOT_COLLABORATOR_LIMIT  = 10
+	VERSION_RETENTION_STD    = 10
+	VERSION_RETENTION_SUB    = 100
 	MAX_CART_SIZE            = 40
 	SUBSCRIPTION_BONUS_PLOTS = 6
 	PRICE_ID_SUBSCRIPTION    = "price_1RwGA7GgpUJInHeUsm3DANJK" // DEV!!
+
+	// refunds when a plot is released, only for plots still held by the user who claimed them
+	RELEASE_REFUND_FREE     = true  // released free plot restores the free plot slot
+	RELEASE_REFUND_CREDIT   = true  // released credit plot refunds the credit
+	RELEASE_REFUND_PURCHASE = false // released purchased plot refunds a credit
+
+	MARKET_FEE_PERCENT = 10
+	MARKET_MIN_PRICE   = 100     // cents
+	MARKET_MAX_PRICE   = 1000000 // cents
 )
 
 var PRICE_ID_DEPTH = []string{
@@ -38,6 +66,10 @@ var PRICE_ID_DEPTH = []string{
 }
 var CHECKOUT_SESSION_DURATION time.Duration = time.Minute * 30
 var API_TIMEOUT time.Duration = time.Minute * 5
+var TRANSFER_DURATION time.Duration = time.Hour * 24 * 7
+var COLLAB_INVITE_DURATION time.Duration = time.Hour * 24 * 7
+var RELEASE_COOLDOWN time.Duration = time.Hour * 24
+var RELEASE_REFUND_WINDOW time.Duration = time.Hour * 24 * 30
 
 type EnvVars struct {
 	CF_TURNSTILE_SECRET_KEY string
@@ -59,11 +91,10 @@ func init() {
 
 	prod := os.Getenv("ENV") == "prod"
 
+	// .env is optional, variables can come from the environment. binaries check theirs with RequireEnv
 	if !prod {
-		err := godotenv.Load()
-		if err != nil {
-			log.Fatal("Error loading .env file")
-			return
+		if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Fatal("Error loading .env file: ", err)
 		}
 	}
 
@@ -82,3 +113,18 @@ func init() {
 	}
 
 }
+
+// exits when one of the given variables is unset, called by the binaries that need them
+func RequireEnv(names ...string) {
+
+	var missing []string
+	for _, name := range names {
+		if os.Getenv(name) == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		log.Fatal("Missing environment variables: ", strings.Join(missing, ", "))
+	}
+
+}
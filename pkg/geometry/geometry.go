@@ -0,0 +1,227 @@
+// world geometry of depth 0 plots, built from the chunk map produced by scripts/make_chunks.
+// plots are surface voxels of the world build, grouped into chunks of up to CHUNK_SIZE plots
+package geometry
+
+import (
+	"cmp"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"slices"
+	"trraformapi/pkg/config"
+)
+
+// bump with the embedded file name when the chunk map is regenerated, clients and the
+// chunk worker compare it against their own copy
+const ChunkMapVersion = 1
+
+// edge length of the world build the plot positions index into
+const WorldSize = 115
+
+// (chunk id, world voxel index) little endian uint32 pairs, entry i is plot id i+1
+//
+//go:embed chunk_map_v1.dat
+var chunkMapData []byte
+
+type Vec3 [3]int // x, y, z with y up
+
+var (
+	chunkMapHash string
+	plotChunk    []uint32 // by plot id - 1
+	plotPos      []Vec3   // by plot id - 1
+	chunkPlots   map[uint32][]uint64
+	posPlot      map[Vec3]uint64
+	grid         map[Vec3][]uint64 // plots by gridCell
+)
+
+const gridCell = 8
+
+func init() {
+
+	n := len(chunkMapData) / 8
+	if n != config.DEP0_PLOT_COUNT {
+		panic("geometry: chunk map doesn't match DEP0_PLOT_COUNT")
+	}
+
+	sum := sha256.Sum256(chunkMapData)
+	chunkMapHash = hex.EncodeToString(sum[:])
+
+	plotChunk = make([]uint32, n)
+	plotPos = make([]Vec3, n)
+	chunkPlots = make(map[uint32][]uint64)
+	posPlot = make(map[Vec3]uint64, n)
+	grid = make(map[Vec3][]uint64)
+
+	for i := range n {
+		chunk := binary.LittleEndian.Uint32(chunkMapData[8*i:])
+		idx := int(binary.LittleEndian.Uint32(chunkMapData[8*i+4:]))
+		pos := Vec3{idx % WorldSize, idx / (WorldSize * WorldSize), idx % (WorldSize * WorldSize) / WorldSize}
+		plotId := uint64(i + 1)
+
+		plotChunk[i] = chunk
+		plotPos[i] = pos
+		chunkPlots[chunk] = append(chunkPlots[chunk], plotId)
+		posPlot[pos] = plotId
+		cell := cellOf(pos)
+		grid[cell] = append(grid[cell], plotId)
+	}
+
+}
+
+// sha256 of the embedded chunk map
+func Hash() string {
+	return chunkMapHash
+}
+
+func ChunkCount() int {
+	return len(chunkPlots)
+}
+
+func valid(plotId uint64) bool {
+	return plotId >= 1 && plotId <= uint64(len(plotChunk))
+}
+
+// chunk of a depth 0 plot
+func Chunk(plotId uint64) (uint32, bool) {
+
+	if !valid(plotId) {
+		return 0, false
+	}
+
+	return plotChunk[plotId-1], true
+
+}
+
+// world voxel position of a depth 0 plot
+func Position(plotId uint64) (Vec3, bool) {
+
+	if !valid(plotId) {
+		return Vec3{}, false
+	}
+
+	return plotPos[plotId-1], true
+
+}
+
+// depth 0 plots in a chunk, ascending
+func ChunkPlots(chunk uint32) []uint64 {
+	return chunkPlots[chunk]
+}
+
+// plots touching a plot, faces, edges and corners included. ascending
+func Neighbors(plotId uint64) []uint64 {
+
+	pos, ok := Position(plotId)
+	if !ok {
+		return nil
+	}
+
+	var neighbors []uint64
+	for dx := -1; dx <= 1; dx++ {
+		for dy := -1; dy <= 1; dy++ {
+			for dz := -1; dz <= 1; dz++ {
+				if dx == 0 && dy == 0 && dz == 0 {
+					continue
+				}
+				if id, ok := posPlot[Vec3{pos[0] + dx, pos[1] + dy, pos[2] + dz}]; ok {
+					neighbors = append(neighbors, id)
+				}
+			}
+		}
+	}
+	slices.Sort(neighbors)
+
+	return neighbors
+
+}
+
+// mean position of the plots in a chunk
+func ChunkCenter(chunk uint32) (Vec3, bool) {
+
+	plots := chunkPlots[chunk]
+	if len(plots) == 0 {
+		return Vec3{}, false
+	}
+
+	var sum Vec3
+	for _, id := range plots {
+		for i, v := range plotPos[id-1] {
+			sum[i] += v
+		}
+	}
+	for i := range sum {
+		sum[i] = int(math.Round(float64(sum[i]) / float64(len(plots))))
+	}
+
+	return sum, true
+
+}
+
+// depth 0 plots within radius voxels of center, nearest first
+func Within(center Vec3, radius float64) []uint64 {
+
+	if radius < 0 {
+		return nil
+	}
+
+	r2 := radius * radius
+	lo := cellOf(Vec3{center[0] - int(radius), center[1] - int(radius), center[2] - int(radius)})
+	hi := cellOf(Vec3{center[0] + int(radius), center[1] + int(radius), center[2] + int(radius)})
+
+	type hit struct {
+		id uint64
+		d2 float64
+	}
+	var hits []hit
+	for cx := lo[0]; cx <= hi[0]; cx++ {
+		for cy := lo[1]; cy <= hi[1]; cy++ {
+			for cz := lo[2]; cz <= hi[2]; cz++ {
+				for _, id := range grid[Vec3{cx, cy, cz}] {
+					if d2 := dist2(plotPos[id-1], center); d2 <= r2 {
+						hits = append(hits, hit{id, d2})
+					}
+				}
+			}
+		}
+	}
+	slices.SortFunc(hits, func(a, b hit) int {
+		return cmp.Or(cmp.Compare(a.d2, b.d2), cmp.Compare(a.id, b.id))
+	})
+
+	ids := make([]uint64, len(hits))
+	for i, h := range hits {
+		ids[i] = h.id
+	}
+
+	return ids
+
+}
+
+func Distance(a Vec3, b Vec3) float64 {
+	return math.Sqrt(dist2(a, b))
+}
+
+func dist2(a Vec3, b Vec3) float64 {
+
+	var d2 float64
+	for i := range a {
+		d := float64(a[i] - b[i])
+		d2 += d * d
+	}
+
+	return d2
+
+}
+
+func cellOf(pos Vec3) Vec3 {
+
+	var cell Vec3
+	for i, v := range pos {
+		cell[i] = int(math.Floor(float64(v) / gridCell))
+	}
+
+	return cell
+
+}
@@ -0,0 +1,158 @@
+package plotutils
+
+import (
+	"context"
+	"strconv"
+	"trraformapi/pkg/config"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// unclaimed plots are kept in a set per depth. a plot in its set can still be locked by a
+// checkout, so it is only available while no claimlock key exists for it. locks expire on
+// their own, so lock expiry needs no changes to the sets
+
+func AvailabilityKey(depth int) string {
+	return "openplots:" + strconv.Itoa(depth)
+}
+
+// take claimed plots out of the index and open their subplots, new plots get the default
+// build which places every subplot
+func MarkPlotsClaimed(redisCli *redis.Client, ctx context.Context, plotIds []*PlotId) error {
+
+	pipe := redisCli.TxPipeline()
+	for _, plotId := range plotIds {
+		depth := plotId.Depth()
+		pipe.SRem(ctx, AvailabilityKey(depth), plotId.ToString())
+		if depth < config.MAX_DEPTH {
+			pipe.SAdd(ctx, AvailabilityKey(depth+1), subplotIdStrs(plotId)...)
+		}
+	}
+	_, err := pipe.Exec(ctx)
+
+	return err
+
+}
+
+// put released plots back in the index, their unclaimed subplots close with them
+func MarkPlotsOpen(redisCli *redis.Client, ctx context.Context, plotIds []*PlotId) error {
+
+	pipe := redisCli.TxPipeline()
+	for _, plotId := range plotIds {
+		depth := plotId.Depth()
+		pipe.SAdd(ctx, AvailabilityKey(depth), plotId.ToString())
+		if depth < config.MAX_DEPTH {
+			pipe.SRem(ctx, AvailabilityKey(depth+1), subplotIdStrs(plotId)...)
+		}
+	}
+	_, err := pipe.Exec(ctx)
+
+	return err
+
+}
+
+// availability of each plot id, ids are expected to be normalized
+func PlotsAvailable(redisCli *redis.Client, ctx context.Context, plotIds []*PlotId) ([]bool, error) {
+
+	pipe := redisCli.Pipeline()
+	open := make([]*redis.BoolCmd, len(plotIds))
+	locked := make([]*redis.IntCmd, len(plotIds))
+	for i, plotId := range plotIds {
+		plotIdStr := plotId.ToString()
+		open[i] = pipe.SIsMember(ctx, AvailabilityKey(plotId.Depth()), plotIdStr)
+		locked[i] = pipe.Exists(ctx, "claimlock:"+plotIdStr)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	available := make([]bool, len(plotIds))
+	for i := range plotIds {
+		available[i] = open[i].Val() && locked[i].Val() == 0
+	}
+
+	return available, nil
+
+}
+
+// up to n random available plots at a depth, plots from candidates are preferred
+func RandomAvailablePlots(redisCli *redis.Client, ctx context.Context, depth int, n int, candidates []*PlotId) ([]*PlotId, error) {
+
+	var result []*PlotId
+	seen := make(map[uint64]struct{})
+	take := func(plotIds []*PlotId) error {
+		available, err := PlotsAvailable(redisCli, ctx, plotIds)
+		if err != nil {
+			return err
+		}
+		for i, plotId := range plotIds {
+			if _, ok := seen[plotId.Id]; ok || !available[i] || len(result) == n {
+				continue
+			}
+			seen[plotId.Id] = struct{}{}
+			result = append(result, plotId)
+		}
+		return nil
+	}
+
+	if len(candidates) > 0 {
+		if err := take(candidates); err != nil {
+			return nil, err
+		}
+	}
+
+	// sample extra to make up for locked plots, a few rounds at most
+	for round := 0; round < 3 && len(result) < n; round++ {
+		idStrs, err := redisCli.SRandMemberN(ctx, AvailabilityKey(depth), int64(2*(n-len(result)))).Result()
+		if err != nil {
+			return nil, err
+		}
+		if len(idStrs) == 0 {
+			break
+		}
+		plotIds := make([]*PlotId, 0, len(idStrs))
+		for _, idStr := range idStrs {
+			if plotId, err := PlotIdFromHexString(idStr); err == nil {
+				plotIds = append(plotIds, plotId)
+			}
+		}
+		if err := take(plotIds); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+
+}
+
+// number of unclaimed plots at each depth, plots locked by a checkout are included
+func OpenPlotCounts(redisCli *redis.Client, ctx context.Context) ([]int64, error) {
+
+	pipe := redisCli.Pipeline()
+	cmds := make([]*redis.IntCmd, config.MAX_DEPTH+1)
+	for depth := range cmds {
+		cmds[depth] = pipe.SCard(ctx, AvailabilityKey(depth))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	counts := make([]int64, len(cmds))
+	for i, cmd := range cmds {
+		counts[i] = cmd.Val()
+	}
+
+	return counts, nil
+
+}
+
+func subplotIdStrs(plotId *PlotId) []any {
+
+	ids := make([]any, config.SUBPLOT_COUNT)
+	for i := range ids {
+		ids[i] = CreateSubplotId(plotId, uint64(i+1)).ToString()
+	}
+
+	return ids
+
+}
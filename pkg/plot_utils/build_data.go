@@ -0,0 +1,100 @@
+package plotutils
+
+import (
+	"fmt"
+	"math"
+	"trraformapi/pkg/config"
+)
+
+// number of non-empty blocks in run-length encoded build data
+func BlockCount(buildData []uint16) int {
+
+	if len(buildData) < 2 {
+		return 0
+	}
+
+	count := 0
+	var val uint16
+	for _, v := range buildData[2:] {
+		if v&1 == 1 {
+			val = v >> 1
+			if val != 0 {
+				count++
+			}
+		} else if val != 0 {
+			count += int(v >> 1)
+		}
+	}
+
+	return count
+
+}
+
+// expands run-length encoded build data to one color index per voxel (x + z*bs + y*bs^2),
+// same as expand() in scripts/make_chunks. voxels past the end of the data are empty
+func ExpandBuildData(buildData []uint16) ([]uint16, error) {
+
+	if len(buildData) < 2 {
+		return nil, fmt.Errorf("in ExpandBuildData: missing header")
+	}
+
+	bs := int(buildData[1])
+	voxels := make([]uint16, bs*bs*bs)
+
+	i := 0
+	var val uint16
+	for _, v := range buildData[2:] {
+		n := 1
+		if v&1 == 1 {
+			val = v >> 1
+		} else {
+			n = int(v >> 1)
+		}
+		if i+n > len(voxels) {
+			return nil, fmt.Errorf("in ExpandBuildData: block count exceeds build size")
+		}
+		for range n {
+			voxels[i] = val
+			i++
+		}
+	}
+
+	return voxels, nil
+
+}
+
+// run-length encodes one color index per voxel, inverse of ExpandBuildData
+func CompressBuildData(version uint16, buildSize int, voxels []uint16) []uint16 {
+
+	// trailing empty voxels are implied
+	end := len(voxels)
+	for end > 0 && voxels[end-1] == 0 {
+		end--
+	}
+
+	buildData := []uint16{version, uint16(buildSize)}
+	for i := 0; i < end; {
+		val := voxels[i]
+		buildData = append(buildData, val<<1|1)
+		i++
+
+		// subplot markers are never followed by a repeat
+		if val > 0 && val <= config.SUBPLOT_COUNT {
+			continue
+		}
+
+		n := 0
+		for i < end && voxels[i] == val {
+			n++
+			i++
+		}
+		for n > 0 {
+			r := min(n, math.MaxUint16>>1)
+			buildData = append(buildData, uint16(r)<<1)
+			n -= r
+		}
+	}
+
+	return buildData
+
+}
@@ -0,0 +1,98 @@
+package plotutils
+
+import (
+	"math"
+	"slices"
+	"testing"
+	"trraformapi/pkg/config"
+)
+
+func TestCompressBuildData(t *testing.T) {
+
+	bs := config.MIN_BUILD_SIZE
+	tests := []struct {
+		name   string
+		voxels map[int]uint16 // non-empty voxels
+		want   []uint16
+	}{
+		{"empty", nil, []uint16{0, uint16(bs)}},
+		{"single", map[int]uint16{0: 100}, []uint16{0, uint16(bs), 100<<1 | 1}},
+		{"run", map[int]uint16{0: 100, 1: 100, 2: 100}, []uint16{0, uint16(bs), 100<<1 | 1, 2 << 1}},
+		{"leading empties", map[int]uint16{3: 100}, []uint16{0, uint16(bs), 1, 2 << 1, 100<<1 | 1}},
+		// adjacent markers are written once each, never as a repeat
+		{"markers", map[int]uint16{0: 1, 1: 1, 2: 2}, []uint16{0, uint16(bs), 1<<1 | 1, 1<<1 | 1, 2<<1 | 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			voxels := make([]uint16, bs*bs*bs)
+			for i, v := range tt.voxels {
+				voxels[i] = v
+			}
+			got := CompressBuildData(0, bs, voxels)
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("CompressBuildData = %v, want %v", got, tt.want)
+			}
+			if n := BlockCount(got); n != len(tt.voxels) {
+				t.Fatalf("BlockCount = %d, want %d", n, len(tt.voxels))
+			}
+			expanded, err := ExpandBuildData(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(expanded, voxels) {
+				t.Fatal("ExpandBuildData doesn't match the compressed voxels")
+			}
+		})
+	}
+
+}
+
+// runs longer than a repeat can hold are split
+func TestCompressBuildDataLongRun(t *testing.T) {
+
+	bs := config.LRG_BUILD_SIZE
+	voxels := make([]uint16, bs*bs*bs)
+	for i := range voxels {
+		voxels[i] = config.MAX_COLOR_IDX
+	}
+
+	buildData := CompressBuildData(0, bs, voxels)
+	for _, v := range buildData[2:] {
+		if v&1 == 0 && int(v>>1) > math.MaxUint16>>1 {
+			t.Fatalf("repeat of %d overflows", v>>1)
+		}
+	}
+	if n := BlockCount(buildData); n != len(voxels) {
+		t.Fatalf("BlockCount = %d, want %d", n, len(voxels))
+	}
+	expanded, err := ExpandBuildData(buildData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(expanded, voxels) {
+		t.Fatal("ExpandBuildData doesn't match the compressed voxels")
+	}
+
+}
+
+func TestExpandBuildDataErrors(t *testing.T) {
+
+	bs := uint16(config.MIN_BUILD_SIZE)
+	tests := []struct {
+		name      string
+		buildData []uint16
+	}{
+		{"missing header", []uint16{0}},
+		{"too many blocks", []uint16{0, bs, 100<<1 | 1, uint16(int(bs)*int(bs)*int(bs)) << 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ExpandBuildData(tt.buildData); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+
+}
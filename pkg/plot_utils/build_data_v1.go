@@ -0,0 +1,184 @@
+package plotutils
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"trraformapi/pkg/config"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// v1 build data is a zstd compressed stream of uvarints:
+//
+//	build version, build size, palette length, palette color indexes..., (run length, value)...
+//
+// values are palette indexes when the palette is non-empty, color indexes otherwise.
+// runs cover voxels in order (x + z*bs + y*bs^2), trailing empty voxels are implied
+
+// v1 is only written when it's smaller than v0, so the compressed part is never larger than
+// a v0 build. the decompressed stream is bounded by config.PLOT_BUILD_V1_MAX_LEN
+
+// builds with fewer distinct colors than this store a palette, so every value fits in one byte
+const v1PaletteMax = 128
+
+var zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedBetterCompression))
+var zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0), zstd.WithDecoderMaxMemory(config.PLOT_BUILD_V1_MAX_LEN))
+
+type run struct {
+	n   int
+	val uint16
+}
+
+// merges v0 writes and repeats into runs of equal voxels
+func buildRuns(buildData []uint16) []run {
+
+	var runs []run
+	var val uint16
+	for _, v := range buildData[2:] {
+		n := 1
+		if v&1 == 1 {
+			val = v >> 1
+		} else {
+			n = int(v >> 1)
+		}
+		if n == 0 {
+			continue
+		}
+		if len(runs) > 0 && runs[len(runs)-1].val == val {
+			runs[len(runs)-1].n += n
+		} else {
+			runs = append(runs, run{n: n, val: val})
+		}
+	}
+
+	if len(runs) > 0 && runs[len(runs)-1].val == 0 {
+		runs = runs[:len(runs)-1]
+	}
+
+	return runs
+
+}
+
+func encodeBuildDataV1(buildData []uint16) ([]byte, error) {
+
+	if len(buildData) < 2 {
+		return nil, fmt.Errorf("in encodeBuildDataV1: missing header")
+	}
+
+	runs := buildRuns(buildData)
+
+	palette := []uint16{}
+	paletteIdx := map[uint16]uint64{}
+	for _, r := range runs {
+		if _, ok := paletteIdx[r.val]; !ok {
+			paletteIdx[r.val] = uint64(len(palette))
+			palette = append(palette, r.val)
+		}
+	}
+	if len(palette) >= v1PaletteMax {
+		palette = palette[:0]
+	}
+
+	buf := make([]byte, 0, 16+len(palette)*3+len(runs)*4)
+	buf = binary.AppendUvarint(buf, uint64(buildData[0]))
+	buf = binary.AppendUvarint(buf, uint64(buildData[1]))
+	buf = binary.AppendUvarint(buf, uint64(len(palette)))
+	for _, c := range palette {
+		buf = binary.AppendUvarint(buf, uint64(c))
+	}
+	for _, r := range runs {
+		buf = binary.AppendUvarint(buf, uint64(r.n))
+		if len(palette) > 0 {
+			buf = binary.AppendUvarint(buf, paletteIdx[r.val])
+		} else {
+			buf = binary.AppendUvarint(buf, uint64(r.val))
+		}
+	}
+
+	return zstdEncoder.EncodeAll(buf, nil), nil
+
+}
+
+// decodes v1 build data back to the v0 uint16 run-length form used everywhere else.
+// build size is checked before any runs are expanded, which bounds the output length
+func decodeBuildDataV1(data []byte, maxBuildSize int) ([]uint16, error) {
+
+	buf, err := zstdDecoder.DecodeAll(data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("in decodeBuildDataV1: %w", err)
+	}
+
+	next := func() (uint64, error) {
+		v, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return 0, fmt.Errorf("in decodeBuildDataV1: invalid varint")
+		}
+		buf = buf[n:]
+		return v, nil
+	}
+
+	var header [3]uint64
+	for i := range header {
+		if header[i], err = next(); err != nil {
+			return nil, err
+		}
+	}
+	ver, bs, paletteLen := header[0], header[1], header[2]
+	if ver > math.MaxUint16 || paletteLen >= v1PaletteMax {
+		return nil, fmt.Errorf("in decodeBuildDataV1: invalid header")
+	}
+	if bs > uint64(maxBuildSize) {
+		return nil, fmt.Errorf("in decodeBuildDataV1: build size %d too large", bs)
+	}
+
+	palette := make([]uint16, paletteLen)
+	for i := range palette {
+		c, err := next()
+		if err != nil {
+			return nil, err
+		}
+		if c > config.MAX_COLOR_IDX {
+			return nil, fmt.Errorf("in decodeBuildDataV1: invalid palette color")
+		}
+		palette[i] = uint16(c)
+	}
+
+	bs3 := bs * bs * bs
+	blkCnt := uint64(0)
+	buildData := []uint16{uint16(ver), uint16(bs)}
+	for len(buf) > 0 {
+		n, err := next()
+		if err != nil {
+			return nil, err
+		}
+		v, err := next()
+		if err != nil {
+			return nil, err
+		}
+
+		if n == 0 || n > bs3-blkCnt {
+			return nil, fmt.Errorf("in decodeBuildDataV1: block count exceeds build size")
+		}
+		blkCnt += n
+
+		if paletteLen > 0 {
+			if v >= paletteLen {
+				return nil, fmt.Errorf("in decodeBuildDataV1: invalid palette index")
+			}
+			v = uint64(palette[v])
+		} else if v > config.MAX_COLOR_IDX {
+			return nil, fmt.Errorf("in decodeBuildDataV1: invalid color")
+		}
+
+		buildData = append(buildData, uint16(v)<<1|1)
+		for n--; n > 0; {
+			r := min(n, math.MaxUint16>>1)
+			buildData = append(buildData, uint16(r)<<1)
+			n -= r
+		}
+	}
+
+	return buildData, nil
+
+}
@@ -0,0 +1,473 @@
+package plotutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"trraformapi/pkg/config"
+	"trraformapi/pkg/schemas"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"go.mongodb.org/mongo-driver/v2/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/v2/mongo/writeconcern"
+)
+
+// per plot claim results, anything else is a subplot conflict reason
+const (
+	CLAIM_OK      = "claimed"
+	CLAIM_LOCKED  = "locked"  // in someone else's checkout
+	CLAIM_TAKEN   = "taken"   // already claimed
+	CLAIM_SKIPPED = "skipped" // another plot in the batch conflicted
+)
+
+var ErrInsufficientCredits = errors.New("not enough plot credits")
+var ErrDuplicatePlotId = errors.New("duplicate plot id")
+
+// claims are all or nothing, when one plot conflicts every plot gets a result and none are claimed
+type ClaimConflictError struct {
+	Results map[string]string
+}
+
+func (e *ClaimConflictError) Error() string {
+	return fmt.Sprintf("claim conflict: %v", e.Results)
+}
+
+func newClaimConflict(plotIds []*PlotId, conflicts map[string]string) *ClaimConflictError {
+
+	results := make(map[string]string, len(plotIds))
+	for _, plotId := range plotIds {
+		plotIdStr := plotId.ToString()
+		if reason, ok := conflicts[plotIdStr]; ok {
+			results[plotIdStr] = reason
+		} else {
+			results[plotIdStr] = CLAIM_SKIPPED
+		}
+	}
+
+	return &ClaimConflictError{Results: results}
+
+}
+
+type ClaimRequest struct {
+	Uid     bson.ObjectID
+	PlotIds []*PlotId
+
+	// schemas.PLOT_SOURCE_CREDIT pays with the free plot first then credits,
+	// schemas.PLOT_SOURCE_PURCHASE is a completed checkout
+	Source string
+
+	// lock held by a checkout session, a lock is taken and released by ClaimPlots when empty
+	LockOwner string
+
+	// purchases only, the checkout's owner priced subplots. the parent owners' shares are
+	// recorded as owed in the claim transaction and paid from the payment intent's charge
+	SubplotPrices map[uint64]int64
+	Session       string
+	PaymentIntent string
+}
+
+type ClaimResult struct {
+	Results map[string]string
+	Claimed []*PlotId     // newly claimed, plots claimed by an earlier delivery of the same purchase are left out
+	User    *schemas.User // after the claim
+}
+
+// plots that aren't claimed yet, and subplots their parent allows uid to claim.
+// doesn't lock, the result only holds while the plots are locked
+func CheckClaimable(mongoDB *mongo.Database, ctx context.Context, uid bson.ObjectID, plotIds []*PlotId) (map[uint64]*SubplotClaim, map[string]string, error) {
+
+	taken, err := claimedPlots(mongoDB, ctx, plotIds)
+	if err != nil {
+		return nil, nil, err
+	}
+	claims, conflicts, err := CheckSubplotClaims(mongoDB, ctx, uid, plotIds)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, plot := range taken {
+		conflicts[(&PlotId{Id: plot.PlotId}).ToString()] = CLAIM_TAKEN
+	}
+
+	return claims, conflicts, nil
+
+}
+
+func claimedPlots(mongoDB *mongo.Database, ctx context.Context, plotIds []*PlotId) ([]schemas.Plot, error) {
+
+	ids := make([]uint64, len(plotIds))
+	for i := range plotIds {
+		ids[i] = plotIds[i].Id
+	}
+	cursor, err := mongoDB.Collection("plots").Find(ctx,
+		bson.M{"plotId": bson.M{"$in": ids}},
+		options.Find().SetProjection(bson.M{"plotId": 1, "owner": 1, "source": 1, "etag": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var taken []schemas.Plot
+	if err := cursor.All(ctx, &taken); err != nil {
+		return nil, err
+	}
+
+	return taken, nil
+
+}
+
+// the one path plots get claimed through. locks the plots, checks ownership and subplot rules,
+// pays, inserts the plot documents and records subplot payouts owed to parent owners in one transaction, then opens subplots in the availability
+// index and writes the default plot, which flags the chunk for a rebuild
+func ClaimPlots(mongoDB *mongo.Database, redisCli *redis.Client, r2Cli *s3.Client, ctx context.Context, req *ClaimRequest) (*ClaimResult, error) {
+
+	purchase := req.Source == schemas.PLOT_SOURCE_PURCHASE
+	plotIdStrs := make([]string, len(req.PlotIds))
+	uniq := make(map[uint64]struct{}, len(req.PlotIds))
+	for i, plotId := range req.PlotIds {
+		if _, isDup := uniq[plotId.Id]; isDup {
+			return nil, ErrDuplicatePlotId
+		}
+		uniq[plotId.Id] = struct{}{}
+		plotIdStrs[i] = plotId.ToString()
+	}
+
+	usersColl := mongoDB.Collection("users")
+	var user schemas.User
+	if err := usersColl.FindOne(ctx, bson.M{"_id": req.Uid}).Decode(&user); err != nil {
+		return nil, err
+	}
+
+	// paid checkouts were checked when the session was created, credits are checked here
+	useFree := user.FreePlot == ""
+	if !purchase {
+		available := user.PlotCredits
+		if useFree {
+			available++
+		}
+		if len(req.PlotIds) > available {
+			return nil, ErrInsufficientCredits
+		}
+		if len(user.PlotIds)+len(req.PlotIds) > config.USER_PLOT_LIMIT {
+			return nil, ErrPlotLimit
+		}
+	}
+
+	// lock plots to prevent duplicate claims, a checkout's own lock is refreshed
+	lockOwner := req.LockOwner
+	if lockOwner == "" {
+		lockOwner = uuid.NewString()
+		defer UnlockPlots(redisCli, lockOwner)
+	}
+	failedIds, err := LockPlots(redisCli, ctx, plotIdStrs, lockOwner)
+	if err != nil {
+		return nil, err
+	}
+	if len(failedIds) > 0 {
+		conflicts := make(map[string]string, len(failedIds))
+		for _, plotIdStr := range failedIds {
+			conflicts[plotIdStr] = CLAIM_LOCKED
+		}
+		return nil, newClaimConflict(req.PlotIds, conflicts)
+	}
+
+	// a retried purchase finds its own plots already claimed
+	taken, err := claimedPlots(mongoDB, ctx, req.PlotIds)
+	if err != nil {
+		return nil, err
+	}
+	// a purchase rechecks subplots, the parent's build or settings may have changed since checkout
+	subplotClaims, subplotConflicts, err := CheckSubplotClaims(mongoDB, ctx, req.Uid, req.PlotIds)
+	if err != nil {
+		return nil, err
+	}
+	plan, err := planClaim(req, taken, subplotClaims, subplotConflicts)
+	if err != nil {
+		return nil, err
+	}
+	results, toClaim, unsaved := plan.results, plan.toClaim, plan.unsaved
+	toClaimStrs := make([]string, len(toClaim))
+	for i, plotId := range toClaim {
+		toClaimStrs[i] = plotId.ToString()
+	}
+	if len(toClaim) == 0 {
+		if err := finishClaim(redisCli, r2Cli, ctx, unsaved, &user); err != nil {
+			return nil, err
+		}
+		return &ClaimResult{Results: results, User: &user}, nil
+	}
+
+	// create transaction session
+	txSession, err := mongoDB.Client().StartSession()
+	if err != nil {
+		return nil, err
+	}
+	defer txSession.EndSession(ctx)
+	txOpts := options.Transaction().SetReadConcern(readconcern.Snapshot()).SetWriteConcern(writeconcern.Majority())
+
+	var updatedUser schemas.User
+	_, err = txSession.WithTransaction(ctx, func(txCtx context.Context) (interface{}, error) {
+
+		filter := bson.M{"_id": req.Uid}
+		update := bson.M{
+			"$addToSet": bson.M{"plotIds": bson.M{"$each": toClaimStrs}},
+		}
+		sources := make([]string, len(toClaim))
+
+		if purchase {
+			update["$addToSet"].(bson.M)["purchasedIds"] = bson.M{"$each": toClaimStrs}
+			for i := range sources {
+				sources[i] = schemas.PLOT_SOURCE_PURCHASE
+			}
+		} else {
+			// free plot first, credits for the rest
+			filter[fmt.Sprintf("plotIds.%d", config.USER_PLOT_LIMIT-len(toClaim))] = bson.M{"$exists": false}
+			credits := len(toClaim)
+			for i := range sources {
+				sources[i] = schemas.PLOT_SOURCE_CREDIT
+			}
+			if useFree {
+				credits--
+				filter["freePlot"] = ""
+				update["$set"] = bson.M{"freePlot": toClaimStrs[0]}
+				sources[0] = schemas.PLOT_SOURCE_FREE
+			}
+			if credits > 0 {
+				filter["plotCredits"] = bson.M{"$gte": credits}
+				update["$inc"] = bson.M{"plotCredits": -credits}
+			}
+		}
+
+		if err := usersColl.FindOneAndUpdate(txCtx, filter, update,
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&updatedUser); errors.Is(err, mongo.ErrNoDocuments) && !purchase {
+			// credits, the free plot or plot slots were used concurrently
+			return nil, ErrInsufficientCredits
+		} else if err != nil {
+			return nil, err
+		}
+
+		now := time.Now().UTC()
+		plotDocs := make([]schemas.Plot, len(toClaim))
+		for i, plotId := range toClaim {
+			plotDocs[i] = schemas.Plot{
+				PlotId: plotId.Id,
+				Ctime:  now,
+				Owner:  req.Uid,
+				Source: sources[i],
+				Depth:  plotId.Depth(),
+				Votes:  0,
+			}
+		}
+		if _, err := mongoDB.Collection("plots").InsertMany(txCtx, plotDocs); err != nil {
+			return nil, err
+		}
+
+		if payouts := subplotPayouts(req, toClaim, subplotClaims, now); len(payouts) > 0 {
+			if _, err := mongoDB.Collection("subplotPayouts").InsertMany(txCtx, payouts); err != nil {
+				return nil, err
+			}
+		}
+
+		return nil, nil
+
+	}, txOpts)
+	if mongo.IsDuplicateKeyError(err) {
+		conflicts := make(map[string]string, len(toClaimStrs))
+		for _, plotIdStr := range toClaimStrs {
+			conflicts[plotIdStr] = CLAIM_TAKEN
+		}
+		return nil, newClaimConflict(req.PlotIds, conflicts)
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := finishClaim(redisCli, r2Cli, ctx, append(unsaved, toClaim...), &updatedUser); err != nil {
+		return nil, err
+	}
+	for _, plotIdStr := range toClaimStrs {
+		results[plotIdStr] = CLAIM_OK
+	}
+
+	return &ClaimResult{Results: results, Claimed: toClaim, User: &updatedUser}, nil
+
+}
+
+// payouts owed to parent owners for the owner priced subplots a purchase claims
+func subplotPayouts(req *ClaimRequest, toClaim []*PlotId, subplotClaims map[uint64]*SubplotClaim, now time.Time) []schemas.SubplotPayout {
+
+	var payouts []schemas.SubplotPayout
+	for _, plotId := range toClaim {
+		price, ok := req.SubplotPrices[plotId.Id]
+		claim := subplotClaims[plotId.Id]
+		if !ok || claim == nil {
+			continue
+		}
+		payouts = append(payouts, schemas.SubplotPayout{
+			PlotId:        plotId.ToString(),
+			Payee:         claim.ParentOwner,
+			Amount:        price * (100 - config.MARKET_FEE_PERCENT) / 100,
+			Session:       req.Session,
+			PaymentIntent: req.PaymentIntent,
+			Status:        schemas.SUBPLOT_PAYOUT_OWED,
+			Ctime:         now,
+		})
+	}
+
+	return payouts
+
+}
+
+type claimPlan struct {
+	results map[string]string // plots an earlier delivery of the same purchase claimed
+	toClaim []*PlotId
+	unsaved []*PlotId // claimed earlier but possibly missing the steps after the commit
+}
+
+// sorts the requested plots into ones to claim and ones a retried purchase already claimed.
+// taken are the plots documents that exist for the requested plots. a purchase keeps the price
+// paid at checkout, but subplots that lost their slot or permission conflict unless the purchase
+// already claimed them. returns a *ClaimConflictError if any plot can't be claimed
+func planClaim(req *ClaimRequest, taken []schemas.Plot, subplotClaims map[uint64]*SubplotClaim, subplotConflicts map[string]string) (*claimPlan, error) {
+
+	purchase := req.Source == schemas.PLOT_SOURCE_PURCHASE
+	plan := &claimPlan{results: map[string]string{}}
+	conflicts := make(map[string]string)
+
+	done := make(map[uint64]bool, len(taken))
+	for _, plot := range taken {
+		plotId := &PlotId{Id: plot.PlotId}
+		plotIdStr := plotId.ToString()
+		if purchase && plot.Owner == req.Uid && plot.Source == schemas.PLOT_SOURCE_PURCHASE {
+			done[plot.PlotId] = true
+			plan.results[plotIdStr] = CLAIM_OK
+			if plot.ETag == "" {
+				plan.unsaved = append(plan.unsaved, plotId)
+			}
+		} else {
+			conflicts[plotIdStr] = CLAIM_TAKEN
+		}
+	}
+
+	for _, plotId := range req.PlotIds {
+		if reason, ok := subplotConflicts[plotId.ToString()]; ok && !done[plotId.Id] {
+			conflicts[plotId.ToString()] = reason
+		}
+	}
+	for _, claim := range subplotClaims {
+		if claim.Price > 0 && !purchase {
+			conflicts[claim.PlotId.ToString()] = SUBPLOT_CHECKOUT_ONLY
+		}
+	}
+	if len(conflicts) > 0 {
+		return nil, newClaimConflict(req.PlotIds, conflicts)
+	}
+
+	for _, plotId := range req.PlotIds {
+		if !done[plotId.Id] {
+			plan.toClaim = append(plan.toClaim, plotId)
+		}
+	}
+
+	return plan, nil
+
+}
+
+// steps after the claim commits. they're idempotent and rerun by a retried purchase for plots its
+// earlier delivery claimed, so a failure here is repaired by the retry. plots the owner has saved
+// since are skipped, the save keeps their build and availability
+func finishClaim(redisCli *redis.Client, r2Cli *s3.Client, ctx context.Context, plotIds []*PlotId, user *schemas.User) error {
+
+	if len(plotIds) == 0 {
+		return nil
+	}
+
+	// the default build places every subplot
+	if err := MarkPlotsClaimed(redisCli, ctx, plotIds); err != nil {
+		return err
+	}
+
+	// writes the default build and flags the chunk for a rebuild
+	for _, plotId := range plotIds {
+		if err := SetDefaultPlot(redisCli, r2Cli, ctx, plotId, user); err != nil {
+			return err
+		}
+	}
+
+	return nil
+
+}
+
+const subplotPricesMetaLen = 500 // stripe's limit on a metadata value
+
+// keeps the "plotId:price" pairs of owner priced subplots in checkout metadata, comma separated
+// and split over "sp", "sp1", "sp2"... so no value is longer than stripe allows
+func SetSubplotPricesMetadata(metadata map[string]string, ownerPriced []string) {
+
+	key, value, n := "sp", "", 0
+	for _, pair := range ownerPriced {
+		if value != "" && len(value)+1+len(pair) > subplotPricesMetaLen {
+			metadata[key] = value
+			n++
+			key, value = fmt.Sprintf("sp%d", n), ""
+		}
+		if value != "" {
+			value += ","
+		}
+		value += pair
+	}
+	if value != "" {
+		metadata[key] = value
+	}
+
+}
+
+// reads the prices SetSubplotPricesMetadata kept in a checkout's metadata
+func SubplotPricesFromMetadata(metadata map[string]string) (map[uint64]int64, error) {
+
+	prices := map[uint64]int64{}
+	for i := 0; ; i++ {
+		key := "sp"
+		if i > 0 {
+			key = fmt.Sprintf("sp%d", i)
+		}
+		ownerPriced, ok := metadata[key]
+		if !ok {
+			return prices, nil
+		}
+		if err := parseSubplotPrices(prices, ownerPriced); err != nil {
+			return nil, err
+		}
+	}
+
+}
+
+// parses a "plotId:price,..." list into prices
+func parseSubplotPrices(prices map[uint64]int64, ownerPriced string) error {
+
+	if ownerPriced == "" {
+		return nil
+	}
+
+	for _, pair := range strings.Split(ownerPriced, ",") {
+		plotIdStr, priceStr, _ := strings.Cut(pair, ":")
+		plotId, err := PlotIdFromHexString(plotIdStr)
+		if err != nil {
+			return err
+		}
+		price, err := strconv.ParseInt(priceStr, 10, 64)
+		if err != nil {
+			return err
+		}
+		prices[plotId.Id] = price
+	}
+
+	return nil
+
+}
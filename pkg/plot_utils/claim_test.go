@@ -0,0 +1,279 @@
+package plotutils
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+	"trraformapi/pkg/config"
+	"trraformapi/pkg/schemas"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func plotIdStrs(plotIds []*PlotId) []string {
+	strs := make([]string, len(plotIds))
+	for i, plotId := range plotIds {
+		strs[i] = plotId.ToString()
+	}
+	return strs
+}
+
+func TestNewClaimConflict(t *testing.T) {
+
+	plotIds := []*PlotId{{Id: 1}, {Id: 2}, {Id: 3}}
+	err := newClaimConflict(plotIds, map[string]string{
+		plotIds[0].ToString(): CLAIM_LOCKED,
+		plotIds[2].ToString(): CLAIM_TAKEN,
+	})
+
+	want := map[string]string{
+		plotIds[0].ToString(): CLAIM_LOCKED,
+		plotIds[1].ToString(): CLAIM_SKIPPED,
+		plotIds[2].ToString(): CLAIM_TAKEN,
+	}
+	if !maps.Equal(err.Results, want) {
+		t.Fatalf("Results = %v, want %v", err.Results, want)
+	}
+
+}
+
+func TestPlanClaim(t *testing.T) {
+
+	uid := bson.NewObjectID()
+	other := bson.NewObjectID()
+	plotIds := []*PlotId{{Id: 1}, {Id: 2}, {Id: 3}}
+	subplotId := CreateSubplotId(plotIds[0], 5)
+
+	tests := []struct {
+		name             string
+		source           string
+		plotIds          []*PlotId
+		taken            []schemas.Plot
+		subplotClaims    map[uint64]*SubplotClaim
+		subplotConflicts map[string]string
+		conflicts        map[string]string // nil when the plan succeeds
+		results          map[string]string
+		toClaim          []*PlotId
+		unsaved          []*PlotId
+	}{
+		{
+			name:    "all free",
+			source:  schemas.PLOT_SOURCE_CREDIT,
+			plotIds: plotIds,
+			results: map[string]string{},
+			toClaim: plotIds,
+		},
+		{
+			name:    "taken",
+			source:  schemas.PLOT_SOURCE_CREDIT,
+			plotIds: plotIds,
+			taken:   []schemas.Plot{{PlotId: 2, Owner: other, Source: schemas.PLOT_SOURCE_CREDIT}},
+			conflicts: map[string]string{
+				plotIds[0].ToString(): CLAIM_SKIPPED,
+				plotIds[1].ToString(): CLAIM_TAKEN,
+				plotIds[2].ToString(): CLAIM_SKIPPED,
+			},
+		},
+		{
+			// only a purchase can find its own plots already claimed
+			name:    "own credit claim",
+			source:  schemas.PLOT_SOURCE_CREDIT,
+			plotIds: plotIds[:1],
+			taken:   []schemas.Plot{{PlotId: 1, Owner: uid, Source: schemas.PLOT_SOURCE_CREDIT, ETag: "e"}},
+			conflicts: map[string]string{
+				plotIds[0].ToString(): CLAIM_TAKEN,
+			},
+		},
+		{
+			name:    "taken by another purchase",
+			source:  schemas.PLOT_SOURCE_PURCHASE,
+			plotIds: plotIds[:2],
+			taken:   []schemas.Plot{{PlotId: 1, Owner: other, Source: schemas.PLOT_SOURCE_PURCHASE}},
+			conflicts: map[string]string{
+				plotIds[0].ToString(): CLAIM_TAKEN,
+				plotIds[1].ToString(): CLAIM_SKIPPED,
+			},
+		},
+		{
+			name:    "retried purchase",
+			source:  schemas.PLOT_SOURCE_PURCHASE,
+			plotIds: plotIds,
+			taken: []schemas.Plot{
+				{PlotId: 1, Owner: uid, Source: schemas.PLOT_SOURCE_PURCHASE, ETag: "e"},
+				{PlotId: 2, Owner: uid, Source: schemas.PLOT_SOURCE_PURCHASE},
+			},
+			results: map[string]string{
+				plotIds[0].ToString(): CLAIM_OK,
+				plotIds[1].ToString(): CLAIM_OK,
+			},
+			toClaim: plotIds[2:],
+			unsaved: plotIds[1:2],
+		},
+		{
+			name:             "subplot conflict",
+			source:           schemas.PLOT_SOURCE_CREDIT,
+			plotIds:          []*PlotId{plotIds[1], subplotId},
+			subplotConflicts: map[string]string{subplotId.ToString(): SUBPLOT_NO_SLOT},
+			conflicts: map[string]string{
+				plotIds[1].ToString(): CLAIM_SKIPPED,
+				subplotId.ToString():  SUBPLOT_NO_SLOT,
+			},
+		},
+		{
+			name:    "priced subplot with credit",
+			source:  schemas.PLOT_SOURCE_CREDIT,
+			plotIds: []*PlotId{subplotId},
+			subplotClaims: map[uint64]*SubplotClaim{
+				subplotId.Id: {PlotId: subplotId, ParentOwner: other, Price: 500},
+			},
+			conflicts: map[string]string{
+				subplotId.ToString(): SUBPLOT_CHECKOUT_ONLY,
+			},
+		},
+		{
+			name:    "unpriced subplot with credit",
+			source:  schemas.PLOT_SOURCE_CREDIT,
+			plotIds: []*PlotId{subplotId},
+			subplotClaims: map[uint64]*SubplotClaim{
+				subplotId.Id: {PlotId: subplotId, ParentOwner: other},
+			},
+			results: map[string]string{},
+			toClaim: []*PlotId{subplotId},
+		},
+		{
+			name:             "subplot lost its slot before the purchase completed",
+			source:           schemas.PLOT_SOURCE_PURCHASE,
+			plotIds:          []*PlotId{subplotId},
+			subplotConflicts: map[string]string{subplotId.ToString(): SUBPLOT_NOT_APPROVED},
+			conflicts: map[string]string{
+				subplotId.ToString(): SUBPLOT_NOT_APPROVED,
+			},
+		},
+		{
+			// the slot was removed after the earlier delivery claimed it
+			name:             "retried subplot purchase",
+			source:           schemas.PLOT_SOURCE_PURCHASE,
+			plotIds:          []*PlotId{subplotId},
+			taken:            []schemas.Plot{{PlotId: subplotId.Id, Owner: uid, Source: schemas.PLOT_SOURCE_PURCHASE, ETag: "e"}},
+			subplotConflicts: map[string]string{subplotId.ToString(): SUBPLOT_NO_SLOT},
+			results: map[string]string{
+				subplotId.ToString(): CLAIM_OK,
+			},
+		},
+		{
+			name:    "priced subplot purchase",
+			source:  schemas.PLOT_SOURCE_PURCHASE,
+			plotIds: []*PlotId{subplotId},
+			subplotClaims: map[uint64]*SubplotClaim{
+				subplotId.Id: {PlotId: subplotId, ParentOwner: other, Price: 500},
+			},
+			results: map[string]string{},
+			toClaim: []*PlotId{subplotId},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &ClaimRequest{Uid: uid, PlotIds: tt.plotIds, Source: tt.source}
+			plan, err := planClaim(req, tt.taken, tt.subplotClaims, tt.subplotConflicts)
+
+			if tt.conflicts != nil {
+				var conflict *ClaimConflictError
+				if !errors.As(err, &conflict) {
+					t.Fatalf("err = %v, want a claim conflict", err)
+				}
+				if !maps.Equal(conflict.Results, tt.conflicts) {
+					t.Fatalf("conflicts = %v, want %v", conflict.Results, tt.conflicts)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !maps.Equal(plan.results, tt.results) {
+				t.Fatalf("results = %v, want %v", plan.results, tt.results)
+			}
+			if got, want := plotIdStrs(plan.toClaim), plotIdStrs(tt.toClaim); !slices.Equal(got, want) {
+				t.Fatalf("toClaim = %v, want %v", got, want)
+			}
+			if got, want := plotIdStrs(plan.unsaved), plotIdStrs(tt.unsaved); !slices.Equal(got, want) {
+				t.Fatalf("unsaved = %v, want %v", got, want)
+			}
+		})
+	}
+
+}
+
+func TestSubplotPricesMetadata(t *testing.T) {
+
+	want := map[uint64]int64{}
+	var ownerPriced []string
+	for i := range 40 {
+		plotId := CreateSubplotId(CreateSubplotId(&PlotId{Id: uint64(0xfffff000 + i)}, 24), uint64(i%24+1))
+		want[plotId.Id] = 999999 + int64(i)
+		ownerPriced = append(ownerPriced, fmt.Sprintf("%s:%d", plotId.ToString(), want[plotId.Id]))
+	}
+
+	metadata := map[string]string{"uid": "u"}
+	SetSubplotPricesMetadata(metadata, ownerPriced)
+	if _, ok := metadata["sp1"]; !ok {
+		t.Fatal("expected prices split over several keys")
+	}
+	for key, value := range metadata {
+		if len(value) > subplotPricesMetaLen {
+			t.Fatalf("%s is %d chars", key, len(value))
+		}
+	}
+
+	got, err := SubplotPricesFromMetadata(metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !maps.Equal(got, want) {
+		t.Fatalf("SubplotPricesFromMetadata = %v, want %v", got, want)
+	}
+
+	// checkouts without owner priced subplots
+	if got, err := SubplotPricesFromMetadata(map[string]string{"uid": "u"}); err != nil || len(got) != 0 {
+		t.Fatalf("SubplotPricesFromMetadata = %v, %v, want no prices", got, err)
+	}
+
+}
+
+func TestSubplotPayouts(t *testing.T) {
+
+	parent := &PlotId{Id: 1}
+	priced, depthPriced := CreateSubplotId(parent, 3), CreateSubplotId(parent, 4)
+	parentOwner := bson.NewObjectID()
+	req := &ClaimRequest{
+		Source:        schemas.PLOT_SOURCE_PURCHASE,
+		SubplotPrices: map[uint64]int64{priced.Id: 1000, CreateSubplotId(parent, 5).Id: 500},
+		Session:       "cs_1",
+		PaymentIntent: "pi_1",
+	}
+	claims := map[uint64]*SubplotClaim{
+		priced.Id:      {PlotId: priced, ParentOwner: parentOwner, Price: 1000},
+		depthPriced.Id: {PlotId: depthPriced, ParentOwner: parentOwner},
+	}
+
+	// the subplot claimed by an earlier delivery isn't in toClaim and isn't owed again
+	payouts := subplotPayouts(req, []*PlotId{priced, depthPriced}, claims, time.Now())
+	if len(payouts) != 1 {
+		t.Fatalf("got %d payouts, want 1", len(payouts))
+	}
+	payout := payouts[0]
+	if payout.PlotId != priced.ToString() || payout.Payee != parentOwner || payout.Status != schemas.SUBPLOT_PAYOUT_OWED {
+		t.Fatalf("payout = %+v", payout)
+	}
+	if want := int64(1000 * (100 - config.MARKET_FEE_PERCENT) / 100); payout.Amount != want {
+		t.Fatalf("Amount = %d, want %d", payout.Amount, want)
+	}
+	if payout.Session != "cs_1" || payout.PaymentIntent != "pi_1" {
+		t.Fatalf("payout = %+v", payout)
+	}
+
+}
@@ -2,7 +2,6 @@ package plotutils
 
 import (
 	"context"
-	"strconv"
 	"trraformapi/pkg/config"
 	"trraformapi/pkg/schemas"
 	"trraformapi/pkg/utils"
@@ -13,10 +12,7 @@ import (
 
 func SetDefaultPlot(redisCli *redis.Client, r2Cli *s3.Client, ctx context.Context, plotId *PlotId, user *schemas.User) error {
 
-	metadata := map[string]string{
-		"owner":    user.Username,
-		"verified": strconv.FormatBool(user.Subscription.IsActive),
-	}
+	metadata := PlotMetadata(user)
 	if err := utils.CopyObjectR2(r2Cli, ctx, config.CF_PLOT_BUCKET, "default.dat", plotId.ToString()+".dat", "application/octet-stream", metadata); err != nil {
 		return err
 	}
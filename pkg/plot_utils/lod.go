@@ -0,0 +1,133 @@
+package plotutils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"trraformapi/pkg/config"
+	"trraformapi/pkg/utils"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/redis/go-redis/v9"
+)
+
+// key of a downsampled copy of a plot, level n is 1/2^n resolution
+func LODKey(plotId *PlotId, level int) string {
+	return fmt.Sprintf("%s.lod%d.dat", plotId.ToString(), level)
+}
+
+// downsamples build data by factor, each factor^3 cell takes its most common color.
+// cells less than a quarter full (subplot markers count as empty) are left empty, which keeps
+// thin walls from disappearing at lower resolutions
+func Downsample(buildData []uint16, factor int) ([]uint16, error) {
+
+	voxels, err := ExpandBuildData(buildData)
+	if err != nil {
+		return nil, err
+	}
+
+	bs := int(buildData[1])
+	lbs := (bs + factor - 1) / factor
+	lod := make([]uint16, lbs*lbs*lbs)
+
+	counts := make(map[uint16]int, factor*factor*factor)
+	for ly := range lbs {
+		for lz := range lbs {
+			for lx := range lbs {
+				clear(counts)
+				filled, total := 0, 0
+				for y := ly * factor; y < min((ly+1)*factor, bs); y++ {
+					for z := lz * factor; z < min((lz+1)*factor, bs); z++ {
+						for x := lx * factor; x < min((lx+1)*factor, bs); x++ {
+							total++
+							if v := voxels[x+z*bs+y*bs*bs]; v > config.SUBPLOT_COUNT {
+								counts[v]++
+								filled++
+							}
+						}
+					}
+				}
+
+				if filled*4 < total {
+					continue
+				}
+
+				// ties go to the lower color index so output is deterministic
+				var best uint16
+				bestN := 0
+				for v, n := range counts {
+					if n > bestN || (n == bestN && v < best) {
+						best, bestN = v, n
+					}
+				}
+				lod[lx+lz*lbs+ly*lbs*lbs] = best
+			}
+		}
+	}
+
+	return CompressBuildData(buildData[0], lbs, lod), nil
+
+}
+
+// encoded levels of detail of a plot, index 0 is level 1
+func EncodeLODs(plotData *PlotData) ([][]byte, error) {
+
+	lods := make([][]byte, 0, config.LOD_LEVELS)
+	for level := 1; level <= config.LOD_LEVELS; level++ {
+		buildData, err := Downsample(plotData.BuildData, 1<<level)
+		if err != nil {
+			return nil, err
+		}
+
+		lodData := *plotData
+		lodData.BuildData = buildData
+		data, err := lodData.EncodePublic()
+		if err != nil {
+			return nil, err
+		}
+		lods = append(lods, data)
+	}
+
+	return lods, nil
+
+}
+
+// uploads levels of detail from EncodeLODs next to the plot data and records which levels exist
+// in the plotlods hash, read by the chunk rebuild alongside the plot data. the entry is removed
+// while the objects are rewritten, so a failed upload leaves the plot without levels rather than stale ones
+func SaveLODs(redisCli *redis.Client, r2Cli *s3.Client, ctx context.Context, plotId *PlotId, lods [][]byte) error {
+
+	plotIdStr := plotId.ToString()
+	if err := redisCli.HDel(ctx, "plotlods", plotIdStr).Err(); err != nil {
+		return err
+	}
+
+	levels := make([]string, 0, len(lods))
+	for i, data := range lods {
+		if err := utils.PutObjectR2(r2Cli, ctx, config.CF_PLOT_BUCKET, LODKey(plotId, i+1), bytes.NewReader(data), "application/octet-stream", nil); err != nil {
+			return err
+		}
+		levels = append(levels, strconv.Itoa(i+1))
+	}
+
+	return redisCli.HSet(ctx, "plotlods", plotIdStr, strings.Join(levels, ",")).Err()
+
+}
+
+func DeleteLODs(redisCli *redis.Client, r2Cli *s3.Client, ctx context.Context, plotId *PlotId) error {
+
+	if err := redisCli.HDel(ctx, "plotlods", plotId.ToString()).Err(); err != nil {
+		return err
+	}
+
+	for level := 1; level <= config.LOD_LEVELS; level++ {
+		if err := utils.DeleteObjectR2(r2Cli, ctx, config.CF_PLOT_BUCKET, LODKey(plotId, level)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+
+}
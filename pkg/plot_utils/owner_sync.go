@@ -0,0 +1,241 @@
+package plotutils
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+	"trraformapi/pkg/config"
+	"trraformapi/pkg/schemas"
+	"trraformapi/pkg/utils"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	OWNER_SYNC_PENDING = "pending"
+	OWNER_SYNC_RUNNING = "running"
+	OWNER_SYNC_DONE    = "done"
+	OWNER_SYNC_FAILED  = "failed"
+
+	ownerSyncLease      = 5 * time.Minute // extended after each purge batch
+	ownerSyncRetryAfter = 5 * time.Minute
+	ownerSyncPurgeBatch = 30 // cloudflare max files per purge request
+)
+
+var ErrOwnerSyncSuperseded = errors.New("owner sync job was requeued while running")
+
+// queue a metadata rewrite for all of a user's plots
+// requeueing restarts the job from the first plot
+func QueueOwnerSync(mongoDB *mongo.Database, ctx context.Context, uid bson.ObjectID) error {
+
+	now := time.Now().UTC()
+	_, err := mongoDB.Collection("ownerSyncJobs").UpdateOne(ctx,
+		bson.M{"uid": uid},
+		bson.M{
+			"$set": bson.M{
+				"status":     OWNER_SYNC_PENDING,
+				"done":       0,
+				"after":      "",
+				"error":      "",
+				"mtime":      now,
+				"leaseUntil": time.Time{},
+			},
+			"$inc":         bson.M{"gen": 1},
+			"$setOnInsert": bson.M{"ctime": now},
+		},
+		options.UpdateOne().SetUpsert(true),
+	)
+
+	return err
+
+}
+
+// claim the oldest pending job, a running job whose worker died,
+// or a failed job that has waited long enough to retry
+func ClaimOwnerSyncJob(mongoDB *mongo.Database, ctx context.Context) (*schemas.OwnerSyncJob, error) {
+
+	now := time.Now().UTC()
+	var job schemas.OwnerSyncJob
+	err := mongoDB.Collection("ownerSyncJobs").FindOneAndUpdate(ctx,
+		bson.M{"$or": bson.A{
+			bson.M{"status": OWNER_SYNC_PENDING},
+			bson.M{"status": OWNER_SYNC_RUNNING, "leaseUntil": bson.M{"$lt": now}},
+			bson.M{"status": OWNER_SYNC_FAILED, "mtime": bson.M{"$lt": now.Add(-ownerSyncRetryAfter)}},
+		}},
+		bson.M{"$set": bson.M{
+			"status":     OWNER_SYNC_RUNNING,
+			"leaseUntil": now.Add(ownerSyncLease),
+		}},
+		options.FindOneAndUpdate().SetSort(bson.M{"mtime": 1}).SetReturnDocument(options.After),
+	).Decode(&job)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+
+}
+
+// plots are synced in plot id order and progress is saved after each cdn purge, a restarted
+// worker resumes after job.After so plots claimed or released in the meantime don't shift it.
+// ownership is rechecked around each batch, a plot transferred or sold while the job runs gets
+// its new owner's metadata
+func RunOwnerSyncJob(mongoDB *mongo.Database, redisCli *redis.Client, r2Cli *s3.Client, httpCli *http.Client, ctx context.Context, job *schemas.OwnerSyncJob) error {
+
+	jobsColl := mongoDB.Collection("ownerSyncJobs")
+
+	// metadata is always built from the current user document
+	var user schemas.User
+	if err := mongoDB.Collection("users").FindOne(ctx, bson.M{"_id": job.Uid}).Decode(&user); err != nil {
+		return finishOwnerSyncJob(jobsColl, ctx, job, err)
+	}
+	metadata := PlotMetadata(&user)
+	plotIds := slices.Sorted(slices.Values(user.PlotIds))
+	total := len(plotIds)
+
+	// search fields in mongo are one write for every plot
+	if err := UpdatePlotsSearchFields(mongoDB, ctx, &user); err != nil {
+		return finishOwnerSyncJob(jobsColl, ctx, job, err)
+	}
+
+	start, found := slices.BinarySearch(plotIds, job.After)
+	if found {
+		start++
+	}
+
+	for ; start < total; start += ownerSyncPurgeBatch {
+
+		end := min(start+ownerSyncPurgeBatch, total)
+		batch := plotIds[start:end]
+
+		owned, err := ownedPlotIds(mongoDB, ctx, job.Uid)
+		if err != nil {
+			return finishOwnerSyncJob(jobsColl, ctx, job, err)
+		}
+		var written []string
+		for _, plotIdStr := range batch {
+			if !owned[plotIdStr] {
+				continue
+			}
+			plotId, err := PlotIdFromHexString(plotIdStr)
+			if err != nil {
+				return finishOwnerSyncJob(jobsColl, ctx, job, err)
+			}
+			if err := UpdatePlotMetadata(redisCli, r2Cli, ctx, plotId, metadata); err != nil {
+				return finishOwnerSyncJob(jobsColl, ctx, job, err)
+			}
+			written = append(written, plotIdStr)
+		}
+
+		// a transfer that committed while the batch was written may have had its metadata overwritten
+		owned, err = ownedPlotIds(mongoDB, ctx, job.Uid)
+		if err != nil {
+			return finishOwnerSyncJob(jobsColl, ctx, job, err)
+		}
+		purgeUrls := make([]string, 0, len(written))
+		for _, plotIdStr := range written {
+			if !owned[plotIdStr] {
+				if err := restorePlotOwnerMetadata(mongoDB, redisCli, r2Cli, ctx, plotIdStr); err != nil {
+					return finishOwnerSyncJob(jobsColl, ctx, job, err)
+				}
+			}
+			purgeUrls = append(purgeUrls, config.PLOT_CDN_URL+"/"+plotIdStr+".dat")
+		}
+		if len(purgeUrls) > 0 {
+			if err := utils.PurgeCacheCDN(httpCli, ctx, purgeUrls); err != nil {
+				return finishOwnerSyncJob(jobsColl, ctx, job, err)
+			}
+		}
+
+		// record progress once the batch is purged, extend lease
+		res, err := jobsColl.UpdateOne(ctx,
+			bson.M{"_id": job.Id, "gen": job.Gen},
+			bson.M{"$set": bson.M{
+				"after":      batch[len(batch)-1],
+				"done":       end,
+				"total":      total,
+				"mtime":      time.Now().UTC(),
+				"leaseUntil": time.Now().UTC().Add(ownerSyncLease),
+			}},
+		)
+		if err != nil {
+			return err
+		}
+		if res.MatchedCount == 0 {
+			return ErrOwnerSyncSuperseded
+		}
+
+	}
+
+	return finishOwnerSyncJob(jobsColl, ctx, job, nil)
+
+}
+
+// plots uid owns now, users.plotIds is the source of truth for ownership
+func ownedPlotIds(mongoDB *mongo.Database, ctx context.Context, uid bson.ObjectID) (map[string]bool, error) {
+
+	var user schemas.User
+	if err := mongoDB.Collection("users").FindOne(ctx,
+		bson.M{"_id": uid},
+		options.FindOne().SetProjection(bson.M{"plotIds": 1}),
+	).Decode(&user); err != nil {
+		return nil, err
+	}
+
+	owned := make(map[string]bool, len(user.PlotIds))
+	for _, plotIdStr := range user.PlotIds {
+		owned[plotIdStr] = true
+	}
+
+	return owned, nil
+
+}
+
+// writes the current owner's metadata, released plots are left to their cleanup
+func restorePlotOwnerMetadata(mongoDB *mongo.Database, redisCli *redis.Client, r2Cli *s3.Client, ctx context.Context, plotIdStr string) error {
+
+	var owner schemas.User
+	err := mongoDB.Collection("users").FindOne(ctx, bson.M{"plotIds": plotIdStr}).Decode(&owner)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	plotId, err := PlotIdFromHexString(plotIdStr)
+	if err != nil {
+		return err
+	}
+
+	return UpdatePlotMetadata(redisCli, r2Cli, ctx, plotId, PlotMetadata(&owner))
+
+}
+
+func finishOwnerSyncJob(jobsColl *mongo.Collection, ctx context.Context, job *schemas.OwnerSyncJob, jobErr error) error {
+
+	update := bson.M{
+		"status":     OWNER_SYNC_DONE,
+		"mtime":      time.Now().UTC(),
+		"leaseUntil": time.Time{},
+	}
+	if jobErr != nil {
+		update["status"] = OWNER_SYNC_FAILED
+		update["error"] = jobErr.Error()
+	}
+
+	// if the job was requeued in the meantime leave it pending
+	if _, err := jobsColl.UpdateOne(ctx, bson.M{"_id": job.Id, "gen": job.Gen}, bson.M{"$set": update}); err != nil {
+		return err
+	}
+
+	return jobErr
+
+}
@@ -48,121 +48,136 @@ func MaxGraphemesValidator(fl validator.FieldLevel) bool {
 	return true
 }
 
-func BuildDataValidator(fl validator.FieldLevel) bool {
+// incrementally validates run-length encoded build data, one value at a time
+type buildValidator struct {
+	n            int
+	bs3          int
+	blkCnt       int
+	subplotsUsed [config.SUBPLOT_COUNT]bool
+	afterSubplot bool
+}
 
-	data, ok := fl.Field().Interface().([]uint16)
-	if !ok {
-		return false
-	}
+func (v *buildValidator) next(value uint16) bool {
 
-	// must contain at least 2 values (version, build size)
-	if len(data) < 2 {
-		return false
+	v.n++
+
+	// first two values are version and build size
+	if v.n == 1 {
+		return value == config.BUILD_DATA_VERSION
+	}
+	if v.n == 2 {
+		bs := int(value)
+		v.bs3 = bs * bs * bs
+		return true
 	}
 
-	_, bs := data[0], int(data[1])
-	bs3 := bs * bs * bs
+	write, val := value&1, value>>1
 
-	// create array to track subplots
-	var subplotsUsed [config.SUBPLOT_COUNT]bool
-	for i := range subplotsUsed {
-		subplotsUsed[i] = false
+	// value after a subplot must not be a repeat type
+	if v.afterSubplot && write == 0 {
+		return false
 	}
-
-	data = data[2:]
-	blkCnt := 0
+	v.afterSubplot = false
 
 	// check that each block is valid, count blocks
-	for i := range data {
-		write, val := data[i]&1, data[i]>>1
-		if write == 1 {
-			if val > config.MAX_COLOR_IDX {
+	if write == 1 {
+		if val > config.MAX_COLOR_IDX {
+			return false
+		}
+		//if subplot
+		if val > 0 && val <= config.SUBPLOT_COUNT {
+			// if subplot is already placed, it can't be placed again
+			if v.subplotsUsed[val-1] {
 				return false
 			}
-			//if subplot
-			if val > 0 && val <= config.SUBPLOT_COUNT {
-				// if subplot is already placed, it can't be placed again
-				if subplotsUsed[val-1] {
-					return false
-				}
-
-				// next value must not be a repeat type
-				if i+1 < len(data) && data[i+1]&1 == 0 {
-					return false
-				}
-
-				subplotsUsed[val-1] = true
-			}
-			blkCnt++
-		} else {
-			blkCnt += int(val)
-		}
-		// terminate if block count exceeds bs^3
-		if blkCnt > bs3 {
-			return false
+			v.subplotsUsed[val-1] = true
+			v.afterSubplot = true
 		}
+		v.blkCnt++
+	} else {
+		v.blkCnt += int(val)
 	}
 
-	return true
+	// terminate if block count exceeds bs^3
+	return v.blkCnt <= v.bs3
+
 }
 
-func Decode(data []byte) (*PlotData, error) {
+// must contain at least 2 values (version, build size)
+func (v *buildValidator) done() bool {
+	return v.n >= 2
+}
 
-	var parts [][]byte
-	buf := data
+func BuildDataValidator(fl validator.FieldLevel) bool {
 
-	for len(buf) > 0 {
+	data, ok := fl.Field().Interface().([]uint16)
+	if !ok {
+		return false
+	}
 
-		//first 4 bytes give the length of the data
-		if len(data) < 4 {
-			return nil, fmt.Errorf("in splitPlotDataParts: invalid prefix")
+	var v buildValidator
+	for _, value := range data {
+		if !v.next(value) {
+			return false
 		}
+	}
 
-		// read length (little-endian)
-		partLen := binary.LittleEndian.Uint32(buf[:4])
-		buf = buf[4:]
-
-		if uint32(len(buf)) < partLen {
-			return nil, fmt.Errorf("in splitPlotDataParts: invalid part")
-		}
+	return v.done()
+}
 
-		chunk := buf[:partLen]
-		parts = append(parts, chunk)
-		buf = buf[partLen:]
+// version of encoded plot data, read from its json part
+func DataVersion(data []byte) (int, error) {
 
+	if len(data) < 4 {
+		return 0, fmt.Errorf("in DataVersion: invalid prefix")
 	}
-
-	if len(parts) != 2 {
-		return nil, fmt.Errorf("invalid plot data")
+	jsonLen := binary.LittleEndian.Uint32(data[:4])
+	if uint32(len(data)-4) < jsonLen {
+		return 0, fmt.Errorf("in DataVersion: invalid part")
 	}
 
 	var jsonData plotDataJsonPart
-	err := json.Unmarshal(parts[0], &jsonData)
-	if err != nil {
-		return nil, fmt.Errorf("error decoding json part")
+	if err := json.Unmarshal(data[4:4+jsonLen], &jsonData); err != nil {
+		return 0, fmt.Errorf("error decoding json part")
 	}
 
-	buildData, err := utils.BytesToUint16Arr(parts[1])
-	if err != nil {
-		return nil, fmt.Errorf("error decoding build data")
-	}
+	return jsonData.Version, nil
 
-	plotData := PlotData{
-		Name:        jsonData.Name,
-		Description: jsonData.Description,
-		Link:        jsonData.Link,
-		LinkTitle:   jsonData.LinkTitle,
-		BuildData:   buildData,
-	}
+}
 
-	return &plotData, nil
+// encodes plot data in the current storage version, for private objects like plot versions
+func (plotData *PlotData) Encode() ([]byte, error) {
+	return plotData.EncodeVersion(config.PLOT_DATA_VERSION)
+}
 
+// encodes plot data in the version served from the CDN
+func (plotData *PlotData) EncodePublic() ([]byte, error) {
+	return plotData.EncodeVersion(config.PLOT_PUBLIC_VERSION)
 }
 
-func (plotData *PlotData) Encode() ([]byte, error) {
+// v1 falls back to v0 when it doesn't compress the build, high entropy builds are smaller in v0
+func (plotData *PlotData) EncodeVersion(version int) ([]byte, error) {
+
+	var buildData []byte
+	var err error
+	switch version {
+	case 0:
+		buildData = utils.Uint16ArrToBytes(plotData.BuildData)
+	case 1:
+		buildData, err = encodeBuildDataV1(plotData.BuildData)
+		if err != nil {
+			return nil, fmt.Errorf("in plotData.Encode:\n%w", err)
+		}
+		if len(buildData) >= 2*len(plotData.BuildData) {
+			version = 0
+			buildData = utils.Uint16ArrToBytes(plotData.BuildData)
+		}
+	default:
+		return nil, fmt.Errorf("in plotData.Encode: unknown version %d", version)
+	}
 
 	jsonData := plotDataJsonPart{
-		Version:     0,
+		Version:     version,
 		Name:        plotData.Name,
 		Description: plotData.Description,
 		Link:        plotData.Link,
@@ -175,7 +190,6 @@ func (plotData *PlotData) Encode() ([]byte, error) {
 		return nil, fmt.Errorf("in plotData.Encode:\n%w", err)
 	}
 
-	buildData := utils.Uint16ArrToBytes(plotData.BuildData)
 	jsonLen := len(json)
 	buildLen := len(buildData)
 	length := jsonLen + buildLen + 8
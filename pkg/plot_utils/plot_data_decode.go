@@ -0,0 +1,175 @@
+package plotutils
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"trraformapi/pkg/config"
+)
+
+var (
+	ErrBadPrefix        = errors.New("plot data: bad length prefix")
+	ErrTruncatedPart    = errors.New("plot data: truncated part")
+	ErrOversizeJson     = errors.New("plot data: json part too large")
+	ErrInvalidJson      = errors.New("plot data: invalid json part")
+	ErrUnknownVersion   = errors.New("plot data: unknown version")
+	ErrOddBuildLength   = errors.New("plot data: odd build data length")
+	ErrOversizeBuild    = errors.New("plot data: build data too large")
+	ErrInvalidBuildData = errors.New("plot data: invalid build data")
+	ErrTrailingData     = errors.New("plot data: trailing data after build part")
+)
+
+// decodes plot data in the Encode framing, build data must be no larger than LRG_BUILD_SIZE
+func Decode(data []byte) (*PlotData, error) {
+	return DecodeReader(bytes.NewReader(data), config.LRG_BUILD_SIZE)
+}
+
+// decodes plot data from r, validating build data as it streams in. every allocation is bounded
+// by maxBuildSize and PLOT_JSON_MAX_SIZE, and at most one byte is read past the build part,
+// so r doesn't need to be bounded by the caller
+func DecodeReader(r io.Reader, maxBuildSize int) (*PlotData, error) {
+
+	br := bufio.NewReader(r)
+
+	// json part
+	jsonLen, err := readPrefix(br)
+	if err != nil {
+		return nil, err
+	}
+	if jsonLen > config.PLOT_JSON_MAX_SIZE {
+		return nil, fmt.Errorf("%w: %d bytes", ErrOversizeJson, jsonLen)
+	}
+	jsonBytes := make([]byte, jsonLen)
+	if _, err := io.ReadFull(br, jsonBytes); err != nil {
+		return nil, truncated(err)
+	}
+	var jsonData plotDataJsonPart
+	if err := json.Unmarshal(jsonBytes, &jsonData); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidJson, err)
+	}
+
+	// build part
+	buildLen, err := readPrefix(br)
+	if err != nil {
+		return nil, err
+	}
+
+	var buildData []uint16
+	switch jsonData.Version {
+	case 0:
+		buildData, err = decodeBuildDataV0(br, buildLen, maxBuildSize)
+	case 1:
+		buildData, err = readBuildDataV1(br, buildLen, maxBuildSize)
+	default:
+		err = fmt.Errorf("%w: %d", ErrUnknownVersion, jsonData.Version)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// nothing may follow the build part
+	if _, err := br.ReadByte(); !errors.Is(err, io.EOF) {
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrTrailingData
+	}
+
+	plotData := PlotData{
+		Name:        jsonData.Name,
+		Description: jsonData.Description,
+		Link:        jsonData.Link,
+		LinkTitle:   jsonData.LinkTitle,
+		BuildData:   buildData,
+	}
+
+	return &plotData, nil
+
+}
+
+func readPrefix(br *bufio.Reader) (uint32, error) {
+
+	var prefix [4]byte
+	if _, err := io.ReadFull(br, prefix[:]); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return 0, ErrBadPrefix
+		}
+		return 0, err
+	}
+
+	return binary.LittleEndian.Uint32(prefix[:]), nil
+
+}
+
+// maps a short read to ErrTruncatedPart, other reader errors (e.g. size limits) pass through
+func truncated(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return ErrTruncatedPart
+	}
+	return err
+}
+
+// reads uint16 build data one value at a time, rejecting it as soon as it becomes invalid
+func decodeBuildDataV0(br *bufio.Reader, buildLen uint32, maxBuildSize int) ([]uint16, error) {
+
+	if buildLen%2 != 0 {
+		return nil, ErrOddBuildLength
+	}
+	maxBuildLen := 2 + maxBuildSize*maxBuildSize*maxBuildSize
+	if buildLen/2 > uint32(maxBuildLen) {
+		return nil, fmt.Errorf("%w: %d values", ErrOversizeBuild, buildLen/2)
+	}
+
+	buildData := make([]uint16, buildLen/2)
+	var v buildValidator
+	var word [2]byte
+	for i := range buildData {
+		if _, err := io.ReadFull(br, word[:]); err != nil {
+			return nil, truncated(err)
+		}
+		buildData[i] = binary.LittleEndian.Uint16(word[:])
+		if i == 1 && int(buildData[1]) > maxBuildSize {
+			return nil, fmt.Errorf("%w: build size %d", ErrOversizeBuild, buildData[1])
+		}
+		if !v.next(buildData[i]) {
+			return nil, fmt.Errorf("%w: at value %d", ErrInvalidBuildData, i)
+		}
+	}
+	if !v.done() {
+		return nil, fmt.Errorf("%w: missing header", ErrInvalidBuildData)
+	}
+
+	return buildData, nil
+
+}
+
+// v1 build data is compressed, so the whole part is read before it's validated
+func readBuildDataV1(br *bufio.Reader, buildLen uint32, maxBuildSize int) ([]uint16, error) {
+
+	if buildLen > 2*config.PLOT_BUILD_MAX_LEN {
+		return nil, fmt.Errorf("%w: %d bytes", ErrOversizeBuild, buildLen)
+	}
+	buildBytes := make([]byte, buildLen)
+	if _, err := io.ReadFull(br, buildBytes); err != nil {
+		return nil, truncated(err)
+	}
+
+	buildData, err := decodeBuildDataV1(buildBytes, maxBuildSize)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidBuildData, err)
+	}
+
+	var v buildValidator
+	for i := range buildData {
+		if !v.next(buildData[i]) {
+			return nil, fmt.Errorf("%w: at value %d", ErrInvalidBuildData, i)
+		}
+	}
+
+	return buildData, nil
+
+}
@@ -0,0 +1,70 @@
+package plotutils
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+	"testing/iotest"
+	"trraformapi/pkg/config"
+)
+
+// Decode and a byte at a time DecodeReader must agree on every input, and anything
+// that decodes must survive a round trip through each encoding version
+func FuzzDecode(f *testing.F) {
+
+	f.Add([]byte{})
+	for _, ver := range []int{0, 1} {
+		data, err := (&PlotData{Name: "seed", BuildData: []uint16{0, 6, 3, 20}}).EncodeVersion(ver)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+
+		plotData, err := Decode(data)
+		streamed, streamErr := DecodeReader(iotest.OneByteReader(bytes.NewReader(data)), config.LRG_BUILD_SIZE)
+		if (err == nil) != (streamErr == nil) || (err != nil && err.Error() != streamErr.Error()) {
+			t.Fatalf("Decode err %v, DecodeReader err %v", err, streamErr)
+		}
+		if err != nil {
+			return
+		}
+		if !plotDataEqual(plotData, streamed) {
+			t.Fatalf("Decode and DecodeReader differ")
+		}
+
+		for ver := 0; ver <= config.PLOT_DATA_VERSION; ver++ {
+			encoded, err := plotData.EncodeVersion(ver)
+			if err != nil {
+				t.Fatalf("v%d encode: %v", ver, err)
+			}
+			decoded, err := Decode(encoded)
+			if err != nil {
+				t.Fatalf("v%d decode: %v", ver, err)
+			}
+			if !plotDataEqual(plotData, decoded) {
+				t.Fatalf("v%d round trip differs", ver)
+			}
+		}
+
+	})
+
+}
+
+// equal json parts and equal voxels, v1 may merge runs differently than the original
+func plotDataEqual(a *PlotData, b *PlotData) bool {
+
+	if a.Name != b.Name || a.Description != b.Description || a.Link != b.Link || a.LinkTitle != b.LinkTitle {
+		return false
+	}
+	if a.BuildData[0] != b.BuildData[0] || a.BuildData[1] != b.BuildData[1] {
+		return false
+	}
+	va, errA := ExpandBuildData(a.BuildData)
+	vb, errB := ExpandBuildData(b.BuildData)
+
+	return errA == nil && errB == nil && slices.Equal(va, vb)
+
+}
@@ -0,0 +1,102 @@
+package plotutils
+
+import (
+	"math/rand"
+	"testing"
+	"trraformapi/pkg/config"
+)
+
+func testBuild(bs int, fill func(i int) uint16) []uint16 {
+	voxels := make([]uint16, bs*bs*bs)
+	for i := range voxels {
+		voxels[i] = fill(i)
+	}
+	return CompressBuildData(0, bs, voxels)
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+
+	rng := rand.New(rand.NewSource(1))
+	tests := []struct {
+		name      string
+		buildData []uint16
+		v1Version int // version EncodeVersion(1) is expected to write
+	}{
+		// a zstd frame is larger than a header only v0 build
+		{"empty", []uint16{0, config.MIN_BUILD_SIZE}, 0},
+		{"markers", testBuild(config.STD_BUILD_SIZE, func(i int) uint16 {
+			if i < config.SUBPLOT_COUNT {
+				return uint16(i + 1)
+			}
+			return uint16(100 + i%3)
+		}), 1},
+		{"solid large", testBuild(config.LRG_BUILD_SIZE, func(int) uint16 { return 500 }), 1},
+		// high entropy doesn't compress, v1 falls back to v0
+		{"random large", testBuild(config.LRG_BUILD_SIZE, func(int) uint16 {
+			return uint16(config.SUBPLOT_COUNT + 1 + rng.Intn(config.MAX_COLOR_IDX-config.SUBPLOT_COUNT))
+		}), 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plotData := &PlotData{Name: "name", Description: "desc", Link: "https://trraform.com", LinkTitle: "link", BuildData: tt.buildData}
+			for ver, wantVer := range []int{0, tt.v1Version} {
+				data, err := plotData.EncodeVersion(ver)
+				if err != nil {
+					t.Fatalf("v%d encode: %v", ver, err)
+				}
+				if gotVer, err := DataVersion(data); err != nil || gotVer != wantVer {
+					t.Fatalf("v%d encode wrote version %d (%v), want %d", ver, gotVer, err, wantVer)
+				}
+				if len(data) > config.PLOT_UPLOAD_MAX_SIZE {
+					t.Fatalf("v%d encode is %d bytes, over the upload limit", ver, len(data))
+				}
+				decoded, err := Decode(data)
+				if err != nil {
+					t.Fatalf("v%d decode: %v", ver, err)
+				}
+				if !plotDataEqual(plotData, decoded) {
+					t.Fatalf("v%d round trip differs", ver)
+				}
+			}
+		})
+	}
+
+}
+
+func TestEncodePublicIsV0(t *testing.T) {
+
+	plotData := &PlotData{BuildData: testBuild(config.STD_BUILD_SIZE, func(int) uint16 { return 40 })}
+	data, err := plotData.EncodePublic()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// clients and the chunk builder only read v0
+	if ver, _ := DataVersion(data); ver != 0 {
+		t.Fatalf("public encode wrote version %d", ver)
+	}
+
+}
+
+func TestBuildDataVersion(t *testing.T) {
+
+	build := testBuild(config.MIN_BUILD_SIZE, func(i int) uint16 { return 100 })
+	valid := func(buildData []uint16) bool {
+		var v buildValidator
+		for _, value := range buildData {
+			if !v.next(value) {
+				return false
+			}
+		}
+		return v.done()
+	}
+
+	if !valid(build) {
+		t.Fatal("current build version rejected")
+	}
+	build[0] = config.BUILD_DATA_VERSION + 1
+	if valid(build) {
+		t.Fatal("unknown build version accepted")
+	}
+
+}
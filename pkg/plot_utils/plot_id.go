@@ -2,12 +2,11 @@ package plotutils
 
 import (
 	"context"
-	"encoding/binary"
 	"fmt"
-	"log"
-	"os"
 	"strconv"
+	"strings"
 	"trraformapi/pkg/config"
+	"trraformapi/pkg/geometry"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/redis/go-redis/v9"
@@ -17,27 +16,6 @@ type PlotId struct {
 	Id uint64
 }
 
-var chunkMap map[uint64]uint32
-
-func init() {
-
-	chunkMapBytes, err := os.ReadFile("static/chunk_map.dat")
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	chunkMap = make(map[uint64]uint32)
-
-	for i := 0; i < len(chunkMapBytes); i += 8 {
-
-		chunkId := binary.LittleEndian.Uint32(chunkMapBytes[i : i+4])
-		plotId := uint64(i / 8)
-		chunkMap[plotId] = chunkId
-
-	}
-
-}
-
 func PlotIdValidator(fl validator.FieldLevel) bool {
 
 	plotId, err := PlotIdFromHexString(fl.Field().String())
@@ -171,7 +149,8 @@ func (plotId *PlotId) GetChunkId() string {
 	depth := plotId.Depth()
 
 	if depth == 0 {
-		return fmt.Sprintf("0_%x", chunkMap[plotId.Id-1])
+		chunk, _ := geometry.Chunk(plotId.Id)
+		return fmt.Sprintf("0_%x", chunk)
 	}
 
 	parentId := plotId.GetParent()
@@ -181,3 +160,35 @@ func (plotId *PlotId) GetChunkId() string {
 	return fmt.Sprintf("%s_%x", parentId.ToString(), chunkId)
 
 }
+
+// plots in a chunk, chunk id in the GetChunkId format
+func ChunkPlotIds(chunkId string) ([]*PlotId, error) {
+
+	parentStr, chunkStr, ok := strings.Cut(chunkId, "_")
+	if !ok {
+		return nil, fmt.Errorf("in ChunkPlotIds: invalid chunk id %q", chunkId)
+	}
+	chunk, err := strconv.ParseUint(chunkStr, 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("in ChunkPlotIds:\n%w", err)
+	}
+
+	var plotIds []*PlotId
+	if parentStr == "0" {
+		for _, id := range geometry.ChunkPlots(uint32(chunk)) {
+			plotIds = append(plotIds, &PlotId{Id: id})
+		}
+		return plotIds, nil
+	}
+
+	parentId, err := PlotIdFromHexString(parentStr)
+	if err != nil || !parentId.Validate() || parentId.Depth() >= config.MAX_DEPTH {
+		return nil, fmt.Errorf("in ChunkPlotIds: invalid chunk id %q", chunkId)
+	}
+	for local := chunk*config.CHUNK_SIZE + 1; local <= min((chunk+1)*config.CHUNK_SIZE, config.SUBPLOT_COUNT); local++ {
+		plotIds = append(plotIds, CreateSubplotId(parentId, local))
+	}
+
+	return plotIds, nil
+
+}
@@ -0,0 +1,55 @@
+package plotutils
+
+import (
+	"context"
+	"strconv"
+	"trraformapi/pkg/config"
+	"trraformapi/pkg/schemas"
+	"trraformapi/pkg/utils"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/redis/go-redis/v9"
+)
+
+// object metadata read by the chunk worker and client
+func PlotMetadata(user *schemas.User) map[string]string {
+
+	owner := user.Username
+	if user.Privacy.AnonymousOwner {
+		owner = ""
+	}
+
+	return map[string]string{
+		"owner":    owner,
+		"verified": strconv.FormatBool(user.Subscription.IsActive),
+	}
+
+}
+
+func UpdatePlotMetadata(redisCli *redis.Client, r2Cli *s3.Client, ctx context.Context, plotId *PlotId, metadata map[string]string) error {
+
+	if err := utils.UpdateMetadataR2(r2Cli, ctx, config.CF_PLOT_BUCKET, plotId.ToString()+".dat", "application/octet-stream", metadata); err != nil {
+		return err
+	}
+
+	return FlagPlotForUpdate(redisCli, ctx, plotId, true)
+
+}
+
+func UpdatePlotsMetadata(redisCli *redis.Client, r2Cli *s3.Client, ctx context.Context, user *schemas.User) error {
+
+	metadata := PlotMetadata(user)
+
+	for _, plotIdStr := range user.PlotIds {
+		plotId, err := PlotIdFromHexString(plotIdStr)
+		if err != nil {
+			return err
+		}
+		if err := UpdatePlotMetadata(redisCli, r2Cli, ctx, plotId, metadata); err != nil {
+			return err
+		}
+	}
+
+	return nil
+
+}
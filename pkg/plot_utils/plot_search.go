@@ -0,0 +1,82 @@
+package plotutils
+
+import (
+	"context"
+	"trraformapi/pkg/schemas"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const PlotSearchIndex = "plotSearch"
+
+// text index over the plot fields mirrored from R2, names rank highest
+func EnsurePlotSearchIndex(mongoDB *mongo.Database, ctx context.Context) error {
+
+	_, err := mongoDB.Collection("plots").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "name", Value: "text"},
+			{Key: "linkTitle", Value: "text"},
+			{Key: "ownerName", Value: "text"},
+			{Key: "description", Value: "text"},
+		},
+		Options: options.Index().SetName(PlotSearchIndex).SetDefaultLanguage("none").SetWeights(bson.D{
+			{Key: "name", Value: 10},
+			{Key: "linkTitle", Value: 4},
+			{Key: "ownerName", Value: 4},
+			{Key: "description", Value: 1},
+		}),
+	})
+
+	return err
+
+}
+
+// plot search fields that come from the owner
+func PlotOwnerSearchFields(user *schemas.User) bson.M {
+
+	return bson.M{
+		"ownerName": PlotMetadata(user)["owner"],
+		"verified":  user.Subscription.IsActive,
+		"hidden":    user.Privacy.HidePlots,
+	}
+
+}
+
+// plot search fields that come from the plot data
+func PlotDataSearchFields(plotId *PlotId, plotData *PlotData) bson.M {
+
+	return bson.M{
+		"name":        plotData.Name,
+		"description": plotData.Description,
+		"linkTitle":   plotData.LinkTitle,
+		"depth":       plotId.Depth(),
+	}
+
+}
+
+// rewrite the owner search fields on all of a user's plots
+func UpdatePlotsSearchFields(mongoDB *mongo.Database, ctx context.Context, user *schemas.User) error {
+
+	ids := make([]uint64, 0, len(user.PlotIds))
+	for _, plotIdStr := range user.PlotIds {
+		plotId, err := PlotIdFromHexString(plotIdStr)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, plotId.Id)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	// conditional on the owner, a plot transferred since user was read keeps its new owner's fields
+	_, err := mongoDB.Collection("plots").UpdateMany(ctx,
+		bson.M{"plotId": bson.M{"$in": ids}, "owner": user.Id},
+		bson.M{"$set": PlotOwnerSearchFields(user)},
+	)
+
+	return err
+
+}
@@ -0,0 +1,92 @@
+package plotutils
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"trraformapi/pkg/config"
+	"trraformapi/pkg/schemas"
+	"trraformapi/pkg/utils"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// deletes everything stored for a released plot outside mongo, flags its chunk for a rebuild and
+// puts it back in the availability index, then marks the release cleaned. runs after the release
+// commits, reconcile reruns it for releases left uncleaned. if the plot was claimed again since,
+// only the versions saved before the release are deleted
+func CleanReleasedPlot(mongoDB *mongo.Database, redisCli *redis.Client, r2Cli *s3.Client, httpCli *http.Client, ctx context.Context, release *schemas.PlotRelease) error {
+
+	plotId, err := PlotIdFromHexString(release.PlotId)
+	if err != nil {
+		return err
+	}
+
+	err = mongoDB.Collection("plots").FindOne(ctx,
+		bson.M{"plotId": plotId.Id},
+		options.FindOne().SetProjection(bson.M{"_id": 1}),
+	).Err()
+	if err == nil {
+		err = pruneVersions(mongoDB, r2Cli, ctx, bson.M{"plotId": release.PlotId, "ctime": bson.M{"$lte": release.Ctime}}, 0)
+	} else if errors.Is(err, mongo.ErrNoDocuments) {
+		err = clearPlotData(mongoDB, redisCli, r2Cli, httpCli, ctx, plotId)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = mongoDB.Collection("plotReleases").UpdateOne(ctx,
+		bson.M{"_id": release.Id},
+		bson.M{"$set": bson.M{"cleaned": true}},
+	)
+
+	return err
+
+}
+
+func clearPlotData(mongoDB *mongo.Database, redisCli *redis.Client, r2Cli *s3.Client, httpCli *http.Client, ctx context.Context, plotId *PlotId) error {
+
+	plotIdStr := plotId.ToString()
+
+	if err := utils.DeleteObjectR2(r2Cli, ctx, config.CF_PLOT_BUCKET, plotIdStr+".dat"); err != nil {
+		return err
+	}
+	if err := DeleteThumbnail(redisCli, r2Cli, ctx, plotId); err != nil {
+		return err
+	}
+	if err := DeleteLODs(redisCli, r2Cli, ctx, plotId); err != nil {
+		return err
+	}
+	if err := PruneVersions(mongoDB, r2Cli, ctx, plotIdStr, 0); err != nil {
+		return err
+	}
+	if err := utils.PurgeCacheCDN(httpCli, ctx, []string{
+		config.PLOT_CDN_URL + "/" + plotIdStr + ".dat",
+		config.PLOT_CDN_URL + "/" + plotIdStr + ".png",
+	}); err != nil {
+		return err
+	}
+	if err := FlagPlotForUpdate(redisCli, ctx, plotId, false); err != nil {
+		return err
+	}
+
+	// back into the availability index
+	parentId := plotId.GetParent()
+	if parentId == nil {
+		return MarkPlotsOpen(redisCli, ctx, []*PlotId{plotId})
+	}
+	parents, err := PlotsWithSubplots(mongoDB, ctx, []uint64{parentId.Id})
+	if err != nil {
+		return err
+	}
+	if parent, ok := parents[parentId.Id]; ok {
+		return SyncSubplotAvailability(mongoDB, redisCli, ctx, parentId, parent.Subplots)
+	}
+
+	return nil
+
+}
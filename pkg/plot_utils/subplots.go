@@ -0,0 +1,209 @@
+package plotutils
+
+import (
+	"context"
+	"slices"
+	"trraformapi/pkg/config"
+	"trraformapi/pkg/schemas"
+
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// reasons a subplot can't be claimed, returned as conflicts
+const (
+	SUBPLOT_PARENT_UNCLAIMED = "parentUnclaimed"
+	SUBPLOT_NO_SLOT          = "noSlot"
+	SUBPLOT_RESERVED         = "reserved"
+	SUBPLOT_NOT_APPROVED     = "notApproved"
+	SUBPLOT_CHECKOUT_ONLY    = "checkoutOnly" // parent owner set a price, can't be claimed with credit
+	SUBPLOT_NO_PAYOUT        = "noPayout"     // parent owner set a price but has no connect account to be paid to
+)
+
+// subplot slots placed in a build, ascending
+func SubplotMarkers(buildData []uint16) []int {
+
+	var placed [config.SUBPLOT_COUNT + 1]bool
+	for _, v := range buildData[2:] {
+		// markers are writes, repeats can't follow them
+		if v&1 == 1 && v>>1 >= 1 && v>>1 <= config.SUBPLOT_COUNT {
+			placed[v>>1] = true
+		}
+	}
+
+	markers := []int{}
+	for i := 1; i <= config.SUBPLOT_COUNT; i++ {
+		if placed[i] {
+			markers = append(markers, i)
+		}
+	}
+
+	return markers
+
+}
+
+// plot docs by id. plots saved before slots were tracked have nil subplots and no slots
+// until search_backfill records them
+func PlotsWithSubplots(mongoDB *mongo.Database, ctx context.Context, plotIds []uint64) (map[uint64]*schemas.Plot, error) {
+
+	cursor, err := mongoDB.Collection("plots").Find(ctx, bson.M{"plotId": bson.M{"$in": plotIds}})
+	if err != nil {
+		return nil, err
+	}
+	var plots []schemas.Plot
+	if err := cursor.All(ctx, &plots); err != nil {
+		return nil, err
+	}
+
+	byId := make(map[uint64]*schemas.Plot, len(plots))
+	for i := range plots {
+		byId[plots[i].PlotId] = &plots[i]
+	}
+
+	return byId, nil
+
+}
+
+// a subplot the user is allowed to claim
+type SubplotClaim struct {
+	PlotId      *PlotId
+	ParentOwner bson.ObjectID
+	Price       int64 // cents set by the parent owner, 0 for the depth price
+}
+
+// checks subplots against their parent's build and settings. depth 0 plots are skipped.
+// returns the allowed subplot claims and a conflict reason for each plot that isn't allowed
+func CheckSubplotClaims(mongoDB *mongo.Database, ctx context.Context, uid bson.ObjectID, plotIds []*PlotId) (map[uint64]*SubplotClaim, map[string]string, error) {
+
+	var parentIds []uint64
+	for _, plotId := range plotIds {
+		if parent := plotId.GetParent(); parent != nil {
+			parentIds = append(parentIds, parent.Id)
+		}
+	}
+	claims := make(map[uint64]*SubplotClaim)
+	conflicts := make(map[string]string)
+	if len(parentIds) == 0 {
+		return claims, conflicts, nil
+	}
+
+	parents, err := PlotsWithSubplots(mongoDB, ctx, parentIds)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// owners by parent plot, the users collection is the source of truth for ownership
+	parentIdStrs := make([]string, 0, len(parents))
+	for id := range parents {
+		parentIdStrs = append(parentIdStrs, (&PlotId{Id: id}).ToString())
+	}
+	cursor, err := mongoDB.Collection("users").Find(ctx,
+		bson.M{"plotIds": bson.M{"$in": parentIdStrs}},
+		options.Find().SetProjection(bson.M{"plotIds": 1, "stripeConnect": 1}),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	var owners []schemas.User
+	if err := cursor.All(ctx, &owners); err != nil {
+		return nil, nil, err
+	}
+	ownerOf := make(map[string]bson.ObjectID)
+	canBePaid := make(map[bson.ObjectID]bool, len(owners))
+	for _, owner := range owners {
+		for _, id := range owner.PlotIds {
+			ownerOf[id] = owner.Id
+		}
+		canBePaid[owner.Id] = owner.StripeConnect != ""
+	}
+
+	for _, plotId := range plotIds {
+		parentId := plotId.GetParent()
+		if parentId == nil {
+			continue
+		}
+		plotIdStr := plotId.ToString()
+		parent, ok := parents[parentId.Id]
+		parentOwner, owned := ownerOf[parentId.ToString()]
+		if !ok || !owned {
+			conflicts[plotIdStr] = SUBPLOT_PARENT_UNCLAIMED
+			continue
+		}
+
+		split := plotId.Split()
+		local := int(split[len(split)-1])
+		settings := &parent.SubplotSettings
+		switch {
+		case !slices.Contains(parent.Subplots, local):
+			conflicts[plotIdStr] = SUBPLOT_NO_SLOT
+		case uid == parentOwner:
+			// parent owner can always claim slots in their own build, at the depth price
+			claims[plotId.Id] = &SubplotClaim{PlotId: plotId, ParentOwner: parentOwner}
+		case slices.Contains(settings.Reserved, local):
+			conflicts[plotIdStr] = SUBPLOT_RESERVED
+		case settings.RequireApproval && !slices.Contains(settings.Approved, uid):
+			conflicts[plotIdStr] = SUBPLOT_NOT_APPROVED
+		case settings.Price > 0 && !canBePaid[parentOwner]:
+			conflicts[plotIdStr] = SUBPLOT_NO_PAYOUT
+		default:
+			claims[plotId.Id] = &SubplotClaim{PlotId: plotId, ParentOwner: parentOwner, Price: settings.Price}
+		}
+	}
+
+	return claims, conflicts, nil
+
+}
+
+// puts the unclaimed subplots placed in a parent's build in the availability index
+// and takes the rest out
+func SyncSubplotAvailability(mongoDB *mongo.Database, redisCli *redis.Client, ctx context.Context, parentId *PlotId, markers []int) error {
+
+	if parentId.Depth() >= config.MAX_DEPTH {
+		return nil
+	}
+
+	ids := make([]uint64, config.SUBPLOT_COUNT)
+	for i := range ids {
+		ids[i] = CreateSubplotId(parentId, uint64(i+1)).Id
+	}
+	cursor, err := mongoDB.Collection("plots").Find(ctx,
+		bson.M{"plotId": bson.M{"$in": ids}},
+		options.Find().SetProjection(bson.M{"plotId": 1}),
+	)
+	if err != nil {
+		return err
+	}
+	var claimed []schemas.Plot
+	if err := cursor.All(ctx, &claimed); err != nil {
+		return err
+	}
+	isClaimed := make(map[uint64]bool, len(claimed))
+	for _, plot := range claimed {
+		isClaimed[plot.PlotId] = true
+	}
+
+	var open, closed []any
+	for i, id := range ids {
+		idStr := (&PlotId{Id: id}).ToString()
+		if !isClaimed[id] && slices.Contains(markers, i+1) {
+			open = append(open, idStr)
+		} else {
+			closed = append(closed, idStr)
+		}
+	}
+
+	key := AvailabilityKey(parentId.Depth() + 1)
+	pipe := redisCli.TxPipeline()
+	if len(open) > 0 {
+		pipe.SAdd(ctx, key, open...)
+	}
+	if len(closed) > 0 {
+		pipe.SRem(ctx, key, closed...)
+	}
+	_, err = pipe.Exec(ctx)
+
+	return err
+
+}
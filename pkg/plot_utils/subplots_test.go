@@ -0,0 +1,56 @@
+package plotutils
+
+import (
+	"slices"
+	"testing"
+	"trraformapi/pkg/config"
+)
+
+func TestSubplotMarkers(t *testing.T) {
+
+	bs := uint16(config.MIN_BUILD_SIZE)
+	tests := []struct {
+		name      string
+		buildData []uint16
+		want      []int
+	}{
+		{"empty", []uint16{0, bs}, []int{}},
+		{"no markers", []uint16{0, bs, 100<<1 | 1, 5 << 1}, []int{}},
+		{"unordered and repeated", []uint16{0, bs, 3<<1 | 1, 1<<1 | 1, 3<<1 | 1, 100<<1 | 1}, []int{1, 3}},
+		{"highest slot", []uint16{0, bs, config.SUBPLOT_COUNT<<1 | 1}, []int{config.SUBPLOT_COUNT}},
+		{"past the last slot", []uint16{0, bs, (config.SUBPLOT_COUNT+1)<<1 | 1}, []int{}},
+		// repeat counts aren't colors
+		{"repeat count in marker range", []uint16{0, bs, 100<<1 | 1, 2 << 1}, []int{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SubplotMarkers(tt.buildData); !slices.Equal(got, tt.want) {
+				t.Fatalf("SubplotMarkers = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+}
+
+// markers survive a compress and expand round trip
+func TestSubplotMarkersCompressed(t *testing.T) {
+
+	bs := config.MIN_BUILD_SIZE
+	voxels := make([]uint16, bs*bs*bs)
+	voxels[0], voxels[1], voxels[2] = 7, 7, 7
+	voxels[10] = 2
+
+	buildData := CompressBuildData(0, bs, voxels)
+	if got := SubplotMarkers(buildData); !slices.Equal(got, []int{2, 7}) {
+		t.Fatalf("SubplotMarkers = %v, want [2 7]", got)
+	}
+	expanded, err := ExpandBuildData(buildData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(expanded, voxels) {
+		t.Fatal("ExpandBuildData doesn't match the compressed voxels")
+	}
+
+}
@@ -0,0 +1,48 @@
+package plotutils
+
+import (
+	"bytes"
+	"context"
+	"trraformapi/pkg/config"
+	"trraformapi/pkg/utils"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/redis/go-redis/v9"
+)
+
+// uploads a plot's png thumbnail and records it in the plotthumbs set, so listings only link
+// thumbnails that were rendered
+func SaveThumbnail(redisCli *redis.Client, r2Cli *s3.Client, ctx context.Context, plotId *PlotId, img []byte) error {
+
+	if err := utils.PutObjectR2(r2Cli, ctx, config.CF_PLOT_BUCKET, plotId.ToString()+".png", bytes.NewReader(img), "image/png", nil); err != nil {
+		return err
+	}
+
+	return redisCli.SAdd(ctx, "plotthumbs", plotId.ToString()).Err()
+
+}
+
+func DeleteThumbnail(redisCli *redis.Client, r2Cli *s3.Client, ctx context.Context, plotId *PlotId) error {
+
+	if err := redisCli.SRem(ctx, "plotthumbs", plotId.ToString()).Err(); err != nil {
+		return err
+	}
+
+	return utils.DeleteObjectR2(r2Cli, ctx, config.CF_PLOT_BUCKET, plotId.ToString()+".png")
+
+}
+
+// whether each plot has a rendered thumbnail, in the order of plotIdStrs
+func HasThumbnails(redisCli *redis.Client, ctx context.Context, plotIdStrs []string) ([]bool, error) {
+
+	if len(plotIdStrs) == 0 {
+		return nil, nil
+	}
+	members := make([]any, len(plotIdStrs))
+	for i, plotIdStr := range plotIdStrs {
+		members[i] = plotIdStr
+	}
+
+	return redisCli.SMIsMember(ctx, "plotthumbs", members...).Result()
+
+}
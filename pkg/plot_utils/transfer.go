@@ -0,0 +1,152 @@
+package plotutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"trraformapi/pkg/config"
+	"trraformapi/pkg/schemas"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+var ErrNotPlotOwner = errors.New("sender does not own plot")
+var ErrPlotLimit = errors.New("recipient plot limit reached")
+
+// moves a plot between users, must be called inside a transaction. the sender's free plot slot
+// and version history don't go with the plot, returns the keys of the version objects to delete with
+// DeleteVersionObjects once the transaction commits
+func TransferPlot(mongoDB *mongo.Database, txCtx context.Context, plotId *PlotId, from bson.ObjectID, to bson.ObjectID, kind string) ([]string, error) {
+
+	plotIdStr := plotId.ToString()
+	usersColl := mongoDB.Collection("users")
+
+	// remove from sender
+	res, err := usersColl.UpdateOne(txCtx,
+		bson.M{
+			"_id":     from,
+			"plotIds": plotIdStr,
+		},
+		bson.M{
+			"$pull": bson.M{"plotIds": plotIdStr},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if res.MatchedCount == 0 {
+		return nil, ErrNotPlotOwner
+	}
+	if _, err := usersColl.UpdateOne(txCtx,
+		bson.M{"_id": from, "freePlot": plotIdStr},
+		bson.M{"$set": bson.M{"freePlot": schemas.FREE_PLOT_TRANSFERRED}},
+	); err != nil {
+		return nil, err
+	}
+
+	// add to recipient if under plot limit
+	plotLimitThreshold := fmt.Sprintf("plotIds.%d", config.USER_PLOT_LIMIT-1)
+	var recipient schemas.User
+	err = usersColl.FindOneAndUpdate(txCtx,
+		bson.M{
+			"_id":              to,
+			plotLimitThreshold: bson.M{"$exists": false},
+		},
+		bson.M{
+			"$addToSet": bson.M{"plotIds": plotIdStr},
+		},
+	).Decode(&recipient)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrPlotLimit
+	} else if err != nil {
+		return nil, err
+	}
+
+	// set new owner and the search fields mirrored from them, keep history,
+	// previous owner's collaborators are removed
+	set := PlotOwnerSearchFields(&recipient)
+	set["owner"] = to
+	set["collaborators"] = bson.A{}
+	res, err = mongoDB.Collection("plots").UpdateOne(txCtx,
+		bson.M{"plotId": plotId.Id},
+		bson.M{
+			"$set": set,
+			"$push": bson.M{"transfers": schemas.TransferRecord{
+				From: from,
+				To:   to,
+				Kind: kind,
+				Time: time.Now().UTC(),
+			}},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if res.MatchedCount == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	versionsColl := mongoDB.Collection("plotVersions")
+	cursor, err := versionsColl.Find(txCtx,
+		bson.M{"plotId": plotIdStr},
+		options.Find().SetProjection(bson.M{"key": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var versions []schemas.PlotVersion
+	if err := cursor.All(txCtx, &versions); err != nil {
+		return nil, err
+	}
+	if _, err := versionsColl.DeleteMany(txCtx, bson.M{"plotId": plotIdStr}); err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, len(versions))
+	for i := range versions {
+		keys[i] = versions[i].Key
+	}
+
+	return keys, nil
+
+}
+
+// one pending transfer per plot
+func EnsureTransferIndexes(mongoDB *mongo.Database, ctx context.Context) error {
+
+	// expired transfers would collide with new ones
+	if err := ExpireTransfers(mongoDB, ctx, ""); err != nil {
+		return err
+	}
+
+	_, err := mongoDB.Collection("transfers").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "plotId", Value: 1}},
+		Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{
+			"status": schemas.TRANSFER_PENDING,
+		}),
+	})
+
+	return err
+
+}
+
+// marks a plot's pending transfers past their expiry as expired, every plot's if plotIdStr is empty
+func ExpireTransfers(mongoDB *mongo.Database, ctx context.Context, plotIdStr string) error {
+
+	filter := bson.M{
+		"status":    schemas.TRANSFER_PENDING,
+		"expiresAt": bson.M{"$lte": time.Now().UTC()},
+	}
+	if plotIdStr != "" {
+		filter["plotId"] = plotIdStr
+	}
+	_, err := mongoDB.Collection("transfers").UpdateMany(ctx, filter,
+		bson.M{"$set": bson.M{"status": schemas.TRANSFER_EXPIRED}},
+	)
+
+	return err
+
+}
@@ -0,0 +1,60 @@
+package plotutils
+
+import (
+	"context"
+	"trraformapi/pkg/config"
+	"trraformapi/pkg/schemas"
+	"trraformapi/pkg/utils"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// delete all but the newest n versions of a plot
+func PruneVersions(mongoDB *mongo.Database, r2Cli *s3.Client, ctx context.Context, plotIdStr string, n int) error {
+
+	return pruneVersions(mongoDB, r2Cli, ctx, bson.M{"plotId": plotIdStr}, n)
+
+}
+
+func pruneVersions(mongoDB *mongo.Database, r2Cli *s3.Client, ctx context.Context, filter bson.M, n int) error {
+
+	versionsColl := mongoDB.Collection("plotVersions")
+	cursor, err := versionsColl.Find(ctx, filter,
+		options.Find().SetSort(bson.M{"_id": -1}).SetSkip(int64(n)).SetProjection(bson.M{"key": 1}),
+	)
+	if err != nil {
+		return err
+	}
+	var expired []schemas.PlotVersion
+	if err := cursor.All(ctx, &expired); err != nil {
+		return err
+	}
+
+	for _, v := range expired {
+		if err := utils.DeleteObjectR2(r2Cli, ctx, config.CF_VERSION_BUCKET, v.Key); err != nil {
+			return err
+		}
+		if _, err := versionsColl.DeleteOne(ctx, bson.M{"_id": v.Id}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+
+}
+
+// deletes version objects whose documents were removed
+func DeleteVersionObjects(r2Cli *s3.Client, ctx context.Context, keys []string) error {
+
+	for _, key := range keys {
+		if err := utils.DeleteObjectR2(r2Cli, ctx, config.CF_VERSION_BUCKET, key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+
+}
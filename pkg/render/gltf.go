@@ -0,0 +1,118 @@
+package render
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+)
+
+// writes a greedy mesh as a binary gltf (.glb) with one primitive and vertex colors
+func WriteGLB(w io.Writer, quads []Quad) error {
+
+	n := len(quads) * 4
+	positions := make([]float32, 0, n*3)
+	normals := make([]float32, 0, n*3)
+	colors := make([]float32, 0, n*3)
+	indices := make([]uint32, 0, len(quads)*6)
+
+	minPos := [3]float32{math.MaxFloat32, math.MaxFloat32, math.MaxFloat32}
+	maxPos := [3]float32{-math.MaxFloat32, -math.MaxFloat32, -math.MaxFloat32}
+
+	for i, q := range quads {
+		// gltf vertex colors are linear
+		rgb := [3]float32{srgbToLinear(q.Color.R), srgbToLinear(q.Color.G), srgbToLinear(q.Color.B)}
+		for _, c := range q.Corners {
+			positions = append(positions, c[:]...)
+			normals = append(normals, q.Normal[:]...)
+			colors = append(colors, rgb[:]...)
+			for k := range 3 {
+				minPos[k], maxPos[k] = min(minPos[k], c[k]), max(maxPos[k], c[k])
+			}
+		}
+		base := uint32(4 * i)
+		indices = append(indices, base, base+1, base+2, base, base+2, base+3)
+	}
+	if n == 0 {
+		minPos, maxPos = [3]float32{}, [3]float32{}
+	}
+
+	// binary chunk holds every attribute back to back, each 4 byte aligned
+	var bin bytes.Buffer
+	views := []map[string]any{}
+	addView := func(data any, target int) int {
+		offset := bin.Len()
+		binary.Write(&bin, binary.LittleEndian, data)
+		views = append(views, map[string]any{"buffer": 0, "byteOffset": offset, "byteLength": bin.Len() - offset, "target": target})
+		return len(views) - 1
+	}
+	const arrayBuffer, elementArrayBuffer = 34962, 34963
+	const float, unsignedInt = 5126, 5125
+
+	posView := addView(positions, arrayBuffer)
+	normView := addView(normals, arrayBuffer)
+	colView := addView(colors, arrayBuffer)
+	idxView := addView(indices, elementArrayBuffer)
+
+	doc := map[string]any{
+		"asset":  map[string]any{"version": "2.0", "generator": "trraform"},
+		"scene":  0,
+		"scenes": []any{map[string]any{"nodes": []int{0}}},
+		"nodes":  []any{map[string]any{"mesh": 0, "name": "plot"}},
+		"meshes": []any{map[string]any{
+			"primitives": []any{map[string]any{
+				"attributes": map[string]int{"POSITION": 0, "NORMAL": 1, "COLOR_0": 2},
+				"indices":    3,
+				"material":   0,
+				"mode":       4,
+			}},
+		}},
+		"materials": []any{map[string]any{
+			"pbrMetallicRoughness": map[string]any{"baseColorFactor": []float32{1, 1, 1, 1}, "metallicFactor": 0, "roughnessFactor": 1},
+		}},
+		"accessors": []any{
+			map[string]any{"bufferView": posView, "componentType": float, "count": n, "type": "VEC3", "min": minPos, "max": maxPos},
+			map[string]any{"bufferView": normView, "componentType": float, "count": n, "type": "VEC3"},
+			map[string]any{"bufferView": colView, "componentType": float, "count": n, "type": "VEC3"},
+			map[string]any{"bufferView": idxView, "componentType": unsignedInt, "count": len(indices), "type": "SCALAR"},
+		},
+		"bufferViews": views,
+		"buffers":     []any{map[string]any{"byteLength": bin.Len()}},
+	}
+
+	jsonBytes, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	// json chunk is padded with spaces, binary chunk with zeros
+	for len(jsonBytes)%4 != 0 {
+		jsonBytes = append(jsonBytes, ' ')
+	}
+
+	header := []uint32{
+		0x46546C67, 2, uint32(12 + 8 + len(jsonBytes) + 8 + bin.Len()), // glTF, version, total length
+		uint32(len(jsonBytes)), 0x4E4F534A, // JSON chunk
+	}
+	if err := binary.Write(w, binary.LittleEndian, header); err != nil {
+		return err
+	}
+	if _, err := w.Write(jsonBytes); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, []uint32{uint32(bin.Len()), 0x004E4942}); err != nil { // BIN chunk
+		return err
+	}
+	_, err = w.Write(bin.Bytes())
+
+	return err
+
+}
+
+func srgbToLinear(c uint8) float32 {
+	v := float64(c) / 255
+	if v <= 0.04045 {
+		return float32(v / 12.92)
+	}
+	return float32(math.Pow((v+0.055)/1.055, 2.4))
+}
@@ -0,0 +1,156 @@
+package render
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"slices"
+	"trraformapi/pkg/config"
+	plotutils "trraformapi/pkg/plot_utils"
+)
+
+var background = color.RGBA{24, 24, 27, 255}
+
+// brightness of the top, +x and +z faces
+const (
+	shadeTop   = 1.0
+	shadeRight = 0.78
+	shadeLeft  = 0.62
+)
+
+type point struct{ x, y float64 }
+
+// renders build data as a size x size isometric image, looking down at the +x, +y and +z faces.
+// subplot markers and empty voxels aren't drawn
+func Isometric(buildData []uint16, size int) (*image.RGBA, error) {
+
+	if !PaletteLoaded() {
+		return nil, ErrNoPalette
+	}
+
+	voxels, err := plotutils.ExpandBuildData(buildData)
+	if err != nil {
+		return nil, err
+	}
+	bs := int(buildData[1])
+
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	for i := 0; i < len(img.Pix); i += 4 {
+		copy(img.Pix[i:], []uint8{background.R, background.G, background.B, background.A})
+	}
+	if bs == 0 {
+		return img, nil
+	}
+
+	filled := func(x, y, z int) bool {
+		if x < 0 || y < 0 || z < 0 || x >= bs || y >= bs || z >= bs {
+			return false
+		}
+		return voxels[x+z*bs+y*bs*bs] > config.SUBPLOT_COUNT
+	}
+
+	// projected build spans 2*bs tile halves horizontally and 2*bs vertically, keep a margin
+	tile := float64(size) * 0.9 / float64(2*bs)
+	origin := point{float64(size) / 2, float64(size)*0.05 + float64(bs)*tile}
+	project := func(x, y, z float64) point {
+		return point{
+			origin.x + (x-z)*tile,
+			origin.y + (x+z)*tile/2 - y*tile,
+		}
+	}
+
+	// painter's algorithm, voxels further from the camera first
+	type voxel struct{ x, y, z int }
+	var visible []voxel
+	for y := range bs {
+		for z := range bs {
+			for x := range bs {
+				if !filled(x, y, z) {
+					continue
+				}
+				if !filled(x+1, y, z) || !filled(x, y+1, z) || !filled(x, y, z+1) {
+					visible = append(visible, voxel{x, y, z})
+				}
+			}
+		}
+	}
+	slices.SortStableFunc(visible, func(a, b voxel) int {
+		return (a.x + a.y + a.z) - (b.x + b.y + b.z)
+	})
+
+	for _, v := range visible {
+		c := Color(voxels[v.x+v.z*bs+v.y*bs*bs])
+		x, y, z := float64(v.x), float64(v.y), float64(v.z)
+		if !filled(v.x, v.y+1, v.z) {
+			fillQuad(img, [4]point{project(x, y+1, z), project(x+1, y+1, z), project(x+1, y+1, z+1), project(x, y+1, z+1)}, shade(c, shadeTop))
+		}
+		if !filled(v.x+1, v.y, v.z) {
+			fillQuad(img, [4]point{project(x+1, y, z), project(x+1, y+1, z), project(x+1, y+1, z+1), project(x+1, y, z+1)}, shade(c, shadeRight))
+		}
+		if !filled(v.x, v.y, v.z+1) {
+			fillQuad(img, [4]point{project(x, y, z+1), project(x+1, y, z+1), project(x+1, y+1, z+1), project(x, y+1, z+1)}, shade(c, shadeLeft))
+		}
+	}
+
+	return img, nil
+
+}
+
+// renders build data as an encoded png
+func IsometricPNG(buildData []uint16, size int) ([]byte, error) {
+
+	img, err := Isometric(buildData, size)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+
+}
+
+func shade(c color.RGBA, f float64) color.RGBA {
+	return color.RGBA{uint8(float64(c.R) * f), uint8(float64(c.G) * f), uint8(float64(c.B) * f), 255}
+}
+
+// scanline fill of a convex quad, pixel centers inside or on the edge are filled
+func fillQuad(img *image.RGBA, pts [4]point, c color.RGBA) {
+
+	minY, maxY := math.Inf(1), math.Inf(-1)
+	for _, p := range pts {
+		minY, maxY = min(minY, p.y), max(maxY, p.y)
+	}
+
+	bounds := img.Bounds()
+	y0 := max(int(math.Ceil(minY-0.5)), bounds.Min.Y)
+	y1 := min(int(math.Floor(maxY-0.5)), bounds.Max.Y-1)
+
+	for py := y0; py <= y1; py++ {
+		sy := float64(py) + 0.5
+		left, right := math.Inf(1), math.Inf(-1)
+		for i := range pts {
+			a, b := pts[i], pts[(i+1)%len(pts)]
+			if (a.y > sy) == (b.y > sy) && a.y != sy {
+				continue
+			}
+			if a.y == b.y {
+				left, right = min(left, a.x, b.x), max(right, a.x, b.x)
+				continue
+			}
+			x := a.x + (sy-a.y)*(b.x-a.x)/(b.y-a.y)
+			left, right = min(left, x), max(right, x)
+		}
+		x0 := max(int(math.Ceil(left-0.5)), bounds.Min.X)
+		x1 := min(int(math.Ceil(right-0.5)), bounds.Max.X)
+		for px := x0; px < x1; px++ {
+			img.SetRGBA(px, py, c)
+		}
+	}
+
+}
@@ -0,0 +1,127 @@
+package render
+
+import (
+	"image/color"
+	"trraformapi/pkg/config"
+	plotutils "trraformapi/pkg/plot_utils"
+)
+
+// one flat shaded quad of a mesh, corners counter-clockwise seen from the front
+type Quad struct {
+	Corners [4][3]float32
+	Normal  [3]float32
+	Color   color.RGBA
+}
+
+// greedy meshes build data: visible faces of the same color in the same plane are merged into
+// as few rectangles as possible. positions are in voxel units, y up, subplot markers are skipped
+func GreedyMesh(buildData []uint16) ([]Quad, error) {
+
+	if !PaletteLoaded() {
+		return nil, ErrNoPalette
+	}
+
+	voxels, err := plotutils.ExpandBuildData(buildData)
+	if err != nil {
+		return nil, err
+	}
+	bs := int(buildData[1])
+
+	at := func(p [3]int) uint16 {
+		for _, c := range p {
+			if c < 0 || c >= bs {
+				return 0
+			}
+		}
+		v := voxels[p[0]+p[2]*bs+p[1]*bs*bs]
+		if v <= config.SUBPLOT_COUNT {
+			return 0
+		}
+		return v
+	}
+
+	var quads []Quad
+	mask := make([]uint16, bs*bs)
+
+	for d := range 3 {
+		u, v := (d+1)%3, (d+2)%3
+		for _, side := range []int{-1, 1} {
+			var normal [3]float32
+			normal[d] = float32(side)
+
+			for i := range bs {
+				// faces of slice i that face an empty neighbor
+				var p [3]int
+				p[d] = i
+				for b := range bs {
+					for a := range bs {
+						p[u], p[v] = a, b
+						mask[a+b*bs] = 0
+						if c := at(p); c != 0 {
+							q := p
+							q[d] += side
+							if at(q) == 0 {
+								mask[a+b*bs] = c
+							}
+						}
+					}
+				}
+
+				// merge runs along u, then grow them along v
+				for b := range bs {
+					for a := 0; a < bs; {
+						c := mask[a+b*bs]
+						if c == 0 {
+							a++
+							continue
+						}
+						w := 1
+						for a+w < bs && mask[a+w+b*bs] == c {
+							w++
+						}
+						h := 1
+					grow:
+						for b+h < bs {
+							for k := range w {
+								if mask[a+k+(b+h)*bs] != c {
+									break grow
+								}
+							}
+							h++
+						}
+						for hb := range h {
+							for k := range w {
+								mask[a+k+(b+hb)*bs] = 0
+							}
+						}
+
+						plane := float32(i)
+						if side > 0 {
+							plane++
+						}
+						corner := func(du, dv int) [3]float32 {
+							var pos [3]float32
+							pos[d], pos[u], pos[v] = plane, float32(a+du), float32(b+dv)
+							return pos
+						}
+						quad := Quad{
+							Corners: [4][3]float32{corner(0, 0), corner(w, 0), corner(w, h), corner(0, h)},
+							Normal:  normal,
+							Color:   Color(c),
+						}
+						// u x v points along +d, flip winding for faces pointing along -d
+						if side < 0 {
+							quad.Corners[1], quad.Corners[3] = quad.Corners[3], quad.Corners[1]
+						}
+						quads = append(quads, quad)
+
+						a += w
+					}
+				}
+			}
+		}
+	}
+
+	return quads, nil
+
+}
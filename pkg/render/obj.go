@@ -0,0 +1,31 @@
+package render
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// writes a greedy mesh as wavefront obj, colors are written as vertex colors (v x y z r g b)
+func WriteOBJ(w io.Writer, quads []Quad) error {
+
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "# trraform plot\no plot\n")
+	for _, q := range quads {
+		r, g, b := float32(q.Color.R)/255, float32(q.Color.G)/255, float32(q.Color.B)/255
+		for _, c := range q.Corners {
+			fmt.Fprintf(bw, "v %g %g %g %.4f %.4f %.4f\n", c[0], c[1], c[2], r, g, b)
+		}
+	}
+	for _, q := range quads {
+		fmt.Fprintf(bw, "vn %g %g %g\n", q.Normal[0], q.Normal[1], q.Normal[2])
+	}
+	for i := range quads {
+		v, n := 4*i+1, i+1
+		fmt.Fprintf(bw, "f %d//%d %d//%d %d//%d %d//%d\n", v, n, v+1, n, v+2, n, v+3, n)
+	}
+
+	return bw.Flush()
+
+}
@@ -0,0 +1,90 @@
+package render
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"math"
+	"os"
+	"sync/atomic"
+	"trraformapi/pkg/config"
+)
+
+// the client's palette table: rgb byte triples for every color index from 0 to MAX_COLOR_IDX.
+// indexes up to SUBPLOT_COUNT are subplot markers, their entries are ignored.
+// exported from the client and deployed next to the api, renders are disabled until it is
+const PALETTE_FILE = "static/palette.dat"
+
+const paletteStart = config.SUBPLOT_COUNT + 1
+
+// thumbnails, exports and imports need the real palette, colors are never guessed
+var ErrNoPalette = errors.New("color palette isn't loaded")
+
+var palette atomic.Pointer[[]color.RGBA]
+
+func LoadPaletteFile(path string) error {
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("in LoadPaletteFile: %w", err)
+	}
+
+	return LoadPalette(data)
+
+}
+
+func LoadPalette(data []byte) error {
+
+	if len(data) != 3*(config.MAX_COLOR_IDX+1) {
+		return fmt.Errorf("in LoadPalette: got %d bytes, want %d", len(data), 3*(config.MAX_COLOR_IDX+1))
+	}
+
+	colors := make([]color.RGBA, config.MAX_COLOR_IDX+1)
+	for i := paletteStart; i <= config.MAX_COLOR_IDX; i++ {
+		colors[i] = color.RGBA{data[3*i], data[3*i+1], data[3*i+2], 255}
+	}
+	palette.Store(&colors)
+
+	return nil
+
+}
+
+func PaletteLoaded() bool {
+	return palette.Load() != nil
+}
+
+// transparent for empty voxels, subplot markers, out of range indexes and before the palette is loaded
+func Color(idx uint16) color.RGBA {
+
+	colors := palette.Load()
+	if colors == nil || idx < paletteStart || idx > config.MAX_COLOR_IDX {
+		return color.RGBA{}
+	}
+
+	return (*colors)[idx]
+
+}
+
+// nearest color index to c by squared rgb distance, ties go to the lower index
+func NearestColor(c color.RGBA) (uint16, error) {
+
+	colors := palette.Load()
+	if colors == nil {
+		return 0, ErrNoPalette
+	}
+
+	best, bestDist := uint16(paletteStart), math.MaxInt
+	for i := paletteStart; i <= config.MAX_COLOR_IDX; i++ {
+		p := (*colors)[i]
+		dr, dg, db := int(p.R)-int(c.R), int(p.G)-int(c.G), int(p.B)-int(c.B)
+		if d := dr*dr + dg*dg + db*db; d < bestDist {
+			best, bestDist = uint16(i), d
+			if d == 0 {
+				break
+			}
+		}
+	}
+
+	return best, nil
+
+}
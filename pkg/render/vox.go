@@ -0,0 +1,294 @@
+package render
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"fmt"
+	"image/color"
+	"io"
+	"slices"
+	"trraformapi/pkg/config"
+	plotutils "trraformapi/pkg/plot_utils"
+)
+
+// MagicaVoxel palettes hold 255 colors (index 0 is empty)
+const voxPaletteSize = 255
+
+// writes build data as a MagicaVoxel .vox model. vox is z up, so build y becomes vox z.
+// builds with more than 255 colors keep the most used ones and map the rest to the nearest kept color
+func WriteVOX(w io.Writer, buildData []uint16) error {
+
+	if !PaletteLoaded() {
+		return ErrNoPalette
+	}
+
+	voxels, err := plotutils.ExpandBuildData(buildData)
+	if err != nil {
+		return err
+	}
+	bs := int(buildData[1])
+
+	// most used colors first
+	counts := map[uint16]int{}
+	for _, v := range voxels {
+		if v > config.SUBPLOT_COUNT {
+			counts[v]++
+		}
+	}
+	used := make([]uint16, 0, len(counts))
+	for v := range counts {
+		used = append(used, v)
+	}
+	slices.SortFunc(used, func(a, b uint16) int {
+		return cmp.Or(counts[b]-counts[a], int(a)-int(b))
+	})
+
+	palette := used[:min(len(used), voxPaletteSize)]
+	voxIdx := make(map[uint16]uint8, len(used))
+	for i, v := range palette {
+		voxIdx[v] = uint8(i + 1)
+	}
+	for _, v := range used[len(palette):] {
+		voxIdx[v] = nearest(Color(v), palette) + 1
+	}
+
+	var xyzi bytes.Buffer
+	count := 0
+	for y := range bs {
+		for z := range bs {
+			for x := range bs {
+				v := voxels[x+z*bs+y*bs*bs]
+				if v <= config.SUBPLOT_COUNT {
+					continue
+				}
+				xyzi.Write([]byte{uint8(x), uint8(z), uint8(y), voxIdx[v]})
+				count++
+			}
+		}
+	}
+
+	rgba := make([]byte, 4*256)
+	for i, v := range palette {
+		c := Color(v)
+		copy(rgba[4*i:], []byte{c.R, c.G, c.B, 255})
+	}
+
+	size := make([]byte, 12)
+	for i := range 3 {
+		binary.LittleEndian.PutUint32(size[4*i:], uint32(bs))
+	}
+
+	var children bytes.Buffer
+	writeVoxChunk(&children, "SIZE", size, nil)
+	writeVoxChunk(&children, "XYZI", append(binary.LittleEndian.AppendUint32(nil, uint32(count)), xyzi.Bytes()...), nil)
+	writeVoxChunk(&children, "RGBA", rgba, nil)
+
+	var out bytes.Buffer
+	out.WriteString("VOX ")
+	binary.Write(&out, binary.LittleEndian, uint32(150))
+	writeVoxChunk(&out, "MAIN", nil, children.Bytes())
+
+	_, err = w.Write(out.Bytes())
+	return err
+
+}
+
+func writeVoxChunk(buf *bytes.Buffer, id string, content []byte, children []byte) {
+	buf.WriteString(id)
+	binary.Write(buf, binary.LittleEndian, uint32(len(content)))
+	binary.Write(buf, binary.LittleEndian, uint32(len(children)))
+	buf.Write(content)
+	buf.Write(children)
+}
+
+// position in palette of the color closest to c
+func nearest(c color.RGBA, palette []uint16) uint8 {
+
+	best, bestDist := 0, -1
+	for i, v := range palette {
+		p := Color(v)
+		dr, dg, db := int(c.R)-int(p.R), int(c.G)-int(p.G), int(c.B)-int(p.B)
+		if dist := dr*dr + dg*dg + db*db; bestDist < 0 || dist < bestDist {
+			best, bestDist = i, dist
+		}
+	}
+
+	return uint8(best)
+
+}
+
+type VoxModel struct {
+	Size    [3]int // x, y, z with z up
+	Voxels  [][4]uint8
+	Palette [256]color.RGBA // entry i is vox color index i, entry 0 unused
+}
+
+// parses the first model of a MagicaVoxel .vox file, other models and scene chunks are ignored
+func ReadVOX(data []byte) (*VoxModel, error) {
+
+	if len(data) < 8 || string(data[:4]) != "VOX " {
+		return nil, fmt.Errorf("in ReadVOX: not a vox file")
+	}
+	buf := data[8:]
+
+	// MAIN chunk, its children hold everything
+	id, _, children, _, err := readVoxChunk(buf)
+	if err != nil {
+		return nil, err
+	}
+	if id != "MAIN" {
+		return nil, fmt.Errorf("in ReadVOX: missing MAIN chunk")
+	}
+
+	model := VoxModel{Palette: defaultVoxPalette()}
+	hasSize, hasVoxels := false, false
+	for len(children) > 0 {
+		var content []byte
+		id, content, _, children, err = readVoxChunk(children)
+		if err != nil {
+			return nil, err
+		}
+
+		switch id {
+		case "SIZE":
+			if hasSize {
+				continue
+			}
+			if len(content) < 12 {
+				return nil, fmt.Errorf("in ReadVOX: invalid SIZE chunk")
+			}
+			for i := range 3 {
+				model.Size[i] = int(binary.LittleEndian.Uint32(content[4*i:]))
+			}
+			hasSize = true
+		case "XYZI":
+			if hasVoxels {
+				continue
+			}
+			if len(content) < 4 {
+				return nil, fmt.Errorf("in ReadVOX: invalid XYZI chunk")
+			}
+			n := int(binary.LittleEndian.Uint32(content))
+			if n < 0 || n > (len(content)-4)/4 {
+				return nil, fmt.Errorf("in ReadVOX: invalid XYZI chunk")
+			}
+			model.Voxels = make([][4]uint8, n)
+			for i := range model.Voxels {
+				copy(model.Voxels[i][:], content[4+4*i:])
+			}
+			hasVoxels = true
+		case "RGBA":
+			if len(content) < 4*256 {
+				return nil, fmt.Errorf("in ReadVOX: invalid RGBA chunk")
+			}
+			// file entry i is color index i+1
+			for i := range 255 {
+				model.Palette[i+1] = color.RGBA{content[4*i], content[4*i+1], content[4*i+2], content[4*i+3]}
+			}
+		}
+	}
+	if !hasSize || !hasVoxels {
+		return nil, fmt.Errorf("in ReadVOX: missing model")
+	}
+
+	return &model, nil
+
+}
+
+func readVoxChunk(buf []byte) (id string, content []byte, children []byte, rest []byte, err error) {
+
+	if len(buf) < 12 {
+		return "", nil, nil, nil, fmt.Errorf("in ReadVOX: truncated chunk header")
+	}
+	id = string(buf[:4])
+	contentLen := uint64(binary.LittleEndian.Uint32(buf[4:]))
+	childrenLen := uint64(binary.LittleEndian.Uint32(buf[8:]))
+	buf = buf[12:]
+	if contentLen+childrenLen > uint64(len(buf)) {
+		return "", nil, nil, nil, fmt.Errorf("in ReadVOX: truncated %s chunk", id)
+	}
+
+	return id, buf[:contentLen], buf[contentLen : contentLen+childrenLen], buf[contentLen+childrenLen:], nil
+
+}
+
+// palette used by files without an RGBA chunk: a 6 level color cube (blue fastest, black
+// left out) followed by 10 step red, green, blue and gray ramps
+func defaultVoxPalette() [256]color.RGBA {
+
+	var palette [256]color.RGBA
+	i := 1
+
+	levels := []uint8{0xff, 0xcc, 0x99, 0x66, 0x33, 0x00}
+	for _, r := range levels {
+		for _, g := range levels {
+			for _, b := range levels {
+				if r == 0 && g == 0 && b == 0 {
+					continue
+				}
+				palette[i] = color.RGBA{r, g, b, 255}
+				i++
+			}
+		}
+	}
+
+	ramp := []uint8{0xee, 0xdd, 0xbb, 0xaa, 0x88, 0x77, 0x55, 0x44, 0x22, 0x11}
+	for channel := range 4 {
+		for _, v := range ramp {
+			c := color.RGBA{A: 255}
+			switch channel {
+			case 0:
+				c.R = v
+			case 1:
+				c.G = v
+			case 2:
+				c.B = v
+			default:
+				c.R, c.G, c.B = v, v, v
+			}
+			palette[i] = c
+			i++
+		}
+	}
+
+	return palette
+
+}
+
+// converts the model to build data no larger than maxBuildSize. colors are quantized to the
+// nearest palette index, models too large are cropped around their horizontal center keeping
+// the bottom, smaller models are centered horizontally and placed on the ground
+func (m *VoxModel) BuildData(maxBuildSize int) ([]uint16, error) {
+
+	if !PaletteLoaded() {
+		return nil, ErrNoPalette
+	}
+
+	bs := max(m.Size[0], m.Size[1], m.Size[2], config.MIN_BUILD_SIZE)
+	bs = min(bs, maxBuildSize)
+
+	// vox x, y, z (z up) to build x, z, y (y up)
+	offX := (bs - m.Size[0]) / 2
+	offZ := (bs - m.Size[1]) / 2
+
+	var colors [256]uint16
+	voxels := make([]uint16, bs*bs*bs)
+	for _, v := range m.Voxels {
+		x, z, y, i := int(v[0])+offX, int(v[1])+offZ, int(v[2]), v[3]
+		if i == 0 || x < 0 || x >= bs || y >= bs || z < 0 || z >= bs {
+			continue
+		}
+		if colors[i] == 0 {
+			c, err := NearestColor(m.Palette[i])
+			if err != nil {
+				return nil, err
+			}
+			colors[i] = c
+		}
+		voxels[x+z*bs+y*bs*bs] = colors[i]
+	}
+
+	return plotutils.CompressBuildData(0, bs, voxels), nil
+
+}
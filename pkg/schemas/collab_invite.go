@@ -0,0 +1,25 @@
+package schemas
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const (
+	COLLAB_INVITE_PENDING  = "pending"
+	COLLAB_INVITE_ACCEPTED = "accepted"
+	COLLAB_INVITE_DECLINED = "declined"
+	COLLAB_INVITE_CANCELED = "canceled" // plot was released before the invite was answered
+)
+
+type CollabInvite struct {
+	Id        bson.ObjectID `bson:"_id,omitempty"`
+	PlotId    string        `bson:"plotId"`
+	From      bson.ObjectID `bson:"from"`
+	To        bson.ObjectID `bson:"to"`
+	Role      string        `bson:"role"`
+	Status    string        `bson:"status"`
+	Ctime     time.Time     `bson:"ctime"`
+	ExpiresAt time.Time     `bson:"expiresAt"`
+}
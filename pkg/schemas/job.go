@@ -0,0 +1,22 @@
+package schemas
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// rewrites owner metadata for every plot owned by a user
+type OwnerSyncJob struct {
+	Id         bson.ObjectID `bson:"_id,omitempty"`
+	Uid        bson.ObjectID `bson:"uid"`
+	Status     string        `bson:"status"`
+	Gen        int           `bson:"gen"`
+	After      string        `bson:"after"` // last plot id synced, plots are synced in order
+	Done       int           `bson:"done"`
+	Total      int           `bson:"total"`
+	Error      string        `bson:"error"`
+	Ctime      time.Time     `bson:"ctime"`
+	Mtime      time.Time     `bson:"mtime"`
+	LeaseUntil time.Time     `bson:"leaseUntil"`
+}
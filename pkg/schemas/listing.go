@@ -0,0 +1,29 @@
+package schemas
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const (
+	LISTING_ACTIVE   = "active"
+	LISTING_PENDING  = "pending"
+	LISTING_SOLD     = "sold"
+	LISTING_CANCELED = "canceled"
+)
+
+type Listing struct {
+	Id            bson.ObjectID `bson:"_id,omitempty"`
+	PlotId        string        `bson:"plotId"`
+	Depth         int           `bson:"depth"`
+	Seller        bson.ObjectID `bson:"seller"`
+	Price         int64         `bson:"price"` // cents
+	Status        string        `bson:"status"`
+	Ctime         time.Time     `bson:"ctime"`
+	Buyer         bson.ObjectID `bson:"buyer,omitempty"`
+	LockOwner     string        `bson:"lockOwner,omitempty"`
+	StripeSession string        `bson:"stripeSession,omitempty"`
+	PendingUntil  time.Time     `bson:"pendingUntil,omitempty"`
+	SoldAt        time.Time     `bson:"soldAt,omitempty"`
+}
@@ -6,10 +6,57 @@ import (
 	"go.mongodb.org/mongo-driver/v2/bson"
 )
 
+const (
+	PLOT_SOURCE_FREE     = "free"
+	PLOT_SOURCE_CREDIT   = "credit"
+	PLOT_SOURCE_PURCHASE = "purchase"
+)
+
+const (
+	COLLAB_OWNER  = "owner"
+	COLLAB_EDITOR = "editor"
+	COLLAB_VIEWER = "viewer"
+)
+
+type TransferRecord struct {
+	From bson.ObjectID `bson:"from"`
+	To   bson.ObjectID `bson:"to"`
+	Kind string        `bson:"kind"`
+	Time time.Time     `bson:"time"`
+}
+
+type Collaborator struct {
+	Uid     bson.ObjectID `bson:"uid"`
+	Role    string        `bson:"role"`
+	AddedAt time.Time     `bson:"addedAt"`
+}
+
+// what the parent owner allows for subplots placed in their build
+type SubplotSettings struct {
+	Reserved        []int           `bson:"reserved,omitempty"` // slots only the parent owner can claim
+	RequireApproval bool            `bson:"requireApproval"`
+	Approved        []bson.ObjectID `bson:"approved,omitempty"` // users who may claim when approval is required
+	Price           int64           `bson:"price,omitempty"`    // cents, 0 uses the depth price
+}
+
 type Plot struct {
-	Id     bson.ObjectID `bson:"_id,omitempty"`
-	PlotId uint64        `bson:"plotId"`
-	Ctime  time.Time     `bson:"ctime"`
-	Owner  bson.ObjectID `bson:"owner"`
-	Votes  int           `bson:"votes"`
+	Id              bson.ObjectID    `bson:"_id,omitempty"`
+	PlotId          uint64           `bson:"plotId"`
+	Ctime           time.Time        `bson:"ctime"`
+	Owner           bson.ObjectID    `bson:"owner"`
+	Source          string           `bson:"source,omitempty"` // how the plot was claimed, empty for plots claimed before it was recorded
+	Votes           int              `bson:"votes"`
+	Revision        int64            `bson:"revision"`
+	ETag            string           `bson:"etag,omitempty"`
+	Name            string           `bson:"name,omitempty"`
+	Description     string           `bson:"description,omitempty"`
+	LinkTitle       string           `bson:"linkTitle,omitempty"`
+	OwnerName       string           `bson:"ownerName,omitempty"`
+	Depth           int              `bson:"depth"`
+	Verified        bool             `bson:"verified"`
+	Hidden          bool             `bson:"hidden"`
+	Subplots        []int            `bson:"subplots"` // slots placed in the current build, nil if not yet recorded
+	SubplotSettings SubplotSettings  `bson:"subplotSettings"`
+	Collaborators   []Collaborator   `bson:"collaborators,omitempty"`
+	Transfers       []TransferRecord `bson:"transfers,omitempty"`
 }
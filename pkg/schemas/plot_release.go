@@ -0,0 +1,19 @@
+package schemas
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// record of a released plot, the plots document is deleted on release so its history is kept here
+type PlotRelease struct {
+	Id        bson.ObjectID    `bson:"_id,omitempty"`
+	PlotId    string           `bson:"plotId"`
+	Uid       bson.ObjectID    `bson:"uid"`
+	Source    string           `bson:"source,omitempty"`
+	Refund    string           `bson:"refund"`
+	Transfers []TransferRecord `bson:"transfers,omitempty"`
+	Ctime     time.Time        `bson:"ctime"`
+	Cleaned   bool             `bson:"cleaned"` // plot data deleted and the plot reopened, see plotutils.CleanReleasedPlot
+}
@@ -0,0 +1,18 @@
+package schemas
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type PlotVersion struct {
+	Id         bson.ObjectID `bson:"_id,omitempty"`
+	PlotId     string        `bson:"plotId"`
+	Key        string        `bson:"key"`
+	Revision   int64         `bson:"revision"`
+	Ctime      time.Time     `bson:"ctime"`
+	Size       int           `bson:"size"`
+	BlockCount int           `bson:"blockCount"`
+	Author     bson.ObjectID `bson:"author"`
+}
@@ -0,0 +1,24 @@
+package schemas
+
+import "time"
+
+const (
+	STRIPE_EVENT_PROCESSING = "processing"
+	STRIPE_EVENT_PROCESSED  = "processed"
+	STRIPE_EVENT_FAILED     = "failed"
+)
+
+// ledger entry for a stripe webhook event, keyed by the stripe event id
+type StripeEvent struct {
+	Id         string    `bson:"_id"`
+	Type       string    `bson:"type"`
+	Object     string    `bson:"object"`  // id of the object the event is about, events for it are ordered by Created
+	Created    time.Time `bson:"created"` // when stripe created the event
+	ReceivedAt time.Time `bson:"receivedAt"`
+	Mtime      time.Time `bson:"mtime"`
+	Status     string    `bson:"status"`
+	Error      string    `bson:"error"`
+	Attempts   int       `bson:"attempts"`
+	LeaseUntil time.Time `bson:"leaseUntil"`
+	Payload    []byte    `bson:"payload"` // raw event, for re-driving
+}
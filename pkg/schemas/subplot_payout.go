@@ -0,0 +1,27 @@
+package schemas
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const (
+	SUBPLOT_PAYOUT_OWED = "owed"
+	SUBPLOT_PAYOUT_PAID = "paid"
+)
+
+// a parent owner's share of an owner priced subplot, recorded as owed by the claim that sold it
+// and paid by a connect transfer from the checkout's charge
+type SubplotPayout struct {
+	Id            bson.ObjectID `bson:"_id,omitempty"`
+	PlotId        string        `bson:"plotId"`
+	Payee         bson.ObjectID `bson:"payee"`  // parent owner when the subplot was claimed
+	Amount        int64         `bson:"amount"` // cents, after the market fee
+	Session       string        `bson:"session"`
+	PaymentIntent string        `bson:"paymentIntent"`
+	Status        string        `bson:"status"`
+	Transfer      string        `bson:"transfer,omitempty"`
+	Ctime         time.Time     `bson:"ctime"`
+	PaidAt        time.Time     `bson:"paidAt,omitempty"`
+}
@@ -0,0 +1,25 @@
+package schemas
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const (
+	TRANSFER_PENDING  = "pending"
+	TRANSFER_ACCEPTED = "accepted"
+	TRANSFER_DECLINED = "declined"
+	TRANSFER_CANCELED = "canceled"
+	TRANSFER_EXPIRED  = "expired" // pending past expiresAt
+)
+
+type PlotTransfer struct {
+	Id        bson.ObjectID `bson:"_id,omitempty"`
+	PlotId    string        `bson:"plotId"`
+	From      bson.ObjectID `bson:"from"`
+	To        bson.ObjectID `bson:"to"`
+	Status    string        `bson:"status"`
+	Ctime     time.Time     `bson:"ctime"`
+	ExpiresAt time.Time     `bson:"expiresAt"`
+}
@@ -20,6 +20,14 @@ type Subscription struct {
 	Invoices       []string `bson:"invoices"`
 }
 
+type Privacy struct {
+	HidePlots      bool `bson:"hidePlots" json:"hidePlots"`
+	AnonymousOwner bool `bson:"anonymousOwner" json:"anonymousOwner"`
+}
+
+// freePlot of a user whose free plot was transferred away, the free slot stays used
+const FREE_PLOT_TRANSFERRED = "transferred"
+
 type User struct {
 	Id             bson.ObjectID `bson:"_id,omitempty"`
 	Ctime          time.Time     `bson:"ctime"`
@@ -29,8 +37,11 @@ type User struct {
 	GoogleId       string        `bson:"googleId"`
 	Username       string        `bson:"username"`
 	UnameChangedAt time.Time     `bson:"unameChangedAt"`
+	ReleasedAt     time.Time     `bson:"releasedAt,omitempty"`
 	StripeCustomer string        `bson:"stripeCustomer"`
+	StripeConnect  string        `bson:"stripeConnect"`
 	Subscription   Subscription  `bson:"subscription"`
+	Privacy        Privacy       `bson:"privacy"`
 	FreePlot       string        `bson:"freePlot"`
 	PlotCredits    int           `bson:"plotCredits"`
 	PlotIds        []string      `bson:"plotIds"`
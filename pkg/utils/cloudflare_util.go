@@ -13,10 +13,13 @@ import (
 	"trraformapi/pkg/config"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
 
+var ErrPreconditionFailed = errors.New("object precondition failed")
+
 func ValidateTurnstileToken(httpCli *http.Client, ctx context.Context, token string) error {
 
 	formData := url.Values{}
@@ -72,6 +75,44 @@ func HasObjectR2(r2Cli *s3.Client, ctx context.Context, bucket string, key strin
 
 }
 
+// metadata of an object, found is false when the object doesn't exist
+func HeadObjectR2(r2Cli *s3.Client, ctx context.Context, bucket string, key string) (map[string]string, bool, error) {
+
+	result, err := r2Cli.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: &bucket,
+		Key:    &key,
+	})
+
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+
+	return result.Metadata, true, nil
+
+}
+
+// etag of an object, found is false when the object doesn't exist
+func ObjectETagR2(r2Cli *s3.Client, ctx context.Context, bucket string, key string) (string, bool, error) {
+
+	result, err := r2Cli.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: &bucket,
+		Key:    &key,
+	})
+
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+
+	return aws.ToString(result.ETag), true, nil
+
+}
+
 func PutObjectR2(r2Cli *s3.Client, ctx context.Context, bucket string, key string, body io.Reader, contentType string, metadata map[string]string) error {
 
 	_, err := r2Cli.PutObject(ctx, &s3.PutObjectInput{
@@ -89,6 +130,35 @@ func PutObjectR2(r2Cli *s3.Client, ctx context.Context, bucket string, key strin
 
 }
 
+// puts an object only if its current etag matches ifMatch, or only if it doesn't exist yet when ifMatch is empty.
+// returns the new etag
+func PutObjectIfMatchR2(r2Cli *s3.Client, ctx context.Context, bucket string, key string, body io.Reader, contentType string, metadata map[string]string, ifMatch string) (string, error) {
+
+	input := &s3.PutObjectInput{
+		Bucket:      &bucket,
+		Key:         &key,
+		Body:        body,
+		ContentType: aws.String(contentType),
+		Metadata:    metadata,
+	}
+	if ifMatch != "" {
+		input.IfMatch = aws.String(ifMatch)
+	} else {
+		input.IfNoneMatch = aws.String("*")
+	}
+
+	result, err := r2Cli.PutObject(ctx, input)
+	var resErr *awshttp.ResponseError
+	if errors.As(err, &resErr) && resErr.HTTPStatusCode() == http.StatusPreconditionFailed {
+		return "", ErrPreconditionFailed
+	} else if err != nil {
+		return "", err
+	}
+
+	return aws.ToString(result.ETag), nil
+
+}
+
 func UpdateMetadataR2(r2Cli *s3.Client, ctx context.Context, bucket string, key string, contentType string, metadata map[string]string) error {
 
 	_, err := r2Cli.CopyObject(ctx, &s3.CopyObjectInput{
@@ -201,3 +271,17 @@ func PurgeCacheCDN(httpCli *http.Client, ctx context.Context, urls []string) err
 	return nil
 
 }
+
+func DeleteObjectR2(r2Cli *s3.Client, ctx context.Context, bucket string, key string) error {
+
+	_, err := r2Cli.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: &bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+
+}